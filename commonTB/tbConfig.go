@@ -16,14 +16,40 @@
 package common
 
 const DRONE_KEEPALIVE_TIMER = 2 // was 5
-const MAX_NODES = 64            // anything bigger would require bitMap structure upgrades
+const MAX_NODES = 64            // terminals an M3 keeps track of, node ids may be higher
 // Playing field in km
 const X_WIDTH = 100                // 100 km per side
 const Y_WIDTH = 100                // 200 km per side
 const Z_WIDTH = 100                // 100 km per side
+const FIELD_LATITUDE = 0.0         // degrees, the X=0, Y=0 corner of the field, X goes east
+const FIELD_LONGITUDE = 0.0        // degrees, and Y north from there
+const EARTH_RADIUS = 6371.0        // km
 const DEFAULT_VELOCITY = 100       //  km/hour
 const DEFAULT_VELOCITY_SCALE = 100 // 0
 const THREE_DIMENSIONAL = false
 const RANGE_3D = 100 // 170
 const RANGE_2D = 100 // 100
 const GROUND_STATION_ID = 64
+
+// Control plane
+const MAX_DATAGRAM_SIZE = 2400        // receive buffer, unless ConnectivityInfo.MaxDatagramSize says otherwise
+const RECEIVE_ERROR_BACKOFF = 10      // msec to wait after a receive error, doubling while they go on
+const RECEIVE_MAX_BACKOFF = 1000      // msec, the wait after a receive error doubles up to this
+const RECEIVE_ERROR_LOG_EVERY = 100   // of a run of receive errors, the first and every this many are printed
+const RELIABLE_RETRY_TIMEOUT = 500    // msec until the first retransmit of an unacknowledged message
+const RELIABLE_MAX_BACKOFF = 4000     // msec, retransmit interval doubles up to this
+const RELIABLE_MAX_RETRIES = 5        // then the message is given up on
+const MAX_FRAGMENTS = 64              // longest message is MAX_FRAGMENTS * MTU
+const FRAGMENT_TIMEOUT = 3000         // msec to wait for the missing fragments of a message
+const FRAGMENT_MEMORY_LIMIT = 1 << 20 // bytes of incomplete messages kept
+const ROUTE_INFINITY = 16             // route metric meaning unreachable
+const ROUTE_ADVERTISE_INTERVAL = 3000 // msec between ROUTES advertisements, sooner if routes changed
+const ROUTE_TIMEOUT = 10000           // msec a route lives without being heard of again
+const GROUND_TIMEOUT = 6000           // msec a neighbor's distance to ground, or hearing the ground, is good for
+const GROUND_DISTANCE_UNKNOWN = -1    // for distances to ground
+const MATRIX_TIMEOUT = 6000           // msec a node's neighbors, as it told us, are good for
+const LIVENESS_HELLO_INTERVAL = 1000  // msec between M3 hellos to each terminal
+const LIVENESS_DEAD_INTERVAL = 4000   // msec a terminal may be silent before it is DOWN
+const SESSION_CONNECT_INTERVAL = 2000 // msec between CONNECTs while an M2 has no session
+const INCARNATION_SEQ_RESET = 16      // SrcSeq a restarted node without incarnation # starts below
+const NODE_TICK_INTERVAL = 300        // msec between Node timer ticks, unless NodeConfig says otherwise
@@ -2,158 +2,236 @@ package common
 
 import (
 	//"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/libp2p/go-reuseport"
 	"log"
 	"net"
+	"sync"
 	"time"
 )
 
 //====================================================================================
-//
+// ControlPlaneInit - get connectivity ready and open its transport. Returns the
+// error if the transport could not be opened, nothing is then received or sent
 //====================================================================================
-func ControlPlaneInit(connectivity *ConnectivityInfo, channels MyChannels) {
-	var err error
-
+func ControlPlaneInit(connectivity *ConnectivityInfo, channels MyChannels) error {
 	channels.ControlChannel = make(chan []byte) // so that all threads can talk to us
-	// UNICAST Drone.UnicastRxPort = "8888",  UnicastRxAddress = "0.0.0.0:8888"
-	/*
-		//----------------------------------------------------------------------------------------------
-		Drone.UnicastRxStruct, err = net.ResolveUDPAddr("udp", Drone.UnicastRxAddress)
-		if err != nil { log.Fatal(err) }
-		fmt.Println(Drone.DroneName, "1Init ControlPlane UnicastRxStruct", Drone.UnicastRxStruct)
-		Drone.UnicastConnection, err = net.ListenMulticastUDP("udp", nil, Drone.UnicastRxStruct)
-		if err != nil {
-			fmt.Println(Drone.DroneName, "2Init ControlPlane ERROR UnicastConnection err=", err)
-			fmt.Println(Drone.DroneName, "3Init ControlPlane ERROR UnicastConnection =", Drone.UnicastConnection)
-		} else {
-			Drone.UnicastConnection.SetReadBuffer(Drone.MaxDatagramSize)
-			fmt.Println(Drone.DroneName, "4Init ControlPlane OK: UnicastRxAddrStruct=", Drone.UnicastRxStruct)
-			fmt.Println(Drone.DroneName, "5Init ControlPlane OK: UnicastConnection=", Drone.UnicastConnection.LocalAddr())
-		}
-	*/
-	connectivity.UnicastRxStruct, err = net.ResolveUDPAddr("udp", ":"+connectivity.UnicastRxPort) //Drone.UnicastRxAddress)
-	if err != nil {
-		log.Fatal(err)
-	} else {
-		fmt.Println("1Init OK ControlPlane UnicastRxStruct", connectivity.UnicastRxStruct)
-		// Drone.UnicastConnection, err = net.ListenUDP("udp", Drone.UnicastRxStruct)
-		connectivity.UnicastConnection, err =
-			reuseport.ListenPacket("udp", ":"+connectivity.UnicastRxPort)
-		if err != nil {
-			fmt.Println("2Init ControlPlane ERROR UnicastConnection err=", err)
-			panic(err)
-		} else {
-			//Drone.UnicastConnection.SetReadBuffer(Drone.MaxDatagramSize)
-			fmt.Println("3Init ControlPlane OK: UnicastConnection=",
-				connectivity.UnicastConnection.LocalAddr())
-			//defer Drone.UnicastConnection.Close()
-		}
+
+	if connectivity.MulticastIP != "" && connectivity.MulticastAddress == "" {
+		connectivity.MulticastAddress = net.JoinHostPort(connectivity.MulticastIP, connectivity.MulticastPort)
 	}
-	//BROADCAST
-	//----------------------------------------------------------------------------------------------------
-	connectivity.BroadcastTxStruct, err = net.ResolveUDPAddr("udp", connectivity.BroadcastTxAddress) // TODO
-	if err != nil {
-		fmt.Println("4Init ControlPlane ERROR BroadcastTxStruct err=", err)
-		panic(err)
+	if connectivity.Reassembly == nil {
+		connectivity.Reassembly = NewReassembler()
 	}
-	fmt.Println("5Init ControlPlane OK: BroadcastTxStruct=", connectivity.BroadcastTxStruct)
-	fmt.Println("6Init ControlPlane Broadcast socket listen on ", connectivity.BroadcastRxAddress)
-	connectivity.BroadcastConnection, err =
-		reuseport.ListenPacket("udp", connectivity.BroadcastRxAddress)
+	if connectivity.MTU > connectivity.MaxDatagramSize && connectivity.MaxDatagramSize > 0 {
+		fmt.Println("Init ControlPlane WARNING MTU=", connectivity.MTU, " > MaxDatagramSize=",
+			connectivity.MaxDatagramSize, ", our peers may truncate what we send")
+	}
+	// Unless somebody plugged in another link, use UDP sockets
+	if connectivity.Transport == nil {
+		connectivity.Transport = NewUDPTransport()
+	}
+	err := connectivity.Transport.Open(connectivity)
 	if err != nil {
-		fmt.Println("7Init ControlPlane ERROR BroadcastConnection err=", err)
-		panic(err)
-	} else {
-		fmt.Println("8Init ControlPlane OK: BroadcastConnection=",
-			connectivity.BroadcastConnection.LocalAddr())
-		//defer Drone.BroadcastConnection.Close()
-	}
-	// CHECK IF SAME ADDRESS
-	/*
-		//----------------------------------------------------------------------------------------------
-		if strings.Compare(Drone.BroadcastRxAddress, Drone.UnicastRxAddress) != 0 { // NOT SAME
-			//  Initialize Broadcast socket
-			fmt.Println(Drone.DroneName, "9Init ControlPlane Broadcast socket listen on ", Drone.BroadcastRxAddress)
-			Drone.BroadcastConnection, err = reuseport.ListenPacket("udp", Drone.BroadcastRxAddress)
-			if err != nil {
-				fmt.Println(Drone.DroneName, "10Init ControlPlane ERROR BroadcastConnection err=", err)
-				fmt.Println(Drone.DroneName, "11Init ControlPlane ERROR BroadcastConnection =", Drone.BroadcastConnection)
-				panic(err)
-			}
-		} else { // SAME
-			fmt.Println(Drone.DroneName, "12Init ControlPlane Broadcast socket listen on unicast ", Drone.BroadcastRxAddress)
-			Drone.BroadcastConnection = Drone.UnicastConnection
-		}
-	*/
-	// DONE
-	//fmt.Println(Drone.DroneName, "9Init ControlPlane BroadcastRxIP= ",Drone.BroadcastRxIP,"UnicastRxIP=", Drone.UnicastRxIP)
+		fmt.Println("Init ControlPlane ERROR opening transport err=", err)
+		return err
+	}
+	return nil
 }
-func ControlPlaneCloseConnections(connectivity ConnectivityInfo) {
+//====================================================================================
+// Close the transport and stop receiving. The returned channel is closed once all
+// receive goroutines have exited; packets they already read are still delivered
+// to MyChannels until then, so keep draining the channels while waiting.
+//====================================================================================
+func ControlPlaneCloseConnections(connectivity *ConnectivityInfo) <-chan struct{} {
 	fmt.Println(" CLOSE NETWORK CONNECTIONS")
-	defer connectivity.UnicastConnection.Close()
-	defer connectivity.BroadcastConnection.Close()
+	if connectivity.Reliable != nil {
+		connectivity.Reliable.Stop()
+	}
+	if connectivity.Transport != nil {
+		_ = connectivity.Transport.Close()
+	}
+	stopped := make(chan struct{})
+	receivers := connectivity.receivers
+	go func() {
+		if receivers != nil {
+			receivers.Wait()
+		}
+		close(stopped)
+	}()
+	return stopped
 }
 
-
 //====================================================================================
 //  Control Plane Listen To Unicast UDP
 //====================================================================================
-func ControlPlaneListenToUnicastUDP(connectivity *ConnectivityInfo, channels MyChannels) {
-	fmt.Println("UNICAST *** Start Receiving on ", connectivity.UnicastConnection.LocalAddr())
-	go func() {
-		for {
-			unicastBuffer := make([]byte, 2400) // Drone.MaxDatagramSize)
-			length, sender, err := connectivity.UnicastConnection.ReadFrom(unicastBuffer)
-
-			if err != nil {
-				log.Fatal(sender, "UNICAST ReadFromUDP failed:", err)
-			}
-			fmt.Println("=====>> UNI-CAST rcv ",
-				" from ", sender.String(), "  ", sender.Network(), "len=", length, "ERR=", err)
-			channels.UnicastRcvCtrlChannel <- unicastBuffer[0:length]
-		}
-	}()
+func ControlPlaneListenToUnicastUDP(ctx context.Context, connectivity *ConnectivityInfo, channels MyChannels) {
+	fmt.Println("UNICAST *** Start Receiving on ", connectivity.UnicastRxAddress)
+	controlPlaneReceive(ctx, connectivity, SOCKET_UNICAST,
+		connectivity.Transport.UnicastReceive, channels.UnicastRcvCtrlChannel)
 }
 
 //====================================================================================
 //  Control Plane Listen To Broadcast UDP
 //====================================================================================
-func ControlPlaneListenToBroadcastUDP(connectivity *ConnectivityInfo, channels MyChannels) {
-	if connectivity.BroadcastConnection != nil {
-		fmt.Println("BROADCAST *** Start Receiving on ", connectivity.BroadcastConnection.LocalAddr())
+func ControlPlaneListenToBroadcastUDP(ctx context.Context, connectivity *ConnectivityInfo, channels MyChannels) {
+	if connectivity.Transport != nil {
+		fmt.Println("BROADCAST *** Start Receiving on ", connectivity.BroadcastRxAddress)
 	} else {
 		fmt.Println("BROADCAST *** Start Receiving - Connection NOT initialized ")
 		return
 	}
+	controlPlaneReceive(ctx, connectivity, SOCKET_BROADCAST,
+		connectivity.Transport.BroadcastReceive, channels.BroadcastRcvCtrlChannel)
+}
+
+//====================================================================================
+//  Control Plane Listen To Multicast UDP
+//====================================================================================
+func ControlPlaneListenToMulticastUDP(ctx context.Context, connectivity *ConnectivityInfo, channels MyChannels) {
+	if connectivity.Transport == nil || connectivity.MulticastAddress == "" {
+		fmt.Println("MULTICAST *** Not Receiving - no multicast group configured")
+		return
+	}
+	fmt.Println("MULTICAST *** Start Receiving on ", connectivity.MulticastAddress)
+	controlPlaneReceive(ctx, connectivity, SOCKET_MULTICAST,
+		connectivity.Transport.MulticastReceive, channels.MulticastRcvCtrlChannel)
+}
+
+//====================================================================================
+// Receive goroutine: read from the transport and pass packets to the main loop
+// until ctx is cancelled or the transport is closed. After a receive error it
+// waits a little longer each time, until a packet comes in again
+//====================================================================================
+func controlPlaneReceive(ctx context.Context, connectivity *ConnectivityInfo, name string,
+	receive func([]byte) (int, net.Addr, error), rcvChannel chan InboundPacket) {
+	if connectivity.receivers == nil {
+		connectivity.receivers = new(sync.WaitGroup)
+	}
+	bufferSize := connectivity.MaxDatagramSize
+	if bufferSize <= 0 {
+		bufferSize = MAX_DATAGRAM_SIZE
+	}
+	connectivity.receivers.Add(1)
 	go func() {
+		defer connectivity.receivers.Done()
+		// one byte more than we accept, so that a datagram that did not fit shows
+		buffer := make([]byte, bufferSize+1) // reused, each packet gets a copy of its size
+		errorCount := 0                      // in a row
+		backoff := RECEIVE_ERROR_BACKOFF * time.Millisecond
 		for {
-			broadcastBuffer := make([]byte, 2400)
-			length, sender, err := connectivity.BroadcastConnection.ReadFrom(broadcastBuffer)
+			length, sender, err := receive(buffer)
 			if err != nil {
-				fmt.Println(sender, "ERROR BROADCAST rcv err=", err,
-					" from ", sender.String(), "  ", sender.Network(), "len=", length, "ERR=", err)
-				panic(err)
+				if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+					fmt.Println(name, "*** Stop Receiving")
+					return
+				}
+				if errorCount%RECEIVE_ERROR_LOG_EVERY == 0 {
+					fmt.Println(sender, "ERROR", name, "rcv err=", err, "len=", length, "errors in a row=",
+						errorCount+1)
+				}
+				errorCount++
+				select {
+				case <-time.After(backoff):
+				case <-ctx.Done():
+					fmt.Println(name, "*** Stop Receiving")
+					return
+				}
+				backoff *= 2
+				if backoff > RECEIVE_MAX_BACKOFF*time.Millisecond {
+					backoff = RECEIVE_MAX_BACKOFF * time.Millisecond
+				}
+				continue
+			}
+			errorCount, backoff = 0, RECEIVE_ERROR_BACKOFF*time.Millisecond
+			if length > bufferSize {
+				fmt.Println(sender, "ERROR", name, "packet longer than", bufferSize, "bytes, dropped")
+				continue
+			}
+			payload := append([]byte(nil), buffer[0:length]...)
+			if TBisFragment(payload) {
+				payload = connectivity.Reassembly.Add(name, sender.String(), payload)
+				if payload == nil {
+					continue // more fragments to come
+				}
+			}
+			packet := InboundPacket{
+				Payload:    payload,
+				Source:     sender,
+				ReceivedAt: time.Now(),
+				Socket:     name,
+			}
+			select {
+			case rcvChannel <- packet:
+			case <-ctx.Done():
+				fmt.Println(name, "*** Stop Receiving")
+				return
 			}
-			//fmt.Println("*****>> BROADCAST rcv Count=", Drone.DroneReceiveCount,
-			//	" from ", sender.String(), "  ", sender.Network(), "len=", length, "ERR=", err)
-			channels.BroadcastRcvCtrlChannel <- broadcastBuffer[0:length]
 		}
 	}()
 }
 
+//====================================================================================
+// SourceIP - IP the packet really came from, "" if the transport did not say
+//====================================================================================
+func (packet InboundPacket) SourceIP() string {
+	if packet.Source == nil {
+		return ""
+	}
+	ip, _, err := net.SplitHostPort(packet.Source.String())
+	if err != nil {
+		return ""
+	}
+	return ip
+}
+
+//====================================================================================
+// SourceMismatch - true if the sender claims an SrcIP other than the one the
+// packet came from, i.e. the header was spoofed or rewritten by a NAT on the way.
+// Relayed messages come from the last relay, so those are never a mismatch
+//====================================================================================
+func (packet InboundPacket) SourceMismatch(msgHeader *MessageHeader) bool {
+	sourceIP := packet.SourceIP()
+	if sourceIP == "" || msgHeader.Hops > 0 {
+		return false // nothing to compare with
+	}
+	claimed := net.ParseIP(msgHeader.SrcIP)
+	return claimed == nil || !claimed.Equal(net.ParseIP(sourceIP))
+}
+
+//====================================================================================
+// ReplyAddress - IP:Port to send a unicast reply to. The real source IP;
+// the port is the source port for unicast packets (sent from the sender's unicast
+// socket), otherwise the unicast port the sender put in its header.
+// For relayed messages it is the SrcIP and SrcPort in the header
+//====================================================================================
+func (packet InboundPacket) ReplyAddress(msgHeader *MessageHeader) string {
+	sourceIP := packet.SourceIP()
+	if sourceIP == "" || msgHeader.Hops > 0 {
+		return net.JoinHostPort(msgHeader.SrcIP, msgHeader.SrcPort)
+	}
+	if packet.Socket == SOCKET_UNICAST || msgHeader.SrcPort == "" {
+		return packet.Source.String()
+	}
+	return net.JoinHostPort(sourceIP, msgHeader.SrcPort)
+}
+
 //====================================================================================
 //  Rcv  BROADCAST thread
 //====================================================================================
-func ControlPlaneRecvThread(connectivity *ConnectivityInfo, channels MyChannels) error {
+func ControlPlaneRecvThread(ctx context.Context, connectivity *ConnectivityInfo, channels MyChannels) error {
 	var err error = nil
 
 	fmt.Println("UDP Receive: ControlPlaneRecvThread: Start RECV THRED")
 
-	if connectivity.UnicastConnection != nil {
-		ControlPlaneListenToUnicastUDP(connectivity, channels)
+	if connectivity.Transport != nil {
+		ControlPlaneListenToUnicastUDP(ctx, connectivity, channels)
+	}
+	ControlPlaneListenToBroadcastUDP(ctx, connectivity, channels)
+	if connectivity.MulticastAddress != "" {
+		ControlPlaneListenToMulticastUDP(ctx, connectivity, channels)
 	}
-	ControlPlaneListenToBroadcastUDP(connectivity, channels)
 
 	return err
 }
@@ -164,7 +242,7 @@ func ControlPlaneRecvThread(connectivity *ConnectivityInfo, channels MyChannels)
 func ControlPlaneBroadcastSend(connectivity ConnectivityInfo, pkt []byte, address *net.UDPAddr) {
 	//fmt.Println(Drone.DroneName, "ControlPlane SEND to IP=", address)
 
-	_, err := connectivity.BroadcastConnection.WriteTo(pkt, address)
+	err := controlPlaneSend(connectivity, pkt, address.String(), connectivity.Transport.BroadcastSend)
 	if err != nil {
 		fmt.Println("BROADCAST SEND to ", address.String(), "FAILED: \n ERROR=", err)
 	} else {
@@ -177,16 +255,38 @@ func ControlPlaneBroadcastSend(connectivity ConnectivityInfo, pkt []byte, addres
 // Control Plane Unicast Send
 //====================================================================================
 func ControlPlaneUnicastSend(connectivity ConnectivityInfo, msgOut []byte, unicastIPandPort string) {
-	var err3 error
-	connectivity.UnicastTxStruct, err3 = net.ResolveUDPAddr("udp", unicastIPandPort) // TODO
-	if err3 != nil {
-		fmt.Println("ERROR UNICAST Set UnicastTxStruct Out to ", unicastIPandPort, " Err=", err3)
+	err := controlPlaneSend(connectivity, msgOut, unicastIPandPort, connectivity.Transport.UnicastSend)
+	if err != nil {
+		fmt.Println("ERROR UNICAST Sending Out to", unicastIPandPort, " Err=", err)
+	}
+}
+
+//====================================================================================
+// Control Plane Multicast Send - to our configured group
+//====================================================================================
+func ControlPlaneMulticastSend(connectivity ConnectivityInfo, pkt []byte) {
+	err := controlPlaneSend(connectivity, pkt, connectivity.MulticastAddress, connectivity.Transport.MulticastSend)
+	if err != nil {
+		fmt.Println("MULTICAST SEND to ", connectivity.MulticastAddress, "FAILED: \n ERROR=", err)
+	}
+}
+
+//====================================================================================
+// Send pkt with send, in fragments if it is longer than our MTU
+//====================================================================================
+func controlPlaneSend(connectivity ConnectivityInfo, pkt []byte, address string,
+	send func([]byte, string) error) error {
+	fragments, err := TBfragment(pkt, connectivity.MTU)
+	if err != nil {
+		return err
 	}
-	_, err4 := connectivity.UnicastConnection.WriteTo(msgOut, connectivity.UnicastTxStruct)
-	if err4 != nil {
-		fmt.Println("ERROR UNICAST Sending Out to", unicastIPandPort, ": UnicastTxStruct=",
-			connectivity.UnicastTxStruct, " Err=", err4)
+	for _, fragment := range fragments {
+		err = send(fragment, address)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 //====================================================================================
@@ -0,0 +1,46 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//====================================================================================
+// A receive that keeps failing is retried less and less often, not in a busy loop,
+// and a packet after the errors still gets through
+//====================================================================================
+func TestControlPlaneReceiveErrors(t *testing.T) {
+	var calls int32
+	failures := int32(6) // 10+20+40+80+160+320 msec of waiting
+	receive := func(buffer []byte) (int, net.Addr, error) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			return 0, nil, errors.New("test")
+		}
+		return copy(buffer, "packet"), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 4000}, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	connectivity := ConnectivityInfo{}
+	rcvChannel := make(chan InboundPacket)
+	controlPlaneReceive(ctx, &connectivity, SOCKET_UNICAST, receive, rcvChannel)
+	defer func() {
+		cancel()
+		connectivity.receivers.Wait()
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n > 4 {
+		t.Errorf("%d receives in 100 msec, want 4 at most", n)
+	}
+	select {
+	case packet := <-rcvChannel:
+		if string(packet.Payload) != "packet" {
+			t.Errorf("received %q", packet.Payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no packet after the errors")
+	}
+}
@@ -0,0 +1,137 @@
+//=============================================================================
+// FILE NAME: tbDispatcher.go
+// DESCRIPTION:
+// Message registry shared by the terminals. Each message type registers its
+// MsgCode, the struct its packets decode into, and the handler; the terminal's
+// ControlPlaneMessages decodes the header, does its own checks and calls
+// Dispatch, which decodes the whole message and calls the handler.
+// Codes nobody registered are counted, see UnknownCodes.
+// Typical use:
+//   dispatcher := common.NewDispatcher()
+//   dispatcher.Register(common.MSG_TYPE_DISCOVERY,
+//       func() interface{} { return new(common.MsgCodeDiscovery) }, handleDiscoveryMsg)
+//   ...
+//   dispatcher.Dispatch(packet, msgHeader)
+//================================================================================
+package common
+
+import (
+	"fmt"
+	"sync"
+)
+
+//====================================================================================
+// MessageHandler - msg is what the registered newMsg returned, decoded from the
+// packet, e.g. *MsgCodeDiscovery; nil for messages registered without a body
+//====================================================================================
+type MessageHandler func(packet InboundPacket, msgHeader *MessageHeader, msg interface{})
+
+type messageType struct {
+	newMsg  func() interface{}
+	handler MessageHandler
+}
+
+type Dispatcher struct {
+	mutex    sync.Mutex
+	types    map[string]messageType
+	unknown  map[string]int64 // MsgCode -> packets nobody registered for
+	failures map[string]int64 // MsgCode -> packets that did not decode
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		types:    make(map[string]messageType),
+		unknown:  make(map[string]int64),
+		failures: make(map[string]int64),
+	}
+}
+
+//====================================================================================
+// Register handler for msgCode. newMsg returns a pointer to the struct the whole
+// packet is decoded into, nil if the header is all the handler needs.
+// Registering the same code again replaces the previous handler
+//====================================================================================
+func (d *Dispatcher) Register(msgCode string, newMsg func() interface{}, handler MessageHandler) {
+	d.mutex.Lock()
+	d.types[msgCode] = messageType{newMsg: newMsg, handler: handler}
+	d.mutex.Unlock()
+}
+
+//====================================================================================
+// Dispatch the packet to the handler registered for msgHeader.MsgCode.
+// Returns false if there is none, or the message did not decode
+//====================================================================================
+func (d *Dispatcher) Dispatch(packet InboundPacket, msgHeader *MessageHeader) bool {
+	d.mutex.Lock()
+	msgType, ok := d.types[msgHeader.MsgCode]
+	if !ok {
+		d.unknown[msgHeader.MsgCode]++
+		first := d.unknown[msgHeader.MsgCode] == 1
+		d.mutex.Unlock()
+		if first {
+			fmt.Println("DISPATCH: UNKNOWN MsgCode=", msgHeader.MsgCode, " from SrcID=", msgHeader.SrcId,
+				" at", packet.Source)
+		}
+		return false
+	}
+	d.mutex.Unlock()
+
+	var msg interface{}
+	if msgType.newMsg != nil {
+		msg = msgType.newMsg()
+		err := TBdecode(packet.Payload, msg)
+		if err != nil {
+			d.mutex.Lock()
+			d.failures[msgHeader.MsgCode]++
+			d.mutex.Unlock()
+			fmt.Println("DISPATCH: ERROR decoding MsgCode=", msgHeader.MsgCode, " from SrcID=",
+				msgHeader.SrcId, " err=", err)
+			return false
+		}
+	}
+	msgType.handler(packet, msgHeader, msg)
+	return true
+}
+
+// UnknownCodes - how many packets were received for each unregistered MsgCode
+func (d *Dispatcher) UnknownCodes() map[string]int64 {
+	return d.copyCounts(d.unknown)
+}
+
+// DecodeFailures - how many packets of each registered MsgCode did not decode
+func (d *Dispatcher) DecodeFailures() map[string]int64 {
+	return d.copyCounts(d.failures)
+}
+
+func (d *Dispatcher) copyCounts(counts map[string]int64) map[string]int64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	result := make(map[string]int64, len(counts))
+	for code, count := range counts {
+		result[code] = count
+	}
+	return result
+}
+
+//====================================================================================
+// TBdecodeHeader - decode just the MessageHeader of a JSON or binary message
+//====================================================================================
+func TBdecodeHeader(message []byte) (*MessageHeader, error) {
+	msg := new(Msg)
+	err := TBdecode(message, &msg)
+	if err != nil {
+		return nil, err
+	}
+	return &msg.MsgHeader, nil
+}
+
+//====================================================================================
+// ControlPlaneLogMessage - handler for messages we accept but do nothing with yet
+//====================================================================================
+func ControlPlaneLogMessage(packet InboundPacket, msgHeader *MessageHeader, msg interface{}) {
+	if msg == nil {
+		fmt.Println("RCVD", msgHeader.MsgCode, "from SrcID=", msgHeader.SrcId, " at", packet.Source)
+	} else {
+		fmt.Printf("RCVD %s from SrcID= %d at %v: %+v\n", msgHeader.MsgCode, msgHeader.SrcId, packet.Source, msg)
+	}
+}
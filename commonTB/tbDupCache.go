@@ -0,0 +1,125 @@
+//=============================================================================
+// FILE NAME: tbDupCache.go
+// DESCRIPTION:
+// Duplicate suppression. The same message can reach us more than once: a
+// DISCOVERY on both the broadcast and the unicast socket, a flooded message
+// through several relays, or a retransmission whose ACK got lost.
+// Senders put TBheaderHash of the header into MessageHeader.Hash; DupCache
+// keeps, per SrcId, a hashArray slot for every SrcSeq and reports a message as
+// a duplicate if its slot already holds the same hash.
+// Sequence numbers run from 0 to MAX_MSG_SEQUENCE-1 and wrap (see TBnextSeq);
+// as a source's sequence moves forward the slots half a window ahead of it
+// are cleared, so a seq coming round again is not mistaken for a duplicate.
+//================================================================================
+package common
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+const dupSlotSeen = 1 // hashArray.state
+
+//====================================================================================
+// TBnextSeq - sequence number following seq, wrapping at MAX_MSG_SEQUENCE
+//====================================================================================
+func TBnextSeq(seq int) int {
+	return (seq + 1) % MAX_MSG_SEQUENCE
+}
+
+//====================================================================================
+// TBheaderHash - 16 bit hash of the fields that identify a message. Ttl, hop
+// counts and anything else relays may change are left out, never returns 0
+//====================================================================================
+func TBheaderHash(msgHeader *MessageHeader) int {
+	var buf [8]byte
+	hash := fnv.New32a()
+	binary.LittleEndian.PutUint64(buf[:], uint64(msgHeader.SrcId))
+	_, _ = hash.Write(buf[:])
+	binary.LittleEndian.PutUint64(buf[:], uint64(msgHeader.SrcSeq))
+	_, _ = hash.Write(buf[:])
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(msgHeader.TimeSent))
+	_, _ = hash.Write(buf[:])
+	_, _ = hash.Write([]byte(msgHeader.MsgCode))
+	_, _ = hash.Write([]byte{0})
+	_, _ = hash.Write([]byte(msgHeader.SrcName))
+	sum := hash.Sum32()
+	folded := uint16(sum>>16) ^ uint16(sum)
+	if folded == 0 {
+		folded = 1 // 0 means "not set" in the header
+	}
+	return int(folded)
+}
+
+type dupSource struct {
+	hashes  hashArray
+	lastSeq int
+}
+
+type DupCache struct {
+	mutex      sync.Mutex
+	sources    map[int]*dupSource // by SrcId
+	duplicates int64
+}
+
+func NewDupCache() *DupCache {
+	return &DupCache{sources: make(map[int]*dupSource)}
+}
+
+//====================================================================================
+// Seen - true if this message was received before. Otherwise it is recorded and
+// false returned, so call it once per received message. ACKs to our neighbors
+// are not sequenced, SrcSeq 0, and never reported as duplicates
+//====================================================================================
+func (c *DupCache) Seen(msgHeader *MessageHeader) bool {
+	if msgHeader.MsgCode == MSG_TYPE_ACK && msgHeader.SrcSeq == 0 {
+		return false
+	}
+	hash := msgHeader.Hash
+	if hash == 0 { // sender does not hash yet
+		hash = TBheaderHash(msgHeader)
+	}
+	seq := msgHeader.SrcSeq % MAX_MSG_SEQUENCE
+	if seq < 0 {
+		seq += MAX_MSG_SEQUENCE
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	source, ok := c.sources[msgHeader.SrcId]
+	if !ok {
+		source = &dupSource{lastSeq: seq}
+		c.sources[msgHeader.SrcId] = source
+	}
+	if source.hashes.state[seq] == dupSlotSeen && int(source.hashes.hashValue[seq]) == hash {
+		c.duplicates++
+		return true
+	}
+	// moving forward ? then forget the slots we are about to wrap into
+	ahead := (seq - source.lastSeq + MAX_MSG_SEQUENCE) % MAX_MSG_SEQUENCE
+	if ahead > 0 && ahead < MAX_MSG_SEQUENCE/2 {
+		for k := 1; k <= ahead; k++ {
+			source.hashes.state[(source.lastSeq+k+MAX_MSG_SEQUENCE/2)%MAX_MSG_SEQUENCE] = 0
+		}
+		source.lastSeq = seq
+	}
+	source.hashes.state[seq] = dupSlotSeen
+	source.hashes.hashValue[seq] = uint16(hash)
+	return false
+}
+
+// Forget - drop everything known about srcId, e.g. after it restarted
+func (c *DupCache) Forget(srcId int) {
+	c.mutex.Lock()
+	delete(c.sources, srcId)
+	c.mutex.Unlock()
+}
+
+// Duplicates - how many duplicates Seen reported so far
+func (c *DupCache) Duplicates() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.duplicates
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"testing"
+)
+
+//====================================================================================
+// Source 1 sends count messages from seq from on, all with the same Hash, then
+// seq probe, or an ACK if ack: is it a duplicate ?
+//====================================================================================
+func TestDupCacheSeen(t *testing.T) {
+	tests := []struct {
+		name  string
+		from  int
+		count int
+		probe int
+		ack   bool
+		want  bool
+	}{
+		{"again", 5, 1, 5, false, true},
+		{"next one", 5, 1, 6, false, false},
+		{"a while back", 0, 700, 300, false, true},
+		{"over half the window back, forgotten", 0, 700, 100, false, false},
+		{"across the wrap, before it", MAX_MSG_SEQUENCE - 4, 8, MAX_MSG_SEQUENCE - 2, false, true},
+		{"across the wrap, after it", MAX_MSG_SEQUENCE - 4, 8, 2, false, true},
+		{"across the wrap, 0", MAX_MSG_SEQUENCE - 4, 5, 0, false, true},
+		{"a round later", 0, MAX_MSG_SEQUENCE, 0, false, false},
+		{"two rounds, the latest", 0, 2 * MAX_MSG_SEQUENCE, MAX_MSG_SEQUENCE - 1, false, true},
+		{"ACK to a neighbor", 0, 0, 0, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := NewDupCache()
+			header := func(seq int) *MessageHeader {
+				return &MessageHeader{MsgCode: MSG_TYPE_DRONE_MOVE, SrcId: 1, SrcSeq: seq, Hash: 100}
+			}
+			for i := 0; i < test.count; i++ {
+				seq := (test.from + i) % MAX_MSG_SEQUENCE
+				if cache.Seen(header(seq)) {
+					t.Fatalf("seq %d, message %d, a duplicate", seq, i)
+				}
+			}
+			probe := header(test.probe)
+			if test.ack {
+				probe.MsgCode = MSG_TYPE_ACK
+				cache.Seen(probe)
+			}
+			if got := cache.Seen(probe); got != test.want {
+				t.Errorf("seq %d seen %v, want %v", test.probe, got, test.want)
+			}
+		})
+	}
+}
+
+//====================================================================================
+// Same SrcSeq, different messages: another source, another Hash, or a source
+// that restarted and was forgotten
+//====================================================================================
+func TestDupCacheSources(t *testing.T) {
+	cache := NewDupCache()
+	tests := []struct {
+		name   string
+		srcId  int
+		hash   int
+		forget bool
+		want   bool
+	}{
+		{"first", 1, 100, false, false},
+		{"again", 1, 100, false, true},
+		{"another source", 2, 100, false, false},
+		{"another message", 1, 101, false, false},
+		{"the first one, its slot taken", 1, 100, false, false},
+		{"forgotten", 2, 100, true, false},
+	}
+	for _, test := range tests {
+		if test.forget {
+			cache.Forget(test.srcId)
+		}
+		msgHeader := &MessageHeader{MsgCode: MSG_TYPE_DRONE_MOVE, SrcId: test.srcId, SrcSeq: 5, Hash: test.hash}
+		if got := cache.Seen(msgHeader); got != test.want {
+			t.Errorf("%s: seen %v, want %v", test.name, got, test.want)
+		}
+	}
+	if duplicates := cache.Duplicates(); duplicates != 1 {
+		t.Errorf("%d duplicates, want 1", duplicates)
+	}
+}
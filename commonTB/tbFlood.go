@@ -0,0 +1,159 @@
+//=============================================================================
+// FILE NAME: tbFlood.go
+// DESCRIPTION:
+// Controlled flooding, so nodes out of direct range of M3 or ground are still
+// reached. Every node that hears a message not addressed to it passes it on,
+// and one sent to our address, on the unicast socket, is addressed to us:
+// Ttl is decremented, Hops incremented, and the message rebroadcast (multicast
+// if we are in a group) with the rest of it untouched. A message arriving with
+// Ttl 1 or less has gone as far as its sender wanted and is not relayed, and
+// DRONE_TERMINATE never is, it is for the nodes it was sent to only.
+// Hash is left alone, it does not cover Ttl or Hops, so receivers see relayed
+// copies as duplicates of the original; the Flooder keeps its own DupCache to
+// relay each message once, whichever way it came round.
+// Handlers find the number of relays a message went through in msgHeader.Hops.
+// Typical use, in ControlPlaneMessages after the duplicate check:
+//   M3.Connectivity.Flood = common.NewFlooder(&M3.Connectivity, M3.M3TerminalId)
+//   ...
+//   M3.Connectivity.Flood.Relay(packet, msgHeader)
+//================================================================================
+package common
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+type Flooder struct {
+	connectivity *ConnectivityInfo
+	selfId       int
+	seen         *DupCache // what we relayed already
+	mutex        sync.Mutex
+	relayed      int64
+	expired      int64 // Ttl ran out here
+}
+
+func NewFlooder(connectivity *ConnectivityInfo, selfId int) *Flooder {
+	return &Flooder{
+		connectivity: connectivity,
+		selfId:       selfId,
+		seen:         NewDupCache(),
+	}
+}
+
+//====================================================================================
+// Relay the message in packet, whose header is msgHeader, if it is not addressed
+// to us only and has Ttl left. Returns true if it was passed on
+//====================================================================================
+func (f *Flooder) Relay(packet InboundPacket, msgHeader *MessageHeader) bool {
+	if msgHeader.DstId == f.selfId || msgHeader.SrcId == f.selfId {
+		return false
+	}
+	if packet.Socket == SOCKET_UNICAST && msgHeader.DstId == 0 {
+		return false // sent to us, whoever we are
+	}
+	if msgHeader.MsgCode == MSG_TYPE_DRONE_TERMINATE {
+		return false
+	}
+	if f.seen.Seen(msgHeader) {
+		return false // relayed it already, this one came round a loop
+	}
+	if msgHeader.Ttl <= 1 {
+		f.mutex.Lock()
+		f.expired++
+		f.mutex.Unlock()
+		return false
+	}
+	msgOut, err := relayCopy(f.connectivity, packet.Payload, msgHeader)
+	if err != nil {
+		fmt.Println("FLOOD: ERROR relaying", msgHeader.MsgCode, " from SrcID=", msgHeader.SrcId, " err=", err)
+		return false
+	}
+	if f.connectivity.MulticastAddress != "" {
+		ControlPlaneMulticastSend(*f.connectivity, msgOut)
+	} else {
+		ControlPlaneBroadcastSend(*f.connectivity, msgOut, f.connectivity.BroadcastTxStruct)
+	}
+	f.mutex.Lock()
+	f.relayed++
+	f.mutex.Unlock()
+	return true
+}
+
+// Stats - messages relayed, and not relayed because their Ttl ran out
+func (f *Flooder) Stats() (relayed, expired int64) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.relayed, f.expired
+}
+
+// relayCopy - message, whose header is msgHeader, one hop further: Ttl down, Hops up,
+// sent from where we are
+func relayCopy(connectivity *ConnectivityInfo, message []byte, msgHeader *MessageHeader) ([]byte, error) {
+	relayHeader := *msgHeader
+	relayHeader.Ttl--
+	relayHeader.Hops++
+	if connectivity.Radio != nil {
+		connectivity.Radio.Stamp(&relayHeader)
+	}
+	return TBreplaceHeader(message, &relayHeader)
+}
+
+//====================================================================================
+// TBreplaceHeader - message with its MsgHeader replaced by msgHeader, in the
+// codec it came in. The body is copied as is, whatever message type it is
+//====================================================================================
+func TBreplaceHeader(message []byte, msgHeader *MessageHeader) ([]byte, error) {
+	if TBisBinary(message) {
+		return binaryReplaceHeader(message, msgHeader)
+	}
+	fields := make(map[string]json.RawMessage)
+	err := json.Unmarshal(message, &fields)
+	if err != nil {
+		return nil, err
+	}
+	header, err := json.Marshal(msgHeader)
+	if err != nil {
+		return nil, err
+	}
+	fields["MsgHeader"] = header
+	return json.Marshal(fields)
+}
+
+// MsgHeader is the first field of every message, field number 1
+func binaryReplaceHeader(message []byte, msgHeader *MessageHeader) ([]byte, error) {
+	if len(message) < 2 {
+		return nil, ErrWireTruncated
+	}
+	if message[1] > WIRE_VERSION {
+		return nil, ErrWireVersion
+	}
+	header, err := appendStruct(nil, reflect.ValueOf(*msgHeader))
+	if err != nil {
+		return nil, err
+	}
+	out := []byte{WIRE_MAGIC, WIRE_VERSION}
+	out = appendUvarint(out, 1<<3|wireBytes)
+	out = appendUvarint(out, uint64(len(header)))
+	out = append(out, header...)
+
+	input := message[2:]
+	for len(input) > 0 {
+		key, n := binary.Uvarint(input)
+		if n <= 0 {
+			return nil, ErrWireTruncated
+		}
+		_, rest, err := splitValue(input[n:], int(key&7))
+		if err != nil {
+			return nil, err
+		}
+		if key>>3 != 1 {
+			out = append(out, input[:len(input)-len(rest)]...)
+		}
+		input = rest
+	}
+	return out, nil
+}
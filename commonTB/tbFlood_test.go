@@ -0,0 +1,62 @@
+package common
+
+import (
+	"testing"
+)
+
+//====================================================================================
+// What node 2 relays, and what it keeps to itself
+//====================================================================================
+func TestFloodRelay(t *testing.T) {
+	connectivity := loopbackConnectivity(NewLoopbackNetwork(), "10.0.0.2")
+	if err := ControlPlaneInit(&connectivity, MyChannels{}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { <-ControlPlaneCloseConnections(&connectivity) }()
+	flood := NewFlooder(&connectivity, 2)
+
+	tests := []struct {
+		name    string
+		socket  string
+		msgCode string
+		srcId   int
+		srcSeq  int
+		dstId   int
+		ttl     int
+		relayed bool
+	}{
+		{"broadcast", SOCKET_BROADCAST, MSG_TYPE_DISCOVERY, 1, 1, 0, 3, true},
+		{"broadcast again", SOCKET_BROADCAST, MSG_TYPE_DISCOVERY, 1, 1, 0, 3, false},
+		{"broadcast, on the unicast socket too", SOCKET_UNICAST, MSG_TYPE_DISCOVERY, 1, 1, 0, 3, false},
+		{"broadcast, Ttl used up", SOCKET_BROADCAST, MSG_TYPE_DISCOVERY, 1, 2, 0, 1, false},
+		{"broadcast, ours", SOCKET_BROADCAST, MSG_TYPE_DISCOVERY, 2, 3, 0, 3, false},
+		{"hello to us", SOCKET_UNICAST, MSG_TYPE_DISCOVERY, 1, 4, 0, 3, false},
+		{"hello to us, by id", SOCKET_UNICAST, MSG_TYPE_DISCOVERY, 1, 5, 2, 3, false},
+		{"to us, broadcast", SOCKET_BROADCAST, MSG_TYPE_DRONE_MOVE, 1, 6, 2, 3, false},
+		{"to 3, no route", SOCKET_UNICAST, MSG_TYPE_DRONE_MOVE, 1, 7, 3, 3, true},
+		{"to 3, broadcast", SOCKET_BROADCAST, MSG_TYPE_DRONE_MOVE, 1, 8, 3, 3, true},
+		{"ACK to 3, flooded", SOCKET_BROADCAST, MSG_TYPE_ACK, 1, 9, 3, 3, true},
+		{"TERMINATE to 3", SOCKET_BROADCAST, MSG_TYPE_DRONE_TERMINATE, GROUND_STATION_ID, 10, 3, 3, false},
+		{"TERMINATE to everybody", SOCKET_BROADCAST, MSG_TYPE_DRONE_TERMINATE, GROUND_STATION_ID, 11, 0, 3, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msgHeader := MessageHeader{MsgCode: test.msgCode, SrcId: test.srcId, SrcSeq: test.srcSeq,
+				DstId: test.dstId, Ttl: test.ttl}
+			payload, err := TBencode(connectivity.WireCodec, MsgCodeDiscovery{MsgHeader: msgHeader})
+			if err != nil {
+				t.Fatal(err)
+			}
+			before, _ := flood.Stats()
+			relayed := flood.Relay(InboundPacket{Payload: payload, Socket: test.socket}, &msgHeader)
+			after, _ := flood.Stats()
+			want := int64(0)
+			if test.relayed {
+				want = 1
+			}
+			if relayed != test.relayed || after-before != want {
+				t.Errorf("relayed %v, %d relays, want %v", relayed, after-before, test.relayed)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+//=============================================================================
+// FILE NAME: tbFragment.go
+// DESCRIPTION:
+// Fragmentation of messages that do not fit in one datagram.
+// The ControlPlane send functions split anything longer than the MTU into
+// fragments, each one a datagram of its own:
+//   FRAG_MAGIC, FRAG_VERSION, message id (4), fragment index (2), count (2), data
+// and the receive threads hand fragments to a Reassembler, which passes the
+// whole message on once all fragments are in. Messages still incomplete after
+// FRAGMENT_TIMEOUT msec are dropped, as are the oldest incomplete messages
+// when more than FRAGMENT_MEMORY_LIMIT bytes are waiting.
+// JSON and binary messages never start with FRAG_MAGIC, so ControlPlaneMessages
+// does not know whether a message came in one piece or in several.
+//================================================================================
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const FRAG_MAGIC = 0xA6
+const FRAG_VERSION = 1
+const FRAG_HEADER_SIZE = 10
+
+var ErrMessageTooLong = errors.New("message needs more than MAX_FRAGMENTS fragments")
+
+// message ids only have to differ between the messages of one sender that can
+// be in flight at the same time; start at random so a restart does not reuse them
+var fragmentMsgId = rand.New(rand.NewSource(time.Now().UnixNano())).Uint32()
+
+//====================================================================================
+// TBfragment - split msg into datagrams of at most mtu bytes, just msg if it fits
+//====================================================================================
+func TBfragment(msg []byte, mtu int) ([][]byte, error) {
+	if mtu <= 0 {
+		mtu = MAX_DATAGRAM_SIZE
+	}
+	if len(msg) <= mtu {
+		return [][]byte{msg}, nil
+	}
+	dataSize := mtu - FRAG_HEADER_SIZE
+	if dataSize <= 0 {
+		return nil, fmt.Errorf("MTU %d too small for fragments", mtu)
+	}
+	count := (len(msg) + dataSize - 1) / dataSize
+	if count > MAX_FRAGMENTS {
+		return nil, ErrMessageTooLong
+	}
+	msgId := atomic.AddUint32(&fragmentMsgId, 1)
+	fragments := make([][]byte, 0, count)
+	for index := 0; index < count; index++ {
+		data := msg[index*dataSize:]
+		if len(data) > dataSize {
+			data = data[:dataSize]
+		}
+		fragment := make([]byte, FRAG_HEADER_SIZE, FRAG_HEADER_SIZE+len(data))
+		fragment[0] = FRAG_MAGIC
+		fragment[1] = FRAG_VERSION
+		binary.BigEndian.PutUint32(fragment[2:], msgId)
+		binary.BigEndian.PutUint16(fragment[6:], uint16(index))
+		binary.BigEndian.PutUint16(fragment[8:], uint16(count))
+		fragments = append(fragments, append(fragment, data...))
+	}
+	return fragments, nil
+}
+
+// TBisFragment - true if pkt is one fragment of a longer message
+func TBisFragment(pkt []byte) bool {
+	return len(pkt) >= FRAG_HEADER_SIZE && pkt[0] == FRAG_MAGIC
+}
+
+//====================================================================================
+// Reassembler - collects fragments until messages are complete. One per
+// ConnectivityInfo, shared by the receive threads
+//====================================================================================
+type Reassembler struct {
+	Timeout     time.Duration // incomplete messages older than this are dropped
+	MemoryLimit int           // bytes of incomplete messages we hold on to
+
+	mutex    sync.Mutex
+	partial  map[string]*partialMessage
+	buffered int
+	// statistics
+	completed int64
+	timedOut  int64
+	evicted   int64
+	invalid   int64
+}
+
+type partialMessage struct {
+	fragments [][]byte
+	received  int
+	size      int
+	firstAt   time.Time
+}
+
+func NewReassembler() *Reassembler {
+	return &Reassembler{
+		Timeout:     FRAGMENT_TIMEOUT * time.Millisecond,
+		MemoryLimit: FRAGMENT_MEMORY_LIMIT,
+		partial:     make(map[string]*partialMessage),
+	}
+}
+
+//====================================================================================
+// Add a fragment of a message received from source on socket. Returns the whole
+// message once its last fragment is in, nil until then
+//====================================================================================
+func (r *Reassembler) Add(socket, source string, fragment []byte) []byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	r.expire(now)
+
+	if !TBisFragment(fragment) || fragment[1] > FRAG_VERSION {
+		r.invalid++
+		return nil
+	}
+	msgId := binary.BigEndian.Uint32(fragment[2:])
+	index := int(binary.BigEndian.Uint16(fragment[6:]))
+	count := int(binary.BigEndian.Uint16(fragment[8:]))
+	if count == 0 || count > MAX_FRAGMENTS || index >= count {
+		r.invalid++
+		return nil
+	}
+	key := fmt.Sprintf("%s/%s/%d", socket, source, msgId)
+	message, ok := r.partial[key]
+	if !ok {
+		message = &partialMessage{fragments: make([][]byte, count), firstAt: now}
+		r.partial[key] = message
+	}
+	if len(message.fragments) != count {
+		r.invalid++
+		return nil
+	}
+	if message.fragments[index] != nil {
+		return nil // duplicate fragment
+	}
+	data := append([]byte(nil), fragment[FRAG_HEADER_SIZE:]...)
+	message.fragments[index] = data
+	message.received++
+	message.size += len(data)
+	r.buffered += len(data)
+
+	if message.received == count {
+		r.drop(key, message)
+		r.completed++
+		whole := make([]byte, 0, message.size)
+		for _, data := range message.fragments {
+			whole = append(whole, data...)
+		}
+		return whole
+	}
+	r.evict(key)
+	return nil
+}
+
+// Incomplete - number of messages waiting for more fragments
+func (r *Reassembler) Incomplete() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.partial)
+}
+
+// Stats - messages reassembled, dropped incomplete on timeout or memory limit,
+// and fragments that made no sense
+func (r *Reassembler) Stats() (completed, timedOut, evicted, invalid int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.completed, r.timedOut, r.evicted, r.invalid
+}
+
+func (r *Reassembler) drop(key string, message *partialMessage) {
+	r.buffered -= message.size
+	delete(r.partial, key)
+}
+
+func (r *Reassembler) expire(now time.Time) {
+	for key, message := range r.partial {
+		if now.Sub(message.firstAt) > r.Timeout {
+			r.drop(key, message)
+			r.timedOut++
+		}
+	}
+}
+
+// evict the oldest incomplete messages, other than keep, until under MemoryLimit
+func (r *Reassembler) evict(keep string) {
+	for r.buffered > r.MemoryLimit {
+		oldestKey := ""
+		var oldest *partialMessage
+		for key, message := range r.partial {
+			if key != keep && (oldest == nil || message.firstAt.Before(oldest.firstAt)) {
+				oldestKey, oldest = key, message
+			}
+		}
+		if oldest == nil {
+			oldestKey, oldest = keep, r.partial[keep] // alone and still too big
+		}
+		r.drop(oldestKey, oldest)
+		r.evicted++
+		if oldestKey == keep {
+			return
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package common
+
+import (
+	"bytes"
+	"testing"
+)
+
+//====================================================================================
+// A message in three fragments, handed to the Reassembler in order, out of order,
+// twice or not at all; -1 is a fragment that makes no sense
+//====================================================================================
+func TestReassembler(t *testing.T) {
+	msg := []byte("twenty five bytes of msg.")
+	fragments, err := TBfragment(msg, FRAG_HEADER_SIZE+10)
+	if err != nil || len(fragments) != 3 {
+		t.Fatalf("%d fragments, err %v, want 3", len(fragments), err)
+	}
+	invalid := append([]byte(nil), fragments[0]...)
+	invalid[8], invalid[9] = 0, 0 // count 0
+
+	tests := []struct {
+		name           string
+		order          []int
+		wantWhole      bool // after the last one in order
+		wantIncomplete int
+		wantInvalid    int64
+	}{
+		{"in order", []int{0, 1, 2}, true, 0, 0},
+		{"reversed", []int{2, 1, 0}, true, 0, 0},
+		{"shuffled", []int{1, 2, 0}, true, 0, 0},
+		{"one twice", []int{0, 1, 1, 2}, true, 0, 0},
+		{"last one twice, the copy waits for the rest", []int{0, 2, 1, 1}, false, 1, 0},
+		{"first missing", []int{1, 2}, false, 1, 0},
+		{"middle missing, the rest twice", []int{0, 2, 0, 2}, false, 1, 0},
+		{"nothing but nonsense", []int{-1}, false, 0, 1},
+		{"nonsense in between", []int{0, -1, 1, 2}, true, 0, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewReassembler()
+			var whole []byte
+			for _, index := range test.order {
+				fragment := invalid
+				if index >= 0 {
+					fragment = fragments[index]
+				}
+				whole = r.Add(SOCKET_UNICAST, "10.0.0.2:4000", fragment)
+			}
+			if test.wantWhole && !bytes.Equal(whole, msg) {
+				t.Errorf("reassembled %q, want %q", whole, msg)
+			}
+			if !test.wantWhole && whole != nil {
+				t.Errorf("reassembled %q, want nothing", whole)
+			}
+			if incomplete := r.Incomplete(); incomplete != test.wantIncomplete {
+				t.Errorf("%d incomplete, want %d", incomplete, test.wantIncomplete)
+			}
+			if _, _, _, invalid := r.Stats(); invalid != test.wantInvalid {
+				t.Errorf("%d invalid, want %d", invalid, test.wantInvalid)
+			}
+		})
+	}
+}
+
+//====================================================================================
+// Fragments of the same message id from two sources, or on two sockets, are two
+// messages
+//====================================================================================
+func TestReassemblerSources(t *testing.T) {
+	fragments, _ := TBfragment([]byte("twenty five bytes of msg."), FRAG_HEADER_SIZE+10)
+	r := NewReassembler()
+	tests := []struct {
+		socket string
+		source string
+		index  int
+		whole  bool
+	}{
+		{SOCKET_UNICAST, "10.0.0.2:4000", 0, false},
+		{SOCKET_UNICAST, "10.0.0.3:4000", 1, false},
+		{SOCKET_BROADCAST, "10.0.0.2:4000", 2, false},
+		{SOCKET_UNICAST, "10.0.0.2:4000", 1, false},
+		{SOCKET_UNICAST, "10.0.0.2:4000", 2, true},
+	}
+	for _, test := range tests {
+		if whole := r.Add(test.socket, test.source, fragments[test.index]); (whole != nil) != test.whole {
+			t.Errorf("fragment %d from %s on %s: whole %v, want %v", test.index, test.source, test.socket,
+				whole != nil, test.whole)
+		}
+	}
+	if incomplete := r.Incomplete(); incomplete != 2 {
+		t.Errorf("%d incomplete, want 2", incomplete)
+	}
+}
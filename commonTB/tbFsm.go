@@ -0,0 +1,226 @@
+//=============================================================================
+// FILE NAME: tbFsm.go
+// DESCRIPTION:
+// Table driven finite state machines, for the terminals' connection logic.
+// An FsmTable declares the states, with their entry and exit actions, and
+// the transitions: in state From, Event moves the machine to state To if
+// the Guard, when there is one, agrees. Transitions are tried in the order
+// they were added, the first one whose guard passes is taken. To "" is an
+// internal transition, only its Action runs; otherwise the exit action of
+// From, the Action and the entry action of To run, in that order, even when
+// To is From. From FSM_ANY_STATE matches any state without a transition of
+// its own for the event.
+// Any number of Fsms run off one table, each with its own state. An event
+// with no transition from the current state is rejected with ErrFsmInvalid,
+// and logged; one whose transitions all have guards that said no returns
+// ErrFsmGuarded, quietly, since that is usually a timer with nothing to do.
+// Events are run to completion: actions must not call Fire on their own Fsm,
+// they Post, and the posted event runs once the current one is done.
+// Typical use:
+//   table := common.NewFsmTable("M2")
+//   table.AddState(SESSION_DOWN, r.enterDown, nil)
+//   err := table.AddTransitions(
+//       common.FsmTransition{From: SESSION_DOWN, Event: EVENT_TICK, To: SESSION_CONNECTING, Guard: r.connectDue},
+//       ...)
+//   r.fsm = table.New("M2", SESSION_DOWN)
+//   err = r.fsm.Fire(EVENT_TICK, nil)
+//================================================================================
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const FSM_ANY_STATE = "*"
+
+var ErrFsmInvalid = errors.New("no transition for event")
+var ErrFsmGuarded = errors.New("event not allowed now")
+
+// FsmAction - entry, exit or transition action; data is what was passed to Fire
+type FsmAction func(fsm *Fsm, event string, data interface{})
+
+// FsmGuard - true if the transition may be taken
+type FsmGuard func(fsm *Fsm, event string, data interface{}) bool
+
+type FsmTransition struct {
+	From   string // FSM_ANY_STATE for all
+	Event  string
+	To     string   // "" = internal, stay in From without exit or entry
+	Guard  FsmGuard // nil = always
+	Action FsmAction
+}
+
+type fsmState struct {
+	entry FsmAction
+	exit  FsmAction
+}
+
+type fsmKey struct {
+	from  string
+	event string
+}
+
+type FsmTable struct {
+	Name        string
+	states      map[string]fsmState
+	transitions map[fsmKey][]FsmTransition
+}
+
+func NewFsmTable(name string) *FsmTable {
+	return &FsmTable{
+		Name:        name,
+		states:      make(map[string]fsmState),
+		transitions: make(map[fsmKey][]FsmTransition),
+	}
+}
+
+// AddState - declare state, entry and exit may be nil
+func (t *FsmTable) AddState(state string, entry, exit FsmAction) {
+	t.states[state] = fsmState{entry: entry, exit: exit}
+}
+
+//====================================================================================
+// AddTransitions - add to the table, after the ones already there. States must
+// have been declared with AddState; on error none of transitions is added
+//====================================================================================
+func (t *FsmTable) AddTransitions(transitions ...FsmTransition) error {
+	for _, transition := range transitions {
+		if _, ok := t.states[transition.From]; !ok && transition.From != FSM_ANY_STATE {
+			return fmt.Errorf("fsm %s: %s --%s--> from undeclared state", t.Name, transition.From, transition.Event)
+		}
+		if _, ok := t.states[transition.To]; !ok && transition.To != "" {
+			return fmt.Errorf("fsm %s: %s --%s--> %s to undeclared state", t.Name, transition.From,
+				transition.Event, transition.To)
+		}
+	}
+	for _, transition := range transitions {
+		key := fsmKey{from: transition.From, event: transition.Event}
+		t.transitions[key] = append(t.transitions[key], transition)
+	}
+	return nil
+}
+
+// New - a machine running off the table, in state initial. The entry action of
+// initial is not run
+func (t *FsmTable) New(name, initial string) *Fsm {
+	return &Fsm{Name: name, table: t, state: initial}
+}
+
+type fsmEvent struct {
+	event string
+	data  interface{}
+}
+
+type Fsm struct {
+	Name string // for the log
+
+	table     *FsmTable
+	fireMutex sync.Mutex // one event at a time
+	mutex     sync.Mutex
+	state     string
+	running   bool
+	posted    []fsmEvent
+}
+
+// State - the current state
+func (f *Fsm) State() string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.state
+}
+
+// Can - true if event would be taken now
+func (f *Fsm) Can(event string, data interface{}) bool {
+	_, err := f.find(f.State(), event, data)
+	return err == nil
+}
+
+//====================================================================================
+// Fire - run event through the machine, then whatever the actions posted.
+// Returns ErrFsmInvalid or ErrFsmGuarded, wrapped, if event was not taken
+//====================================================================================
+func (f *Fsm) Fire(event string, data interface{}) error {
+	f.fireMutex.Lock()
+	defer f.fireMutex.Unlock()
+	f.mutex.Lock()
+	f.running = true
+	f.mutex.Unlock()
+
+	err := f.run(event, data)
+	for {
+		f.mutex.Lock()
+		if len(f.posted) == 0 {
+			f.running = false
+			f.mutex.Unlock()
+			return err
+		}
+		posted := f.posted[0]
+		f.posted = f.posted[1:]
+		f.mutex.Unlock()
+		_ = f.run(posted.event, posted.data)
+	}
+}
+
+//====================================================================================
+// Post - fire event once the one being run is done, or now if there is none
+//====================================================================================
+func (f *Fsm) Post(event string, data interface{}) {
+	f.mutex.Lock()
+	if f.running {
+		f.posted = append(f.posted, fsmEvent{event: event, data: data})
+		f.mutex.Unlock()
+		return
+	}
+	f.mutex.Unlock()
+	_ = f.Fire(event, data)
+}
+
+func (f *Fsm) run(event string, data interface{}) error {
+	from := f.State()
+	transition, err := f.find(from, event, data)
+	if err != nil {
+		if errors.Is(err, ErrFsmInvalid) {
+			fmt.Println("FSM:", f.Name, "REJECTED", event, "in", from)
+		}
+		return err
+	}
+	if transition.To == "" {
+		if transition.Action != nil {
+			transition.Action(f, event, data)
+		}
+		return nil
+	}
+	if exit := f.table.states[from].exit; exit != nil {
+		exit(f, event, data)
+	}
+	if transition.Action != nil {
+		transition.Action(f, event, data)
+	}
+	f.mutex.Lock()
+	f.state = transition.To
+	f.mutex.Unlock()
+	fmt.Println("FSM:", f.Name, from, "--"+event+"-->", transition.To)
+	if entry := f.table.states[transition.To].entry; entry != nil {
+		entry(f, event, data)
+	}
+	return nil
+}
+
+// find - the transition event takes from state
+func (f *Fsm) find(state, event string, data interface{}) (FsmTransition, error) {
+	transitions, ok := f.table.transitions[fsmKey{from: state, event: event}]
+	if !ok {
+		transitions, ok = f.table.transitions[fsmKey{from: FSM_ANY_STATE, event: event}]
+	}
+	if !ok {
+		return FsmTransition{}, fmt.Errorf("fsm %s: %w %s in %s", f.Name, ErrFsmInvalid, event, state)
+	}
+	for _, transition := range transitions {
+		if transition.Guard == nil || transition.Guard(f, event, data) {
+			return transition, nil
+		}
+	}
+	return FsmTransition{}, fmt.Errorf("fsm %s: %w, %s in %s", f.Name, ErrFsmGuarded, event, state)
+}
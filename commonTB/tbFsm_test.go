@@ -0,0 +1,116 @@
+package common
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// traceTable - A, B and C, every action and entry and exit adds to trace
+//
+//	A --go--> B if data is true, C otherwise   B --stay--> internal
+//	A --self--> A                               B --back--> A
+//	C --blocked--> B never                      any --reset--> A
+//	A --first--> B, which posts second         B --second--> C
+func traceTable(t *testing.T, trace *[]string) *FsmTable {
+	t.Helper()
+	record := func(what string) FsmAction {
+		return func(_ *Fsm, _ string, _ interface{}) { *trace = append(*trace, what) }
+	}
+	table := NewFsmTable("test")
+	for _, state := range []string{"A", "B", "C"} {
+		table.AddState(state, record("enter "+state), record("exit "+state))
+	}
+	err := table.AddTransitions(
+		FsmTransition{From: "A", Event: "go", To: "B", Guard: func(_ *Fsm, _ string, data interface{}) bool {
+			return data == true
+		}, Action: record("go")},
+		FsmTransition{From: "A", Event: "go", To: "C", Action: record("go")},
+		FsmTransition{From: "A", Event: "self", To: "A"},
+		FsmTransition{From: "B", Event: "stay", Action: record("stay")},
+		FsmTransition{From: "B", Event: "back", To: "A"},
+		FsmTransition{From: "C", Event: "blocked", To: "B", Guard: func(_ *Fsm, _ string, _ interface{}) bool {
+			return false
+		}},
+		FsmTransition{From: FSM_ANY_STATE, Event: "reset", To: "A"},
+		FsmTransition{From: "A", Event: "first", To: "B", Action: func(fsm *Fsm, _ string, _ interface{}) {
+			*trace = append(*trace, "first")
+			fsm.Post("second", nil)
+		}},
+		FsmTransition{From: "B", Event: "second", To: "C"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestFsmFire(t *testing.T) {
+	tests := []struct {
+		name      string
+		from      string
+		event     string
+		data      interface{}
+		want      string
+		wantErr   error
+		wantTrace []string
+	}{
+		{"guard says yes", "A", "go", true, "B", nil, []string{"exit A", "go", "enter B"}},
+		{"next transition", "A", "go", false, "C", nil, []string{"exit A", "go", "enter C"}},
+		{"to itself", "A", "self", nil, "A", nil, []string{"exit A", "enter A"}},
+		{"internal", "B", "stay", nil, "B", nil, []string{"stay"}},
+		{"back", "B", "back", nil, "A", nil, []string{"exit B", "enter A"}},
+		{"any state", "C", "reset", nil, "A", nil, []string{"exit C", "enter A"}},
+		{"any state, to itself", "A", "reset", nil, "A", nil, []string{"exit A", "enter A"}},
+		{"guarded", "C", "blocked", nil, "C", ErrFsmGuarded, nil},
+		{"no transition", "B", "go", true, "B", ErrFsmInvalid, nil},
+		{"unknown event", "A", "nonsense", nil, "A", ErrFsmInvalid, nil},
+		{"posted", "A", "first", nil, "C", nil, []string{"exit A", "first", "enter B", "exit B", "enter C"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var trace []string
+			fsm := traceTable(t, &trace).New(test.name, test.from)
+			if can := fsm.Can(test.event, test.data); can != (test.wantErr == nil) {
+				t.Errorf("Can %s in %s = %v", test.event, test.from, can)
+			}
+			if err := fsm.Fire(test.event, test.data); !errors.Is(err, test.wantErr) {
+				t.Errorf("Fire %s in %s: err %v, want %v", test.event, test.from, err, test.wantErr)
+			}
+			if got := fsm.State(); got != test.want {
+				t.Errorf("Fire %s in %s: state %s, want %s", test.event, test.from, got, test.want)
+			}
+			if !reflect.DeepEqual(trace, test.wantTrace) {
+				t.Errorf("Fire %s in %s: ran %v, want %v", test.event, test.from, trace, test.wantTrace)
+			}
+		})
+	}
+}
+
+// Post with no event running fires at once
+func TestFsmPost(t *testing.T) {
+	var trace []string
+	fsm := traceTable(t, &trace).New("post", "B")
+	fsm.Post("back", nil)
+	if fsm.State() != "A" {
+		t.Errorf("state %s after Post back, want A", fsm.State())
+	}
+}
+
+// a transition from or to an undeclared state adds none of them
+func TestFsmAddTransitions(t *testing.T) {
+	table := NewFsmTable("test")
+	table.AddState("A", nil, nil)
+	for _, transition := range []FsmTransition{
+		{From: "X", Event: "go", To: "A"},
+		{From: "A", Event: "go", To: "X"},
+	} {
+		err := table.AddTransitions(FsmTransition{From: "A", Event: "ok", To: "A"}, transition)
+		if err == nil {
+			t.Errorf("%s --%s--> %s added", transition.From, transition.Event, transition.To)
+		}
+	}
+	if fsm := table.New("test", "A"); fsm.Can("ok", nil) {
+		t.Errorf("transitions added along with a bad one")
+	}
+}
@@ -0,0 +1,222 @@
+//=============================================================================
+// FILE NAME: tbGround.go
+// DESCRIPTION:
+// Reaching the ground station from nodes out of its radio range.
+// Every node puts its distance to the ground and its radio range into its
+// DISCOVERY messages; a node is in range of the ground if the distance is
+// known and within the range, or if it hears the ground directly.
+// Among itself and the neighbors in range, each node elects as the ground
+// forwarder the one closest to the ground, the lowest id on a tie. Neighbors
+// see mostly the same candidates and so elect the same forwarder, while
+// distant parts of the mesh end up with one of their own.
+// ControlPlaneGroundSend sends to the ground directly when in range, otherwise
+// to the forwarder, which passes it on with ControlPlaneGroundForward.
+// Neighbors not heard of for GROUND_TIMEOUT msec are dropped, and the forwarder
+// is elected again whenever a candidate comes, goes, or moves out of range.
+//================================================================================
+package common
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type GroundCandidate struct {
+	Id       int
+	Address  string  // IP:Port to unicast to
+	Distance float64 // to the ground station, GROUND_DISTANCE_UNKNOWN if not known
+	Range    float64 // radio range to the ground station
+	Updated  time.Time
+}
+
+// InRange - close enough to talk to the ground station. Nodes that do not send
+// their range yet leave it 0, and are never in range
+func (c GroundCandidate) InRange() bool {
+	return c.Range > 0 && c.Distance >= 0 && c.Distance <= c.Range
+}
+
+type GroundService struct {
+	Timeout time.Duration // neighbors not heard of for this long are dropped
+
+	selfId        int
+	mutex         sync.Mutex
+	distance      float64 // ours, as configured or moved to
+	radioRange    float64
+	groundAddress string // where we heard the ground from
+	groundHeard   time.Time
+	neighbors     map[int]*GroundCandidate // by Id
+	forwarder     GroundCandidate          // Id 0 = none
+}
+
+func NewGroundService(selfId int, distance, radioRange float64) *GroundService {
+	return &GroundService{
+		Timeout:    GROUND_TIMEOUT * time.Millisecond,
+		selfId:     selfId,
+		distance:   distance,
+		radioRange: radioRange,
+		neighbors:  make(map[int]*GroundCandidate),
+	}
+}
+
+//====================================================================================
+// SetDistance - we moved, distance is to the ground, GROUND_DISTANCE_UNKNOWN if not known
+//====================================================================================
+func (g *GroundService) SetDistance(distance, radioRange float64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.distance = distance
+	g.radioRange = radioRange
+	g.elect(time.Now())
+}
+
+//====================================================================================
+// HeardGround - a message came directly from the ground station, at address
+//====================================================================================
+func (g *GroundService) HeardGround(address string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.groundAddress = address
+	g.groundHeard = time.Now()
+	g.elect(g.groundHeard)
+}
+
+//====================================================================================
+// Own - distance and range to put in our DISCOVERY. If we hear the ground without
+// knowing how far it is we claim the edge of our range: in range, but anybody
+// who does know is a better forwarder
+//====================================================================================
+func (g *GroundService) Own() (distance, radioRange float64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	own := g.self(time.Now())
+	return own.Distance, own.Range
+}
+
+//====================================================================================
+// Update - neighbor id, at address, advertised its distance and range to ground
+//====================================================================================
+func (g *GroundService) Update(id int, address string, distance, radioRange float64) {
+	if id == g.selfId {
+		return
+	}
+	now := time.Now()
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.neighbors[id] = &GroundCandidate{Id: id, Address: address, Distance: distance, Range: radioRange, Updated: now}
+	g.elect(now)
+}
+
+//====================================================================================
+// Expire - drop neighbors not heard of for Timeout, and elect again
+//====================================================================================
+func (g *GroundService) Expire(now time.Time) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for id, neighbor := range g.neighbors {
+		if now.Sub(neighbor.Updated) > g.Timeout {
+			delete(g.neighbors, id)
+		}
+	}
+	g.elect(now)
+}
+
+// Forwarder - the elected forwarder, Id is ours if it is us; false if nobody is in range
+func (g *GroundService) Forwarder() (GroundCandidate, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.forwarder, g.forwarder.Id != 0
+}
+
+// IsForwarder - true if we were elected
+func (g *GroundService) IsForwarder() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.forwarder.Id == g.selfId
+}
+
+//====================================================================================
+// Next - where to unicast something for the ground: the ground itself if we
+// are in range of it, otherwise the forwarder. "" if neither is known
+//====================================================================================
+func (g *GroundService) Next() string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.groundAddress != "" && g.self(time.Now()).InRange() {
+		return g.groundAddress
+	}
+	if g.forwarder.Id != 0 && g.forwarder.Id != g.selfId {
+		return g.forwarder.Address
+	}
+	return ""
+}
+
+// self - us as a candidate
+func (g *GroundService) self(now time.Time) GroundCandidate {
+	own := GroundCandidate{Id: g.selfId, Address: g.groundAddress, Distance: g.distance, Range: g.radioRange,
+		Updated: now}
+	heard := !g.groundHeard.IsZero() && now.Sub(g.groundHeard) <= g.Timeout
+	if heard && !own.InRange() {
+		own.Distance = own.Range
+	}
+	return own
+}
+
+// elect - closest to the ground among us and our neighbors in range, lowest id on a tie
+func (g *GroundService) elect(now time.Time) {
+	var best GroundCandidate
+	candidates := []GroundCandidate{g.self(now)}
+	for _, neighbor := range g.neighbors {
+		candidates = append(candidates, *neighbor)
+	}
+	for _, candidate := range candidates {
+		if !candidate.InRange() {
+			continue
+		}
+		if best.Id == 0 || candidate.Distance < best.Distance ||
+			(candidate.Distance == best.Distance && candidate.Id < best.Id) {
+			best = candidate
+		}
+	}
+	if best.Id != g.forwarder.Id {
+		if best.Id == 0 {
+			fmt.Println("GROUND: NO FORWARDER, nobody around is in range of the ground")
+		} else {
+			fmt.Println("GROUND: FORWARDER is", best.Id, " distance=", best.Distance, " range=", best.Range)
+		}
+	}
+	g.forwarder = best
+}
+
+//====================================================================================
+// ControlPlaneGroundSend - unicast msgOut to the ground station, through the
+// forwarder if we are out of range
+//====================================================================================
+func ControlPlaneGroundSend(connectivity ConnectivityInfo, msgOut []byte) error {
+	if connectivity.Ground == nil {
+		return ErrNoRoute
+	}
+	address := connectivity.Ground.Next()
+	if address == "" {
+		return fmt.Errorf("%w %d", ErrNoRoute, GROUND_STATION_ID)
+	}
+	ControlPlaneUnicastSend(connectivity, msgOut, address)
+	return nil
+}
+
+//====================================================================================
+// ControlPlaneGroundForward - pass a message unicast to us for the ground station
+// on towards it. Returns false if it is not one, Ttl is used up, or we know no way
+//====================================================================================
+func ControlPlaneGroundForward(connectivity *ConnectivityInfo, packet InboundPacket, msgHeader *MessageHeader) bool {
+	if packet.Socket != SOCKET_UNICAST || msgHeader.DstId != GROUND_STATION_ID || msgHeader.Ttl <= 1 ||
+		connectivity.Ground == nil {
+		return false
+	}
+	msgOut, err := relayCopy(connectivity, packet.Payload, msgHeader)
+	if err != nil {
+		fmt.Println("GROUND: ERROR forwarding", msgHeader.MsgCode, " from SrcID=", msgHeader.SrcId, " err=", err)
+		return false
+	}
+	return ControlPlaneGroundSend(*connectivity, msgOut) == nil
+}
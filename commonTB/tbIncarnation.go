@@ -0,0 +1,110 @@
+//=============================================================================
+// FILE NAME: tbIncarnation.go
+// DESCRIPTION:
+// Telling when a peer restarted. Every node picks its incarnation # once, when
+// it starts, and sends it as DiscoveryMsgBody.TimeCreated; IncarnationTracker
+// remembers the last one seen from each peer, and a different one means the
+// peer restarted. Peers that send no incarnation # (0) are taken to have
+// restarted when their sequence numbers start over, i.e. drop back below
+// INCARNATION_SEQ_RESET. Listeners registered with Subscribe are told about
+// every restart, after the tracker is unlocked, so they can drop whatever
+// they kept about the old incarnation: duplicate state, counters, sessions.
+// Typical use:
+//   discBody.TimeCreated = common.TBincarnation(Node.TimeCreated)
+//   Node.Incarnations.Subscribe(peerRestarted)
+//   Node.Incarnations.Observe(msgHeader.SrcId, discoveryMsg.TimeCreated, msgHeader.SrcSeq)
+//================================================================================
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+type IncarnationEvent struct {
+	Id  int
+	Old float64 // incarnation #, 0 if the peer sends none
+	New float64
+	Seq int // first SrcSeq of the new incarnation we saw
+}
+
+type IncarnationListener func(event IncarnationEvent)
+
+type peerIncarnation struct {
+	incarnation float64
+	lastSeq     int
+}
+
+type IncarnationTracker struct {
+	mutex     sync.Mutex
+	peers     map[int]*peerIncarnation // by SrcId
+	listeners []IncarnationListener
+	restarts  int64
+}
+
+func NewIncarnationTracker() *IncarnationTracker {
+	return &IncarnationTracker{peers: make(map[int]*peerIncarnation)}
+}
+
+// TBincarnation - our incarnation #, from the time we started
+func TBincarnation(created time.Time) float64 {
+	return float64(created.UnixNano())
+}
+
+// Subscribe - listener is called for every restart from now on
+func (t *IncarnationTracker) Subscribe(listener IncarnationListener) {
+	t.mutex.Lock()
+	t.listeners = append(t.listeners, listener)
+	t.mutex.Unlock()
+}
+
+//====================================================================================
+// Observe - peer id sent seq with incarnation #. Returns true, after telling the
+// listeners, if it restarted since we last heard from it. The first time we hear
+// of id is not a restart
+//====================================================================================
+func (t *IncarnationTracker) Observe(id int, incarnation float64, seq int) bool {
+	t.mutex.Lock()
+	peer, ok := t.peers[id]
+	if !ok {
+		t.peers[id] = &peerIncarnation{incarnation: incarnation, lastSeq: seq}
+		t.mutex.Unlock()
+		return false
+	}
+	restarted := false
+	if incarnation != 0 || peer.incarnation != 0 {
+		restarted = incarnation != peer.incarnation
+	} else {
+		// no incarnation #, did its sequence start over ?
+		behind := (peer.lastSeq - seq + MAX_MSG_SEQUENCE) % MAX_MSG_SEQUENCE
+		restarted = behind > 0 && behind < MAX_MSG_SEQUENCE/2 && seq < INCARNATION_SEQ_RESET
+	}
+	event := IncarnationEvent{Id: id, Old: peer.incarnation, New: incarnation, Seq: seq}
+	peer.incarnation = incarnation
+	peer.lastSeq = seq
+	var listeners []IncarnationListener
+	if restarted {
+		t.restarts++
+		listeners = t.listeners
+	}
+	t.mutex.Unlock()
+
+	for _, listener := range listeners {
+		listener(event)
+	}
+	return restarted
+}
+
+// Forget - drop what we know about id, the next one heard from it is new
+func (t *IncarnationTracker) Forget(id int) {
+	t.mutex.Lock()
+	delete(t.peers, id)
+	t.mutex.Unlock()
+}
+
+// Restarts - how many restarts Observe reported so far
+func (t *IncarnationTracker) Restarts() int64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.restarts
+}
@@ -0,0 +1,218 @@
+//=============================================================================
+// FILE NAME: tbLiveness.go
+// DESCRIPTION:
+// Liveness of the terminals an M3 talks to. Every terminal heard from is UP;
+// one silent for two of its hello intervals, a hello missed, is SUSPECT, and
+// one silent for the dead interval, and at least three of its hello intervals,
+// is DOWN. A terminal's hello interval is what its DISCOVERY says, ours if it
+// says nothing. Hearing from it again brings it back UP.
+// Check tells the caller which terminals are due a hello, one every hello
+// interval to each terminal not DOWN, and listeners registered with Subscribe
+// are told about every state change, after the monitor is unlocked.
+// Typical use:
+//   M3.Liveness = common.NewLivenessMonitor(helloInterval, deadInterval)
+//   M3.Liveness.Subscribe(livenessEvent)
+//   M3.Liveness.HeardHello(msgHeader.SrcId, interval) // on every hello received
+//   M3.Liveness.Heard(msgHeader.SrcId)                 // on anything else
+//   for _, id := range M3.Liveness.Check(tick) { ... send hello to id ... }
+//================================================================================
+package common
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const LIVENESS_UP = "UP"
+const LIVENESS_SUSPECT = "SUSPECT"
+const LIVENESS_DOWN = "DOWN"
+
+type Liveness struct {
+	Id            int
+	State         string // LIVENESS_UP, LIVENESS_SUSPECT or LIVENESS_DOWN
+	LastHeard     time.Time
+	LastHelloSent time.Time
+	HelloInterval time.Duration // between its hellos to us, 0 = not known
+}
+
+type LivenessEvent struct {
+	Id        int
+	From      string // "" the first time we hear of Id
+	To        string
+	LastHeard time.Time
+}
+
+type LivenessListener func(event LivenessEvent)
+
+type LivenessMonitor struct {
+	HelloInterval time.Duration // between our hellos to each terminal, and theirs unless they say
+	DeadInterval  time.Duration // silent for this long is DOWN, if that is three of their hellos
+
+	mutex     sync.Mutex
+	terminals map[int]*Liveness // by Id
+	listeners []LivenessListener
+}
+
+//====================================================================================
+// NewLivenessMonitor - intervals of 0 or less take LIVENESS_HELLO_INTERVAL and
+// LIVENESS_DEAD_INTERVAL
+//====================================================================================
+func NewLivenessMonitor(helloInterval, deadInterval time.Duration) *LivenessMonitor {
+	if helloInterval <= 0 {
+		helloInterval = LIVENESS_HELLO_INTERVAL * time.Millisecond
+	}
+	if deadInterval <= 0 {
+		deadInterval = LIVENESS_DEAD_INTERVAL * time.Millisecond
+	}
+	return &LivenessMonitor{
+		HelloInterval: helloInterval,
+		DeadInterval:  deadInterval,
+		terminals:     make(map[int]*Liveness),
+	}
+}
+
+// Subscribe - listener is called for every state change from now on
+func (l *LivenessMonitor) Subscribe(listener LivenessListener) {
+	l.mutex.Lock()
+	l.listeners = append(l.listeners, listener)
+	l.mutex.Unlock()
+}
+
+//====================================================================================
+// Heard - terminal id said something, it is UP
+//====================================================================================
+func (l *LivenessMonitor) Heard(id int) {
+	l.HeardHello(id, 0)
+}
+
+//====================================================================================
+// HeardHello - terminal id said hello, and says the next one comes within
+// helloInterval, 0 if it does not say
+//====================================================================================
+func (l *LivenessMonitor) HeardHello(id int, helloInterval time.Duration) {
+	now := time.Now()
+	l.mutex.Lock()
+	terminal, ok := l.terminals[id]
+	if !ok {
+		terminal = &Liveness{Id: id}
+		l.terminals[id] = terminal
+	}
+	terminal.LastHeard = now
+	if helloInterval > 0 {
+		terminal.HelloInterval = helloInterval
+	}
+	var events []LivenessEvent
+	if terminal.State != LIVENESS_UP {
+		events = append(events, l.change(terminal, LIVENESS_UP))
+	}
+	listeners := l.listeners
+	l.mutex.Unlock()
+
+	l.notify(listeners, events)
+}
+
+//====================================================================================
+// Check - move terminals silent for too long to SUSPECT or DOWN, and return the
+// ids of those due a hello, which are then counted as sent one
+//====================================================================================
+func (l *LivenessMonitor) Check(now time.Time) []int {
+	l.mutex.Lock()
+	var events []LivenessEvent
+	var due []int
+	for _, terminal := range l.terminals {
+		silent := now.Sub(terminal.LastHeard)
+		suspectAfter, deadAfter := l.thresholds(terminal)
+		switch {
+		case silent > deadAfter:
+			if terminal.State != LIVENESS_DOWN {
+				events = append(events, l.change(terminal, LIVENESS_DOWN))
+			}
+		case silent > suspectAfter:
+			if terminal.State != LIVENESS_SUSPECT {
+				events = append(events, l.change(terminal, LIVENESS_SUSPECT))
+			}
+		}
+		if terminal.State != LIVENESS_DOWN && now.Sub(terminal.LastHelloSent) >= l.HelloInterval {
+			terminal.LastHelloSent = now
+			due = append(due, terminal.Id)
+		}
+	}
+	listeners := l.listeners
+	l.mutex.Unlock()
+
+	sort.Ints(due)
+	sort.Slice(events, func(i, j int) bool { return events[i].Id < events[j].Id })
+	l.notify(listeners, events)
+	return due
+}
+
+// Remove - forget terminal id, without an event
+func (l *LivenessMonitor) Remove(id int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.terminals, id)
+}
+
+// DeadAfter - how long terminal id may be silent before it is DOWN
+func (l *LivenessMonitor) DeadAfter(id int) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	terminal, ok := l.terminals[id]
+	if !ok {
+		terminal = &Liveness{}
+	}
+	_, deadAfter := l.thresholds(terminal)
+	return deadAfter
+}
+
+// Get - liveness of terminal id
+func (l *LivenessMonitor) Get(id int) (Liveness, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	terminal, ok := l.terminals[id]
+	if !ok {
+		return Liveness{}, false
+	}
+	return *terminal, true
+}
+
+// Snapshot - copy of all terminals, by id
+func (l *LivenessMonitor) Snapshot() []Liveness {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	terminals := make([]Liveness, 0, len(l.terminals))
+	for _, terminal := range l.terminals {
+		terminals = append(terminals, *terminal)
+	}
+	sort.Slice(terminals, func(i, j int) bool { return terminals[i].Id < terminals[j].Id })
+	return terminals
+}
+
+// thresholds - silent this long is SUSPECT, and this long DOWN, by the
+// terminal's hello interval
+func (l *LivenessMonitor) thresholds(terminal *Liveness) (time.Duration, time.Duration) {
+	interval := terminal.HelloInterval
+	if interval <= 0 {
+		interval = l.HelloInterval
+	}
+	deadAfter := l.DeadInterval
+	if deadAfter < 3*interval {
+		deadAfter = 3 * interval
+	}
+	return 2 * interval, deadAfter
+}
+
+func (l *LivenessMonitor) change(terminal *Liveness, state string) LivenessEvent {
+	event := LivenessEvent{Id: terminal.Id, From: terminal.State, To: state, LastHeard: terminal.LastHeard}
+	terminal.State = state
+	return event
+}
+
+func (l *LivenessMonitor) notify(listeners []LivenessListener, events []LivenessEvent) {
+	for _, event := range events {
+		for _, listener := range listeners {
+			listener(event)
+		}
+	}
+}
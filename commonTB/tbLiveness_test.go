@@ -0,0 +1,40 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+//====================================================================================
+// SUSPECT and DOWN go by the hello interval each terminal told us, ours if it
+// told us nothing, and DOWN never comes before DeadInterval
+//====================================================================================
+func TestLivenessPeerHelloInterval(t *testing.T) {
+	liveness := NewLivenessMonitor(time.Second, 4*time.Second)
+	start := time.Now()
+	liveness.Heard(2)                             // ours, 1 s
+	liveness.HeardHello(3, 1300*time.Millisecond) // an M2 on a 300 ms tick
+	liveness.HeardHello(4, 2*time.Second)
+
+	tests := []struct {
+		after time.Duration
+		want  map[int]string
+	}{
+		{1900 * time.Millisecond, map[int]string{2: LIVENESS_UP, 3: LIVENESS_UP, 4: LIVENESS_UP}},
+		{2100 * time.Millisecond, map[int]string{2: LIVENESS_SUSPECT, 3: LIVENESS_UP, 4: LIVENESS_UP}},
+		{2700 * time.Millisecond, map[int]string{2: LIVENESS_SUSPECT, 3: LIVENESS_SUSPECT, 4: LIVENESS_UP}},
+		{4100 * time.Millisecond, map[int]string{2: LIVENESS_DOWN, 3: LIVENESS_DOWN, 4: LIVENESS_SUSPECT}},
+		{6100 * time.Millisecond, map[int]string{2: LIVENESS_DOWN, 3: LIVENESS_DOWN, 4: LIVENESS_DOWN}},
+	}
+	for _, test := range tests {
+		liveness.Check(start.Add(test.after))
+		for id, want := range test.want {
+			if got, _ := liveness.Get(id); got.State != want {
+				t.Errorf("after %v terminal %d is %s, want %s", test.after, id, got.State, want)
+			}
+		}
+	}
+	if deadAfter := liveness.DeadAfter(4); deadAfter != 6*time.Second {
+		t.Errorf("terminal 4 DOWN after %v, want 6s", deadAfter)
+	}
+}
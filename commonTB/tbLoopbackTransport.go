@@ -0,0 +1,226 @@
+//=============================================================================
+// FILE NAME: tbLoopbackTransport.go
+// DESCRIPTION:
+// In-process, channel based Transport. All terminals created from the same
+// LoopbackNetwork can talk to each other without any sockets, so one M3 and
+// several M2s can run inside one process (tests, simulations).
+// Delivery follows UDP rules: packets go to the node whose IP matches and are
+// handed to the unicast or broadcast endpoint listening on the destination
+// port; multicast goes to every other node that joined the same group;
+// a full receive queue drops the packet.
+// Typical use:
+//   network := common.NewLoopbackNetwork()
+//   M2.M2Connectivity.Transport = network.NewTransport("10.0.0.2")
+//   err := common.ControlPlaneInit(&M2.M2Connectivity, Node.Channels)
+//================================================================================
+package common
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+const LOOPBACK_QUEUE_SIZE = 1024 // packets waiting per endpoint before we drop
+
+var ErrLoopbackClosed = fmt.Errorf("loopback transport: %w", net.ErrClosed)
+
+//====================================================================================
+// LoopbackNetwork - the "wire" shared by all loopback transports
+//====================================================================================
+type LoopbackNetwork struct {
+	mutex sync.Mutex
+	nodes map[string]*LoopbackTransport // by IP
+}
+
+func NewLoopbackNetwork() *LoopbackNetwork {
+	return &LoopbackNetwork{nodes: make(map[string]*LoopbackTransport)}
+}
+
+// NewTransport creates a transport for the node with the given IP. The node
+// becomes reachable once the control plane opens it, and can be opened again
+// after Close to restart the node.
+func (network *LoopbackNetwork) NewTransport(ip string) *LoopbackTransport {
+	return &LoopbackTransport{network: network, ip: ip}
+}
+
+//====================================================================================
+// deliver pkt to every node matching ip (all but the sender if ip is empty or
+// a broadcast address) on the endpoint listening on port
+//====================================================================================
+func (network *LoopbackNetwork) deliver(from *LoopbackTransport, pkt []byte, ip, port string) {
+	packet := loopbackPacket{
+		payload: append([]byte(nil), pkt...),
+		sender:  loopbackAddr(net.JoinHostPort(from.ip, from.unicastPort)),
+	}
+	broadcast := ip == "" || ip == "255.255.255.255" || ip == "0.0.0.0"
+
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+	for nodeIP, node := range network.nodes {
+		if broadcast && node == from {
+			continue
+		}
+		if !broadcast && nodeIP != ip {
+			continue
+		}
+		node.enqueue(packet, port)
+	}
+}
+
+//====================================================================================
+// deliver pkt to every other node that joined group
+//====================================================================================
+func (network *LoopbackNetwork) deliverMulticast(from *LoopbackTransport, pkt []byte, group string) {
+	packet := loopbackPacket{
+		payload: append([]byte(nil), pkt...),
+		sender:  loopbackAddr(net.JoinHostPort(from.ip, from.unicastPort)),
+	}
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+	for _, node := range network.nodes {
+		if node == from || node.multicastGroup != group {
+			continue
+		}
+		select {
+		case node.multicastQueue <- packet:
+		default:
+		}
+	}
+}
+
+func (network *LoopbackNetwork) attach(node *LoopbackTransport) {
+	network.mutex.Lock()
+	network.nodes[node.ip] = node
+	network.mutex.Unlock()
+}
+
+func (network *LoopbackNetwork) detach(node *LoopbackTransport) {
+	network.mutex.Lock()
+	if network.nodes[node.ip] == node {
+		delete(network.nodes, node.ip)
+	}
+	network.mutex.Unlock()
+}
+
+//====================================================================================
+// LoopbackTransport - one node on a LoopbackNetwork
+//====================================================================================
+type LoopbackTransport struct {
+	network        *LoopbackNetwork
+	ip             string
+	unicastPort    string
+	broadcastPort  string
+	multicastGroup string // IP:Port of the joined group, empty if none
+	unicastQueue   chan loopbackPacket
+	broadcastQueue chan loopbackPacket
+	multicastQueue chan loopbackPacket
+	closed         chan struct{}
+	closeOnce      sync.Once
+}
+
+type loopbackPacket struct {
+	payload []byte
+	sender  net.Addr
+}
+
+// loopbackAddr is the "IP:Port" of the sending node's unicast endpoint
+type loopbackAddr string
+
+func (a loopbackAddr) Network() string { return "loopback" }
+func (a loopbackAddr) String() string  { return string(a) }
+
+func (t *LoopbackTransport) Open(connectivity *ConnectivityInfo) error {
+	var err error
+	t.unicastPort = connectivity.UnicastRxPort
+	t.broadcastPort = connectivity.BroadcastRxPort
+	t.unicastQueue = make(chan loopbackPacket, LOOPBACK_QUEUE_SIZE)
+	t.broadcastQueue = make(chan loopbackPacket, LOOPBACK_QUEUE_SIZE)
+	t.multicastGroup = connectivity.MulticastAddress
+	t.multicastQueue = make(chan loopbackPacket, LOOPBACK_QUEUE_SIZE)
+	t.closed = make(chan struct{})
+	t.closeOnce = sync.Once{}
+	// senders hand us BroadcastTxStruct, so it has to be there as with UDP
+	connectivity.BroadcastTxStruct, err = net.ResolveUDPAddr("udp", connectivity.BroadcastTxAddress)
+	if err != nil {
+		return err
+	}
+	t.network.attach(t)
+	return nil
+}
+
+func (t *LoopbackTransport) UnicastSend(pkt []byte, address string) error {
+	ip, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	t.network.deliver(t, pkt, ip, port)
+	return nil
+}
+
+func (t *LoopbackTransport) BroadcastSend(pkt []byte, address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	t.network.deliver(t, pkt, "", port)
+	return nil
+}
+
+func (t *LoopbackTransport) MulticastSend(pkt []byte, address string) error {
+	t.network.deliverMulticast(t, pkt, address)
+	return nil
+}
+
+func (t *LoopbackTransport) UnicastReceive(buffer []byte) (int, net.Addr, error) {
+	return t.receive(t.unicastQueue, buffer)
+}
+
+func (t *LoopbackTransport) BroadcastReceive(buffer []byte) (int, net.Addr, error) {
+	return t.receive(t.broadcastQueue, buffer)
+}
+
+func (t *LoopbackTransport) MulticastReceive(buffer []byte) (int, net.Addr, error) {
+	return t.receive(t.multicastQueue, buffer)
+}
+
+func (t *LoopbackTransport) Close() error {
+	if t.closed == nil { // never opened
+		return nil
+	}
+	t.closeOnce.Do(func() {
+		t.network.detach(t)
+		close(t.closed)
+	})
+	return nil
+}
+
+//====================================================================================
+// Hand the packet to the endpoint listening on port, drop it if nobody listens
+// or the endpoint is not keeping up
+//====================================================================================
+func (t *LoopbackTransport) enqueue(packet loopbackPacket, port string) {
+	var queue chan loopbackPacket
+	switch port {
+	case t.unicastPort:
+		queue = t.unicastQueue
+	case t.broadcastPort:
+		queue = t.broadcastQueue
+	default:
+		return
+	}
+	select {
+	case queue <- packet:
+	default:
+	}
+}
+
+func (t *LoopbackTransport) receive(queue chan loopbackPacket, buffer []byte) (int, net.Addr, error) {
+	select {
+	case packet := <-queue:
+		length := copy(buffer, packet.payload)
+		return length, packet.sender, nil
+	case <-t.closed:
+		return 0, nil, ErrLoopbackClosed
+	}
+}
@@ -0,0 +1,220 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+const testTickInterval = 50 * time.Millisecond
+
+// loopbackConnectivity - a node at ip on network, unicast on 4000, broadcast on 4001
+func loopbackConnectivity(network *LoopbackNetwork, ip string) ConnectivityInfo {
+	return ConnectivityInfo{
+		UnicastRxPort:      "4000",
+		BroadcastTxIP:      "255.255.255.255",
+		BroadcastTxPort:    "4001",
+		BroadcastRxPort:    "4001",
+		BroadcastTxAddress: "255.255.255.255:4001",
+		Transport:          network.NewTransport(ip),
+	}
+}
+
+// testNodeConfig - node id at ip, ticking every tick
+func testNodeConfig(id int, ip string, tick time.Duration) NodeConfig {
+	return NodeConfig{Id: id, Name: fmt.Sprint("node", id), IP: ip, Port: "4000", TickInterval: tick}
+}
+
+// startNode - a node playing role, stopped when the test is over
+func startNode(t *testing.T, config NodeConfig, connectivity *ConnectivityInfo, role Role) (*Node, chan []string) {
+	t.Helper()
+	node := NewNode(config, connectivity, role)
+	console := make(chan []string)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := node.Start(ctx, console); err != nil {
+		t.Fatalf("node %d: %v", config.Id, err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		<-node.Done()
+	})
+	return node, console
+}
+
+// eventually - fail unless condition is true within timeout
+func eventually(t *testing.T, timeout time.Duration, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("not %s after %v", what, timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// terminalState - session and liveness of terminal id, as m3 sees it
+func terminalState(m3 *M3Role, id int) (string, string) {
+	term, ok := m3.Info.Terminals.Get(id)
+	if !ok {
+		return "", ""
+	}
+	liveness, _ := m3.Info.Liveness.Get(id)
+	return term.TerminalState, liveness.State
+}
+
+//====================================================================================
+// One M3 and four M2s on a loopback network: the M2s discover the M3 and get a
+// session each; sessions ended or lost by either side are gone on both, and M2s
+// ask for a new one
+//====================================================================================
+func TestLoopbackM3AndFourM2s(t *testing.T) {
+	network := NewLoopbackNetwork()
+
+	m3Info := &M3Info{M3TerminalId: 1, TerminalHelloTimerLength: 100, TerminalDeadInterval: 500,
+		TerminalDiscoveryTimeout: 5000}
+	m3Info.Connectivity = loopbackConnectivity(network, "10.0.0.1")
+	m3 := NewM3Role(m3Info)
+	_, m3Console := startNode(t, testNodeConfig(1, "10.0.0.1", testTickInterval), &m3Info.Connectivity, m3)
+
+	m2s := make(map[int]*M2Role)
+	m2Nodes := make(map[int]*Node)
+	for id := 2; id <= 5; id++ {
+		info := &M2Info{M2TerminalId: id, M2TerminalHelloTimerLength: 100}
+		ip := fmt.Sprint("10.0.0.", id)
+		info.M2Connectivity = loopbackConnectivity(network, ip)
+		m2s[id] = NewM2Role(info)
+		m2Nodes[id], _ = startNode(t, testNodeConfig(id, ip, testTickInterval), &info.M2Connectivity, m2s[id])
+	}
+
+	// discovery, then a session for everybody
+	for id, m2 := range m2s {
+		eventually(t, 3*time.Second, fmt.Sprint("M2 ", id, " CONNECTED"), func() bool {
+			return m2.fsm.State() == SESSION_CONNECTED
+		})
+		eventually(t, time.Second, fmt.Sprint("terminal ", id, " CONNECTED and UP in M3"), func() bool {
+			session, liveness := terminalState(m3, id)
+			return session == SESSION_CONNECTED && liveness == LIVENESS_UP
+		})
+	}
+	if terminals := m3Info.Terminals.Snapshot(); len(terminals) != 4 {
+		t.Fatalf("M3 knows %d terminals, want 4: %v", len(terminals), terminals)
+	}
+
+	// M3 ends the session of 3, which asks for another one
+	m3Console <- []string{"disconnect", "3"}
+	eventually(t, time.Second, "M2 3 DOWN", func() bool { return m2s[3].fsm.State() != SESSION_CONNECTED })
+	eventually(t, 5*time.Second, "M2 3 CONNECTED again", func() bool {
+		session, _ := terminalState(m3, 3)
+		return m2s[3].fsm.State() == SESSION_CONNECTED && session == SESSION_CONNECTED
+	})
+
+	// M3 loses 4, and tells it
+	term, _ := m3Info.Terminals.Get(4)
+	_ = m3.sessionFsm(4).Fire(EVENT_LOST, lostEvent(term, "test"))
+	eventually(t, time.Second, "M2 4 DOWN", func() bool { return m2s[4].fsm.State() != SESSION_CONNECTED })
+	eventually(t, 5*time.Second, "M2 4 CONNECTED again", func() bool {
+		session, _ := terminalState(m3, 4)
+		return m2s[4].fsm.State() == SESSION_CONNECTED && session == SESSION_CONNECTED
+	})
+
+	// M3 forgets the session of 2 without a word, the hellos of 2 find out
+	m3.forgetSession(2)
+	eventually(t, time.Second, "M2 2 DOWN", func() bool { return m2s[2].fsm.State() != SESSION_CONNECTED })
+	eventually(t, 5*time.Second, "M2 2 CONNECTED again", func() bool {
+		session, _ := terminalState(m3, 2)
+		return m2s[2].fsm.State() == SESSION_CONNECTED && session == SESSION_CONNECTED
+	})
+
+	// 5 goes away, it ends its session and then M3 no longer hears it
+	m2Nodes[5].Stop()
+	<-m2Nodes[5].Done()
+	eventually(t, time.Second, "terminal 5 DOWN in M3", func() bool {
+		session, liveness := terminalState(m3, 5)
+		return session == SESSION_DOWN && liveness == LIVENESS_DOWN
+	})
+	for _, id := range []int{2, 3, 4} {
+		if session, liveness := terminalState(m3, id); session != SESSION_CONNECTED || liveness != LIVENESS_UP {
+			t.Errorf("terminal %d %s and %s in M3, want CONNECTED and UP", id, session, liveness)
+		}
+	}
+}
+
+//====================================================================================
+// An M3 takes as its terminals only the nodes it hears itself: 3, two hops away
+// through 2, is not one, our hellos would not get there
+//====================================================================================
+func TestLoopbackM3TerminalsInRange(t *testing.T) {
+	network := NewLoopbackNetwork()
+	m3Info := &M3Info{M3TerminalId: 1, TerminalHelloTimerLength: 100, TerminalDeadInterval: 500,
+		TerminalDiscoveryTimeout: 5000}
+	m3Info.Connectivity = loopbackConnectivity(network, "10.0.0.1")
+	m3 := NewM3Role(m3Info)
+	nodeAt(t, 1, 10, &m3Info.Connectivity, m3)
+	for id, x := range map[int]float64{2: 50, 3: 90} {
+		connectivity := loopbackConnectivity(network, fmt.Sprint("10.0.0.", id))
+		nodeAt(t, id, x, &connectivity, helloRole{})
+	}
+
+	eventually(t, 3*time.Second, "terminal 2 UP in M3", func() bool {
+		_, liveness := terminalState(m3, 2)
+		return liveness == LIVENESS_UP
+	})
+	eventually(t, 3*time.Second, "a route from 1 to 3", func() bool {
+		_, ok := m3Info.Connectivity.Routes.Lookup(3)
+		return ok
+	})
+	if _, ok := m3Info.Terminals.Get(3); ok {
+		t.Errorf("M3 took 3, two hops away, as a terminal")
+	}
+}
+
+//====================================================================================
+// A node stops on a DRONE_TERMINATE from the ground or its controller, and from
+// nobody else
+//====================================================================================
+func TestLoopbackTerminate(t *testing.T) {
+	tests := []struct {
+		name    string
+		srcId   int
+		stopped bool
+	}{
+		{"from a stranger", 3, false},
+		{"from our controller", 2, true},
+		{"from the ground", GROUND_STATION_ID, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			network := NewLoopbackNetwork()
+			config := testNodeConfig(1, "10.0.0.1", testTickInterval)
+			config.ControllerId = 2
+			connectivity := loopbackConnectivity(network, "10.0.0.1")
+			node, _ := startNode(t, config, &connectivity, helloRole{})
+
+			sender := loopbackConnectivity(network, "10.0.0.9")
+			if err := ControlPlaneInit(&sender, MyChannels{}); err != nil {
+				t.Fatal(err)
+			}
+			defer func() { <-ControlPlaneCloseConnections(&sender) }()
+			msgOut, err := TBencode(sender.WireCodec, MsgCodeTerminate{MsgHeader: MessageHeader{
+				MsgCode: MSG_TYPE_DRONE_TERMINATE, SrcId: test.srcId, SrcIP: "10.0.0.9", SrcPort: "4000", SrcSeq: 1,
+				DstId: 1, Ttl: 1}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ControlPlaneUnicastSend(sender, msgOut, "10.0.0.1:4000")
+
+			select {
+			case <-node.Done():
+				if !test.stopped {
+					t.Errorf("stopped by SrcID %d", test.srcId)
+				}
+			case <-time.After(500 * time.Millisecond):
+				if test.stopped {
+					t.Errorf("not stopped by SrcID %d", test.srcId)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+//=============================================================================
+// FILE NAME: tbM2Fsm.go
+// DESCRIPTION:
+// M2 connection logic, as a table driven FSM. M2 is DOWN until the timer has it
+// ask for a session (CONNECTING); the first M3 to accept gets it (CONNECTED)
+// until one of the two ends it, or M3 is not heard from for too long.
+// The session states and events are M3's too, see tbM3Fsm.go
+//================================================================================
+package common
+
+import (
+	"fmt"
+	"net"
+)
+
+// Session states, of M2 and of each terminal in M3
+const SESSION_DOWN = "DOWN"
+const SESSION_CONNECTING = "CONNECTING"
+const SESSION_CONNECTED = "CONNECTED"
+
+// Session FSM events
+const EVENT_TICK = "TICK"                       // M2, timer
+const EVENT_HELLO = "HELLO"                     // DISCOVERY received, in M3 only an M2's in a session
+const EVENT_ACCEPTED = "ACCEPTED"               // M2, CONNECTING received, accepted
+const EVENT_REJECTED = "REJECTED"               // M2, CONNECTING received, rejected
+const EVENT_M3_RESTART = "M3_RESTART"           // M2, our M3 restarted, it has no session with us
+const EVENT_CONNECT = "CONNECT"                 // M3, CONNECT received
+const EVENT_CONFIRMED = "CONFIRMED"             // M3, CONNECTED received
+const EVENT_LOST = "LOST"                       // M3, terminal went DOWN, away or restarted
+const EVENT_PEER_DISCONNECT = "PEER_DISCONNECT" // DISCONNECT received
+const EVENT_CLOSE = "CLOSE"                     // we end the session
+
+// what the events come with, whatever applies
+type m2Event struct {
+	packet       InboundPacket
+	msgHeader    *MessageHeader
+	discoveryMsg *DiscoveryMsgBody
+	session      SessionMsgBody
+	reason       string // CLOSE
+	reliable     bool   // CLOSE, false if we are about to go away
+}
+
+//====================================================================================
+// m2FsmTable - the transition table, actions and guards of role
+//====================================================================================
+func m2FsmTable(r *M2Role) (*FsmTable, error) {
+	table := NewFsmTable("M2")
+	table.AddState(SESSION_DOWN, r.enterDown, nil)
+	table.AddState(SESSION_CONNECTING, r.enterConnecting, nil)
+	table.AddState(SESSION_CONNECTED, r.enterConnected, nil)
+	err := table.AddTransitions(
+		FsmTransition{From: FSM_ANY_STATE, Event: EVENT_HELLO, Action: r.helloReceived},
+		// no session, ask for one
+		FsmTransition{From: SESSION_DOWN, Event: EVENT_TICK, To: SESSION_CONNECTING, Guard: r.connectDue},
+		FsmTransition{From: SESSION_DOWN, Event: EVENT_ACCEPTED, Action: r.refuseSession},
+		FsmTransition{From: SESSION_CONNECTING, Event: EVENT_TICK, Guard: r.connectDue, Action: r.askForSession},
+		FsmTransition{From: SESSION_CONNECTING, Event: EVENT_ACCEPTED, To: SESSION_CONNECTED, Guard: r.ourSession,
+			Action: r.sessionAccepted},
+		FsmTransition{From: SESSION_CONNECTING, Event: EVENT_ACCEPTED, Action: r.refuseSession},
+		FsmTransition{From: SESSION_CONNECTING, Event: EVENT_REJECTED, Guard: r.ourSession, Action: r.sessionRejected},
+		// session up
+		FsmTransition{From: SESSION_CONNECTED, Event: EVENT_TICK, To: SESSION_DOWN, Guard: r.m3Lost, Action: r.logM3Lost},
+		FsmTransition{From: SESSION_CONNECTED, Event: EVENT_TICK, Guard: r.helloDue, Action: r.sendHelloToM3},
+		FsmTransition{From: SESSION_CONNECTED, Event: EVENT_ACCEPTED, Guard: r.ourM3Session, Action: r.confirmSession},
+		FsmTransition{From: SESSION_CONNECTED, Event: EVENT_ACCEPTED, Action: r.refuseSession},
+		FsmTransition{From: SESSION_CONNECTED, Event: EVENT_PEER_DISCONNECT, To: SESSION_DOWN, Guard: r.ourM3Session},
+		FsmTransition{From: SESSION_CONNECTED, Event: EVENT_CLOSE, To: SESSION_DOWN, Action: r.sendDisconnect},
+		FsmTransition{From: SESSION_CONNECTED, Event: EVENT_M3_RESTART, To: SESSION_DOWN},
+	)
+	return table, err
+}
+
+// initFsm - a new FSM, DOWN
+func (r *M2Role) initFsm() error {
+	table, err := m2FsmTable(r)
+	if err != nil {
+		return err
+	}
+	r.fsm = table.New(r.node.Name, SESSION_DOWN)
+	r.node.ChangeState(SESSION_DOWN)
+	return nil
+}
+
+//====================================================================================
+// States
+//====================================================================================
+func (r *M2Role) enterDown(fsm *Fsm, _ string, _ interface{}) {
+	r.Info.M3TerminalId = 0 // we ask for a new session on the next tick
+	r.node.ChangeState(fsm.State())
+}
+
+func (r *M2Role) enterConnecting(fsm *Fsm, event string, data interface{}) {
+	r.Info.M2SessionId++
+	r.node.ChangeState(fsm.State())
+	r.askForSession(fsm, event, data)
+}
+
+func (r *M2Role) enterConnected(fsm *Fsm, _ string, _ interface{}) {
+	r.node.ChangeState(fsm.State())
+}
+
+//====================================================================================
+// Guards
+//====================================================================================
+func (r *M2Role) connectDue(_ *Fsm, _ string, _ interface{}) bool {
+	return TBtimestampMilli()-r.Info.M2TerminalLastHelloSendTime > SESSION_CONNECT_INTERVAL
+}
+
+func (r *M2Role) helloDue(_ *Fsm, _ string, _ interface{}) bool {
+	return TBtimestampMilli()-r.Info.M2TerminalLastHelloSendTime > r.Info.M2TerminalHelloTimerLength
+}
+
+// m3Lost - no word from M3 for the dead interval, and at least three of its hello
+// intervals, as a LivenessMonitor has it: the session is gone
+func (r *M2Role) m3Lost(_ *Fsm, _ string, _ interface{}) bool {
+	deadAfter := int64(LIVENESS_DEAD_INTERVAL)
+	if 3*r.Info.M3HelloInterval > deadAfter {
+		deadAfter = 3 * r.Info.M3HelloInterval
+	}
+	return TBtimestampMilli()-r.Info.M2TerminalLastHelloReceiveTime > deadAfter
+}
+
+// ourSession - the session we asked for
+func (r *M2Role) ourSession(_ *Fsm, _ string, data interface{}) bool {
+	return data.(*m2Event).session.SessionId == r.Info.M2SessionId
+}
+
+// ourM3Session - the session we have, with the M3 we have it with
+func (r *M2Role) ourM3Session(fsm *Fsm, event string, data interface{}) bool {
+	return r.ourSession(fsm, event, data) && data.(*m2Event).msgHeader.SrcId == r.Info.M3TerminalId
+}
+
+//====================================================================================
+// Actions
+//====================================================================================
+func (r *M2Role) helloReceived(_ *Fsm, _ string, data interface{}) {
+	ev := data.(*m2Event)
+	r.helloMessage(ev.packet, ev.msgHeader, ev.discoveryMsg)
+}
+
+// askForSession - tell everybody we are here and ask any M3 for a session
+func (r *M2Role) askForSession(_ *Fsm, _ string, _ interface{}) {
+	r.node.SendDiscovery("")
+	r.sendSessionPacket(MSG_TYPE_CONNECT, 0, "", SessionMsgBody{SessionId: r.Info.M2SessionId}, false)
+	r.Info.M2TerminalLastHelloSendTime = TBtimestampMilli()
+}
+
+func (r *M2Role) sessionAccepted(_ *Fsm, _ string, data interface{}) {
+	ev := data.(*m2Event)
+	replyAddress := ev.packet.ReplyAddress(ev.msgHeader)
+	r.Info.M3TerminalId = ev.msgHeader.SrcId
+	r.Info.M3HelloInterval = 0 // until its first hello says
+	r.Info.M3TerminalIP, r.Info.M3TerminalPort, _ = net.SplitHostPort(replyAddress)
+	r.Info.M2TerminalLastHelloReceiveTime = TBtimestampMilli()
+	r.sendSessionPacket(MSG_TYPE_CONNECTED, r.Info.M3TerminalId, replyAddress, ev.session, true)
+	fmt.Println("SESSION: CONNECTED to M3 ID=", r.Info.M3TerminalId, " at", replyAddress, " session=",
+		ev.session.SessionId)
+}
+
+// confirmSession - our CONNECTED got lost, or crossed a repeated CONNECT
+func (r *M2Role) confirmSession(_ *Fsm, _ string, data interface{}) {
+	ev := data.(*m2Event)
+	r.sendSessionPacket(MSG_TYPE_CONNECTED, r.Info.M3TerminalId, ev.packet.ReplyAddress(ev.msgHeader),
+		ev.session, true)
+}
+
+// refuseSession - an M3 accepted too late, or after another one
+func (r *M2Role) refuseSession(_ *Fsm, _ string, data interface{}) {
+	ev := data.(*m2Event)
+	r.sendSessionPacket(MSG_TYPE_DISCONNECT, ev.msgHeader.SrcId, ev.packet.ReplyAddress(ev.msgHeader),
+		SessionMsgBody{SessionId: ev.session.SessionId, Reason: "not wanted"}, true)
+}
+
+func (r *M2Role) sessionRejected(_ *Fsm, _ string, data interface{}) {
+	ev := data.(*m2Event)
+	fmt.Println("SESSION: REJECTED by M3 ID=", ev.msgHeader.SrcId, " reason=", ev.session.Reason)
+}
+
+func (r *M2Role) logM3Lost(_ *Fsm, _ string, _ interface{}) {
+	fmt.Println("SESSION: LOST M3 ID=", r.Info.M3TerminalId, " session=", r.Info.M2SessionId)
+}
+
+func (r *M2Role) sendHelloToM3(_ *Fsm, _ string, _ interface{}) {
+	r.node.SendHello(r.Info.M3TerminalId, net.JoinHostPort(r.Info.M3TerminalIP, r.Info.M3TerminalPort),
+		r.Info.M2SessionId)
+	r.Info.M2TerminalLastHelloSendTime = TBtimestampMilli()
+}
+
+func (r *M2Role) sendDisconnect(_ *Fsm, _ string, data interface{}) {
+	ev := data.(*m2Event)
+	fmt.Println("SESSION: DISCONNECT from M3 ID=", r.Info.M3TerminalId, " session=", r.Info.M2SessionId,
+		" reason=", ev.reason)
+	r.sendSessionPacket(MSG_TYPE_DISCONNECT, r.Info.M3TerminalId,
+		net.JoinHostPort(r.Info.M3TerminalIP, r.Info.M3TerminalPort),
+		SessionMsgBody{SessionId: r.Info.M2SessionId, Reason: ev.reason}, ev.reliable)
+}
@@ -0,0 +1,128 @@
+package common
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testM2Role - an M2 on a node that never ticks, only the test fires its events
+func testM2Role(t *testing.T) *M2Role {
+	t.Helper()
+	info := &M2Info{M2TerminalId: 2, M2TerminalHelloTimerLength: 1000}
+	info.M2Connectivity = loopbackConnectivity(NewLoopbackNetwork(), "10.0.0.2")
+	r := NewM2Role(info)
+	startNode(t, testNodeConfig(2, "10.0.0.2", time.Hour), &info.M2Connectivity, r)
+	return r
+}
+
+//====================================================================================
+// The M2 transition table: in session 7 with M3 1, which we last said hello to
+// sent msec ago, and heard from heard msec ago
+//====================================================================================
+func TestM2FsmTable(t *testing.T) {
+	r := testM2Role(t)
+	table, err := m2FsmTable(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromM3 := func(srcId, sessionId int) *m2Event {
+		return &m2Event{msgHeader: &MessageHeader{SrcId: srcId, SrcIP: "10.0.0.1", SrcPort: "4000"},
+			session: SessionMsgBody{SessionId: sessionId}, discoveryMsg: &DiscoveryMsgBody{}}
+	}
+	closing := &m2Event{reason: "test", reliable: false}
+
+	tests := []struct {
+		name    string
+		from    string
+		event   string
+		data    *m2Event
+		sent    int64
+		heard   int64
+		want    string
+		wantErr error
+	}{
+		{"connect not due", SESSION_DOWN, EVENT_TICK, nil, 0, 0, SESSION_DOWN, ErrFsmGuarded},
+		{"connect due", SESSION_DOWN, EVENT_TICK, nil, SESSION_CONNECT_INTERVAL + 1, 0, SESSION_CONNECTING, nil},
+		{"hello, no session", SESSION_DOWN, EVENT_HELLO, fromM3(1, 0), 0, 0, SESSION_DOWN, nil},
+		{"accepted too late", SESSION_DOWN, EVENT_ACCEPTED, fromM3(1, 7), 0, 0, SESSION_DOWN, nil},
+		{"disconnect, no session", SESSION_DOWN, EVENT_PEER_DISCONNECT, fromM3(1, 7), 0, 0, SESSION_DOWN,
+			ErrFsmInvalid},
+		{"close, no session", SESSION_DOWN, EVENT_CLOSE, closing, 0, 0, SESSION_DOWN, ErrFsmInvalid},
+		{"M3 restart, no session", SESSION_DOWN, EVENT_M3_RESTART, nil, 0, 0, SESSION_DOWN, ErrFsmInvalid},
+		{"ask again not due", SESSION_CONNECTING, EVENT_TICK, nil, 0, 0, SESSION_CONNECTING, ErrFsmGuarded},
+		{"ask again", SESSION_CONNECTING, EVENT_TICK, nil, SESSION_CONNECT_INTERVAL + 1, 0, SESSION_CONNECTING,
+			nil},
+		{"accepted", SESSION_CONNECTING, EVENT_ACCEPTED, fromM3(1, 7), 0, 0, SESSION_CONNECTED, nil},
+		{"accepted, another session", SESSION_CONNECTING, EVENT_ACCEPTED, fromM3(1, 6), 0, 0, SESSION_CONNECTING,
+			nil},
+		{"rejected", SESSION_CONNECTING, EVENT_REJECTED, fromM3(1, 7), 0, 0, SESSION_CONNECTING, nil},
+		{"rejected, another session", SESSION_CONNECTING, EVENT_REJECTED, fromM3(1, 6), 0, 0, SESSION_CONNECTING,
+			ErrFsmGuarded},
+		{"nothing due", SESSION_CONNECTED, EVENT_TICK, nil, 0, 0, SESSION_CONNECTED, ErrFsmGuarded},
+		{"hello due", SESSION_CONNECTED, EVENT_TICK, nil, 1001, 0, SESSION_CONNECTED, nil},
+		{"M3 lost", SESSION_CONNECTED, EVENT_TICK, nil, 0, LIVENESS_DEAD_INTERVAL + 1, SESSION_DOWN, nil},
+		{"accepted again", SESSION_CONNECTED, EVENT_ACCEPTED, fromM3(1, 7), 0, 0, SESSION_CONNECTED, nil},
+		{"accepted by another M3", SESSION_CONNECTED, EVENT_ACCEPTED, fromM3(3, 7), 0, 0, SESSION_CONNECTED, nil},
+		{"disconnect", SESSION_CONNECTED, EVENT_PEER_DISCONNECT, fromM3(1, 7), 0, 0, SESSION_DOWN, nil},
+		{"disconnect, another session", SESSION_CONNECTED, EVENT_PEER_DISCONNECT, fromM3(1, 6), 0, 0,
+			SESSION_CONNECTED, ErrFsmGuarded},
+		{"disconnect, another M3", SESSION_CONNECTED, EVENT_PEER_DISCONNECT, fromM3(3, 7), 0, 0, SESSION_CONNECTED,
+			ErrFsmGuarded},
+		{"close", SESSION_CONNECTED, EVENT_CLOSE, closing, 0, 0, SESSION_DOWN, nil},
+		{"M3 restart", SESSION_CONNECTED, EVENT_M3_RESTART, nil, 0, 0, SESSION_DOWN, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := TBtimestampMilli()
+			r.Info.M3TerminalId, r.Info.M3TerminalIP, r.Info.M3TerminalPort = 1, "10.0.0.1", "4000"
+			r.Info.M2SessionId = 7
+			r.Info.M2TerminalLastHelloSendTime = now - test.sent
+			r.Info.M2TerminalLastHelloReceiveTime = now - test.heard
+			fsm := table.New(test.name, test.from)
+			var data interface{}
+			if test.data != nil {
+				data = test.data
+			}
+			if can := fsm.Can(test.event, data); can != (test.wantErr == nil) {
+				t.Errorf("Can %s in %s = %v", test.event, test.from, can)
+			}
+			if err := fsm.Fire(test.event, data); !errors.Is(err, test.wantErr) {
+				t.Errorf("Fire %s in %s: err %v, want %v", test.event, test.from, err, test.wantErr)
+			}
+			if got := fsm.State(); got != test.want {
+				t.Errorf("Fire %s in %s: state %s, want %s", test.event, test.from, got, test.want)
+			}
+		})
+	}
+}
+
+//====================================================================================
+// M3 is lost after the dead interval, or three of its hello intervals if they are
+// longer
+//====================================================================================
+func TestM2M3Lost(t *testing.T) {
+	r := testM2Role(t)
+	tests := []struct {
+		name          string
+		helloInterval int64
+		heard         int64
+		lost          bool
+	}{
+		{"heard", 0, 0, false},
+		{"silent a while", 0, LIVENESS_DEAD_INTERVAL - 100, false},
+		{"silent the dead interval", 0, LIVENESS_DEAD_INTERVAL + 1, true},
+		{"short hellos, silent the dead interval", 100, LIVENESS_DEAD_INTERVAL + 1, true},
+		{"long hellos, silent the dead interval", 2000, LIVENESS_DEAD_INTERVAL + 1, false},
+		{"long hellos, silent three of them", 2000, 6001, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r.Info.M3HelloInterval = test.helloInterval
+			r.Info.M2TerminalLastHelloReceiveTime = TBtimestampMilli() - test.heard
+			if lost := r.m3Lost(nil, EVENT_TICK, nil); lost != test.lost {
+				t.Errorf("m3Lost = %v, want %v", lost, test.lost)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+//=============================================================================
+// FILE NAME: tbM2Role.go
+// DESCRIPTION:
+// M2 on a Node: one session with one M3, see tbM2Fsm.go. All an M2 knows is
+// in its M2Role and the M2Info it was given, so several M2s can run side by
+// side in one process, on a LoopbackNetwork for instance.
+// Typical use:
+//   role := common.NewM2Role(&M2)
+//   Node = common.NewNode(config, &M2.M2Connectivity, role)
+//   err := Node.Start(ctx, ConsoleInput)
+//================================================================================
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+type M2Role struct {
+	Info *M2Info // from the config file, and the M3 we have a session with
+
+	node *Node
+	fsm  *Fsm // Node.State follows its state
+}
+
+func NewM2Role(info *M2Info) *M2Role {
+	return &M2Role{Info: info}
+}
+
+// Start - register the messages M2 handles, on top of what the Node does, and
+// start DOWN
+func (r *M2Role) Start(node *Node) error {
+	if r.node != node {
+		r.node = node
+		node.Incarnations.Subscribe(r.peerRestarted)
+	}
+	// M3 tells when we are gone by how often we say hello, see LivenessMonitor
+	node.HelloInterval = time.Duration(r.Info.M2TerminalHelloTimerLength) * time.Millisecond
+	if node.TickInterval > node.HelloInterval {
+		fmt.Println("M2: WARNING tick ", node.TickInterval, " longer than the hello interval ", node.HelloInterval)
+	}
+	err := r.initFsm()
+	if err != nil {
+		return err
+	}
+	// TODO: GROUNDINFO will require some rethinking how to handle
+	// TODO: may need to rebroadcast for nodes that aare out of range
+	// Note that in order to cure the situation where a node might have been out of reach
+	// at the time the STEP message was sent, GROUND will insert the latest value for
+	//the StepMode in all GROUNDINFO messages .... but we need to process those ...
+	node.Subscribe(MSG_TYPE_CONNECTING, func() interface{} { return new(MsgCodeSession) }, r.handleConnectingMsg)
+	node.Subscribe(MSG_TYPE_DISCONNECT, func() interface{} { return new(MsgCodeSession) }, r.handleDisconnectMsg)
+	node.Subscribe(MSG_TYPE_DISCONNECTED, func() interface{} { return new(MsgCodeSession) }, ControlPlaneLogMessage)
+	// accepted, but nothing to do with them yet
+	node.Subscribe(MSG_TYPE_UPDATE, nil, ControlPlaneLogMessage)
+	node.Subscribe(MSG_TYPE_CONNECT, nil, ControlPlaneLogMessage) // other M2s asking M3
+	node.Subscribe(MSG_TYPE_STEP, func() interface{} { return new(MsgCodeStep) }, ControlPlaneLogMessage)
+	return nil
+}
+
+// Tick - hellos, and the session with M3, see tbM2Fsm.go
+func (r *M2Role) Tick(_ *Node, _ time.Time) {
+	_ = r.fsm.Fire(EVENT_TICK, nil)
+}
+
+// Discovery - the FSM knows what to do with them in which state
+func (r *M2Role) Discovery(_ *Node, packet InboundPacket, msgHeader *MessageHeader,
+	discoveryMsg *DiscoveryMsgBody) {
+	_ = r.fsm.Fire(EVENT_HELLO, &m2Event{packet: packet, msgHeader: msgHeader, discoveryMsg: discoveryMsg})
+}
+
+func (r *M2Role) Command(_ *Node, cmd []string) bool {
+	switch cmd[0] {
+	case "disconnect": // end our session with M3, we ask for a new one later
+		if r.fsm.Fire(EVENT_CLOSE, &m2Event{reason: "M2 console", reliable: true}) != nil {
+			fmt.Println("SESSION: no session with M3")
+		}
+	case "help":
+		fmt.Println("MAIN ......... No Help yet")
+	default:
+		return false
+	}
+	return true
+}
+
+func (r *M2Role) Status(_ *Node) {
+	fmt.Println("SESSION: M3=", r.Info.M3TerminalId, " Session=", r.Info.M2SessionId)
+}
+
+// Stop - tell our M3 we are going away, it will not hear an ACK from us anyway
+func (r *M2Role) Stop(_ *Node) {
+	closing := &m2Event{reason: "M2 shutting down"}
+	if r.fsm.Can(EVENT_CLOSE, closing) {
+		_ = r.fsm.Fire(EVENT_CLOSE, closing)
+	}
+}
+
+//====================================================================================
+// A peer restarted: if it is our M3 so is our session
+//====================================================================================
+func (r *M2Role) peerRestarted(event IncarnationEvent) {
+	if event.Id == r.Info.M3TerminalId {
+		_ = r.fsm.Fire(EVENT_M3_RESTART, nil)
+	}
+}
+
+//====================================================================================
+// ControlPlaneMessage CONNECTING - an M3 answered our CONNECT. The first one to
+// accept gets the session, any other is told we do not want it
+//====================================================================================
+func (r *M2Role) handleConnectingMsg(packet InboundPacket, msgHeader *MessageHeader, msg interface{}) {
+	ev := &m2Event{packet: packet, msgHeader: msgHeader, session: msg.(*MsgCodeSession).MsgSession}
+	if ev.session.Accepted {
+		_ = r.fsm.Fire(EVENT_ACCEPTED, ev)
+	} else {
+		_ = r.fsm.Fire(EVENT_REJECTED, ev)
+	}
+}
+
+//====================================================================================
+// ControlPlaneMessage DISCONNECT - M3 ended our session
+//====================================================================================
+func (r *M2Role) handleDisconnectMsg(packet InboundPacket, msgHeader *MessageHeader, msg interface{}) {
+	session := msg.(*MsgCodeSession).MsgSession
+	fmt.Println("SESSION: DISCONNECT from ID=", msgHeader.SrcId, " session=", session.SessionId, " reason=",
+		session.Reason)
+	r.sendSessionPacket(MSG_TYPE_DISCONNECTED, msgHeader.SrcId, packet.ReplyAddress(msgHeader),
+		SessionMsgBody{SessionId: session.SessionId}, false)
+	_ = r.fsm.Fire(EVENT_PEER_DISCONNECT, &m2Event{packet: packet, msgHeader: msgHeader, session: session})
+}
+
+//==========================================================================
+// Me=0, M1=1, M2=2..5
+//===========================================================================
+func (r *M2Role) helloMessage(_ InboundPacket, msgHeader *MessageHeader, discoveryMsg *DiscoveryMsgBody) {
+	r.Info.M2TerminalMsgLastSentAt = discoveryMsg.MsgLastSentAt
+	if msgHeader.SrcId == r.Info.M3TerminalId {
+		r.Info.M2TerminalLastHelloReceiveTime = TBtimestampMilli() // our M3 is still there
+		r.Info.M3HelloInterval = discoveryMsg.HelloInterval
+	}
+}
+
+//=================================================================================
+// Format and send a session msg to M3 dstId at address, or to everybody when
+// address is ""
+//=================================================================================
+func (r *M2Role) sendSessionPacket(msgCode string, dstId int, address string, session SessionMsgBody,
+	reliable bool) {
+	myMsg := MsgCodeSession{
+		MsgHeader:  r.node.Header(msgCode, 3, dstId, address),
+		MsgSession: session,
+	}
+	if reliable && address != "" {
+		err := r.node.SendReliable(&myMsg.MsgHeader, &myMsg, address)
+		if err != nil {
+			fmt.Println("ERROR sending", msgCode, " to", address, " err=", err)
+		}
+		return
+	}
+	r.node.Send(myMsg, address)
+}
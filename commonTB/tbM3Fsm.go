@@ -0,0 +1,170 @@
+//=============================================================================
+// FILE NAME: tbM3Fsm.go
+// DESCRIPTION:
+// M3 connection logic, as a table driven FSM, one per terminal. A terminal is
+// DOWN until its CONNECT is accepted (CONNECTING), and CONNECTED once it
+// confirms, until one of the two ends the session or it goes silent; the
+// terminal is told when we drop its session, and so is an M2 whose hellos are
+// in a session we do not have.
+// TerminalInfo.TerminalState follows the state of its FSM. The states and
+// events are in tbM2Fsm.go
+//================================================================================
+package common
+
+import (
+	"fmt"
+)
+
+// what the events come with, terminalId always, the rest whatever applies
+type m3Event struct {
+	terminalId   int
+	replyAddress string
+	session      SessionMsgBody
+	reason       string // CLOSE, LOST
+	reliable     bool   // CLOSE, false if we are about to go away
+}
+
+//====================================================================================
+// m3FsmTable - the session transition table, actions and guards of role
+//====================================================================================
+func m3FsmTable(r *M3Role) (*FsmTable, error) {
+	table := NewFsmTable("M3 session")
+	table.AddState(SESSION_DOWN, r.enterSessionState, nil)
+	table.AddState(SESSION_CONNECTING, r.enterSessionState, nil)
+	table.AddState(SESSION_CONNECTED, r.enterSessionState, nil)
+	err := table.AddTransitions(
+		FsmTransition{From: SESSION_DOWN, Event: EVENT_CONNECT, To: SESSION_CONNECTING, Guard: r.roomForSession,
+			Action: r.takeSession},
+		FsmTransition{From: SESSION_DOWN, Event: EVENT_CONNECT, Action: r.rejectSession},
+		FsmTransition{From: SESSION_DOWN, Event: EVENT_LOST},
+		// a repeated CONNECT, our CONNECTING crossed it, or a new session
+		FsmTransition{From: SESSION_CONNECTING, Event: EVENT_CONNECT, Action: r.takeSession},
+		FsmTransition{From: SESSION_CONNECTING, Event: EVENT_CONFIRMED, To: SESSION_CONNECTED, Guard: r.sameSession,
+			Action: r.logConnected},
+		// a hello in a session, ours or one we do not have
+		FsmTransition{From: SESSION_DOWN, Event: EVENT_HELLO, Action: r.refuseHello},
+		FsmTransition{From: FSM_ANY_STATE, Event: EVENT_HELLO, Guard: r.sameSession},
+		FsmTransition{From: FSM_ANY_STATE, Event: EVENT_HELLO, Action: r.refuseHello},
+		FsmTransition{From: SESSION_CONNECTED, Event: EVENT_CONNECT, Guard: r.sameSession, Action: r.takeSession},
+		FsmTransition{From: SESSION_CONNECTED, Event: EVENT_CONNECT, To: SESSION_CONNECTING, Action: r.takeSession},
+		FsmTransition{From: SESSION_CONNECTED, Event: EVENT_CONFIRMED, Guard: r.sameSession},
+		// either side ends it
+		FsmTransition{From: SESSION_CONNECTING, Event: EVENT_PEER_DISCONNECT, To: SESSION_DOWN, Guard: r.sameSession},
+		FsmTransition{From: SESSION_CONNECTED, Event: EVENT_PEER_DISCONNECT, To: SESSION_DOWN, Guard: r.sameSession},
+		FsmTransition{From: SESSION_CONNECTING, Event: EVENT_CLOSE, To: SESSION_DOWN, Action: r.sendDisconnect},
+		FsmTransition{From: SESSION_CONNECTED, Event: EVENT_CLOSE, To: SESSION_DOWN, Action: r.sendDisconnect},
+		FsmTransition{From: SESSION_CONNECTING, Event: EVENT_LOST, To: SESSION_DOWN, Action: r.sessionLost},
+		FsmTransition{From: SESSION_CONNECTED, Event: EVENT_LOST, To: SESSION_DOWN, Action: r.sessionLost},
+	)
+	return table, err
+}
+
+// initFsm - build the session transition table, every terminal starts over DOWN
+func (r *M3Role) initFsm() error {
+	table, err := m3FsmTable(r)
+	if err != nil {
+		return err
+	}
+	r.sessionsMutex.Lock()
+	r.sessionTable = table
+	r.sessions = make(map[int]*Fsm)
+	r.sessionsMutex.Unlock()
+	return nil
+}
+
+// lostEvent - LOST of the session term has with us, for reason
+func lostEvent(term TerminalInfo, reason string) *m3Event {
+	return &m3Event{terminalId: term.TerminalId, replyAddress: term.TerminalIPandPort,
+		session: SessionMsgBody{SessionId: term.TerminalSessionId}, reason: reason}
+}
+
+// sessionFsm - the FSM of terminal id, a new one DOWN if it has none yet
+func (r *M3Role) sessionFsm(id int) *Fsm {
+	r.sessionsMutex.Lock()
+	defer r.sessionsMutex.Unlock()
+	fsm, ok := r.sessions[id]
+	if !ok {
+		fsm = r.sessionTable.New(fmt.Sprint("M3 session ", id), SESSION_DOWN)
+		r.sessions[id] = fsm
+	}
+	return fsm
+}
+
+// forgetSession - terminal id is gone from Terminals
+func (r *M3Role) forgetSession(id int) {
+	r.sessionsMutex.Lock()
+	defer r.sessionsMutex.Unlock()
+	delete(r.sessions, id)
+}
+
+//====================================================================================
+// States
+//====================================================================================
+func (r *M3Role) enterSessionState(fsm *Fsm, _ string, data interface{}) {
+	state := fsm.State()
+	r.Info.Terminals.Update(data.(*m3Event).terminalId, func(term *TerminalInfo) { term.TerminalState = state })
+}
+
+//====================================================================================
+// Guards
+//====================================================================================
+// roomForSession - fewer than MaxSessions other terminals have one
+func (r *M3Role) roomForSession(_ *Fsm, _ string, data interface{}) bool {
+	return r.Info.MaxSessions <= 0 || r.countSessions(data.(*m3Event).terminalId) < r.Info.MaxSessions
+}
+
+// sameSession - the session the terminal has with us
+func (r *M3Role) sameSession(_ *Fsm, _ string, data interface{}) bool {
+	ev := data.(*m3Event)
+	term, ok := r.Info.Terminals.Get(ev.terminalId)
+	return ok && term.TerminalSessionId == ev.session.SessionId
+}
+
+//====================================================================================
+// Actions
+//====================================================================================
+func (r *M3Role) takeSession(_ *Fsm, _ string, data interface{}) {
+	ev := data.(*m3Event)
+	r.Info.Terminals.Update(ev.terminalId, func(term *TerminalInfo) { term.TerminalSessionId = ev.session.SessionId })
+	fmt.Println("SESSION: CONNECT from ID=", ev.terminalId, " session=", ev.session.SessionId, " accepted")
+	r.sendSessionPacket(MSG_TYPE_CONNECTING, ev.terminalId, ev.replyAddress,
+		SessionMsgBody{SessionId: ev.session.SessionId, Accepted: true}, true)
+}
+
+func (r *M3Role) rejectSession(_ *Fsm, _ string, data interface{}) {
+	ev := data.(*m3Event)
+	fmt.Println("SESSION: CONNECT from ID=", ev.terminalId, " session=", ev.session.SessionId, " rejected")
+	r.sendSessionPacket(MSG_TYPE_CONNECTING, ev.terminalId, ev.replyAddress,
+		SessionMsgBody{SessionId: ev.session.SessionId, Reason: "all sessions in use"}, true)
+}
+
+func (r *M3Role) logConnected(_ *Fsm, _ string, data interface{}) {
+	ev := data.(*m3Event)
+	fmt.Println("SESSION: CONNECTED terminal ID=", ev.terminalId, " session=", ev.session.SessionId)
+}
+
+// sessionLost - tell the terminal, in case it still hears us, it has to ask again
+func (r *M3Role) sessionLost(_ *Fsm, _ string, data interface{}) {
+	ev := data.(*m3Event)
+	fmt.Println("SESSION: LOST terminal ID=", ev.terminalId, " session=", ev.session.SessionId, " reason=", ev.reason)
+	r.sendSessionPacket(MSG_TYPE_DISCONNECT, ev.terminalId, ev.replyAddress,
+		SessionMsgBody{SessionId: ev.session.SessionId, Reason: ev.reason}, false)
+}
+
+// refuseHello - the terminal thinks it has a session with us, it has not
+func (r *M3Role) refuseHello(_ *Fsm, _ string, data interface{}) {
+	ev := data.(*m3Event)
+	fmt.Println("SESSION: hello from terminal ID=", ev.terminalId, " in session=", ev.session.SessionId,
+		" we do not have")
+	r.sendSessionPacket(MSG_TYPE_DISCONNECT, ev.terminalId, ev.replyAddress,
+		SessionMsgBody{SessionId: ev.session.SessionId, Reason: "no such session"}, false)
+}
+
+func (r *M3Role) sendDisconnect(_ *Fsm, _ string, data interface{}) {
+	ev := data.(*m3Event)
+	term, _ := r.Info.Terminals.Get(ev.terminalId)
+	fmt.Println("SESSION: DISCONNECT terminal ID=", ev.terminalId, " session=", term.TerminalSessionId,
+		" reason=", ev.reason)
+	r.sendSessionPacket(MSG_TYPE_DISCONNECT, ev.terminalId, term.TerminalIPandPort,
+		SessionMsgBody{SessionId: term.TerminalSessionId, Reason: ev.reason}, ev.reliable)
+}
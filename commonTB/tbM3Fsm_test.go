@@ -0,0 +1,101 @@
+package common
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testM3Role - an M3 on a node that never ticks, only the test fires its events
+func testM3Role(t *testing.T) *M3Role {
+	t.Helper()
+	info := &M3Info{M3TerminalId: 1, MaxSessions: 1}
+	info.Connectivity = loopbackConnectivity(NewLoopbackNetwork(), "10.0.0.1")
+	r := NewM3Role(info)
+	startNode(t, testNodeConfig(1, "10.0.0.1", time.Hour), &info.Connectivity, r)
+	return r
+}
+
+//====================================================================================
+// The M3 transition table: terminal 2 in session 7, and one session allowed,
+// which terminal 3 has if busy
+//====================================================================================
+func TestM3FsmTable(t *testing.T) {
+	r := testM3Role(t)
+	table, err := m3FsmTable(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := func(sessionId int) *m3Event {
+		return &m3Event{terminalId: 2, replyAddress: "10.0.0.2:4000", session: SessionMsgBody{SessionId: sessionId}}
+	}
+	closing := &m3Event{terminalId: 2, reason: "test"}
+
+	tests := []struct {
+		name    string
+		from    string
+		event   string
+		data    *m3Event
+		busy    bool
+		want    string
+		wantErr error
+	}{
+		{"connect", SESSION_DOWN, EVENT_CONNECT, session(8), false, SESSION_CONNECTING, nil},
+		{"connect, no room", SESSION_DOWN, EVENT_CONNECT, session(8), true, SESSION_DOWN, nil},
+		{"lost, no session", SESSION_DOWN, EVENT_LOST, session(7), false, SESSION_DOWN, nil},
+		{"hello, no session", SESSION_DOWN, EVENT_HELLO, session(7), false, SESSION_DOWN, nil},
+		{"confirmed, no session", SESSION_DOWN, EVENT_CONFIRMED, session(7), false, SESSION_DOWN, ErrFsmInvalid},
+		{"disconnect, no session", SESSION_DOWN, EVENT_PEER_DISCONNECT, session(7), false, SESSION_DOWN,
+			ErrFsmInvalid},
+		{"close, no session", SESSION_DOWN, EVENT_CLOSE, closing, false, SESSION_DOWN, ErrFsmInvalid},
+		{"connect again", SESSION_CONNECTING, EVENT_CONNECT, session(8), true, SESSION_CONNECTING, nil},
+		{"confirmed", SESSION_CONNECTING, EVENT_CONFIRMED, session(7), false, SESSION_CONNECTED, nil},
+		{"confirmed, another session", SESSION_CONNECTING, EVENT_CONFIRMED, session(6), false, SESSION_CONNECTING,
+			ErrFsmGuarded},
+		{"disconnect before confirmed", SESSION_CONNECTING, EVENT_PEER_DISCONNECT, session(7), false, SESSION_DOWN,
+			nil},
+		{"close before confirmed", SESSION_CONNECTING, EVENT_CLOSE, closing, false, SESSION_DOWN, nil},
+		{"lost before confirmed", SESSION_CONNECTING, EVENT_LOST, session(7), false, SESSION_DOWN, nil},
+		{"connect, same session", SESSION_CONNECTED, EVENT_CONNECT, session(7), true, SESSION_CONNECTED, nil},
+		{"connect, new session", SESSION_CONNECTED, EVENT_CONNECT, session(8), true, SESSION_CONNECTING, nil},
+		{"confirmed again", SESSION_CONNECTED, EVENT_CONFIRMED, session(7), false, SESSION_CONNECTED, nil},
+		{"confirmed, another session", SESSION_CONNECTED, EVENT_CONFIRMED, session(6), false, SESSION_CONNECTED,
+			ErrFsmGuarded},
+		{"hello", SESSION_CONNECTED, EVENT_HELLO, session(7), false, SESSION_CONNECTED, nil},
+		{"hello, another session", SESSION_CONNECTED, EVENT_HELLO, session(6), false, SESSION_CONNECTED, nil},
+		{"disconnect", SESSION_CONNECTED, EVENT_PEER_DISCONNECT, session(7), false, SESSION_DOWN, nil},
+		{"disconnect, another session", SESSION_CONNECTED, EVENT_PEER_DISCONNECT, session(6), false,
+			SESSION_CONNECTED, ErrFsmGuarded},
+		{"close", SESSION_CONNECTED, EVENT_CLOSE, closing, false, SESSION_DOWN, nil},
+		{"lost", SESSION_CONNECTED, EVENT_LOST, session(7), false, SESSION_DOWN, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := func(busy bool) string {
+				if busy {
+					return SESSION_CONNECTED
+				}
+				return SESSION_DOWN
+			}
+			_, _ = r.Info.Terminals.Register(3, func(term *TerminalInfo) { term.TerminalState = state(test.busy) })
+			_, _ = r.Info.Terminals.Register(2, func(term *TerminalInfo) {
+				term.TerminalSessionId = 7
+				term.TerminalIPandPort = "10.0.0.2:4000"
+				term.TerminalState = test.from
+			})
+			fsm := table.New(test.name, test.from)
+			if can := fsm.Can(test.event, test.data); can != (test.wantErr == nil) {
+				t.Errorf("Can %s in %s = %v", test.event, test.from, can)
+			}
+			if err := fsm.Fire(test.event, test.data); !errors.Is(err, test.wantErr) {
+				t.Errorf("Fire %s in %s: err %v, want %v", test.event, test.from, err, test.wantErr)
+			}
+			if got := fsm.State(); got != test.want {
+				t.Errorf("Fire %s in %s: state %s, want %s", test.event, test.from, got, test.want)
+			}
+			if term, _ := r.Info.Terminals.Get(2); term.TerminalState != test.want {
+				t.Errorf("Fire %s in %s: terminal %s, want %s", test.event, test.from, term.TerminalState, test.want)
+			}
+		})
+	}
+}
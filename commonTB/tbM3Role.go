@@ -0,0 +1,377 @@
+//=============================================================================
+// FILE NAME: tbM3Role.go
+// DESCRIPTION:
+// M3 on a Node: the M1 and M2s it knows, in M3Info.Terminals, their liveness
+// and their sessions, one FSM per terminal, see tbM3Fsm.go. All an M3 knows is
+// in its M3Role and the M3Info it was given, so it can run in one process with
+// its M2s, on a LoopbackNetwork for instance.
+// Typical use:
+//   role := common.NewM3Role(&M3)
+//   role.Log = &Log
+//   Node = common.NewNode(config, &M3.Connectivity, role)
+//   err := Node.Start(ctx, ConsoleInput)
+//================================================================================
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type M3Role struct {
+	Info *M3Info      // from the config file, with the terminals we know
+	Log  *LogInstance // liveness changes go there too, nil = only to the console
+
+	node          *Node
+	sessionTable  *FsmTable
+	sessionsMutex sync.Mutex
+	sessions      map[int]*Fsm // by terminal id
+}
+
+//====================================================================================
+// NewM3Role - M3 keeping its terminals in info.Terminals, a new registry of
+// MAX_NODES if it has none
+//====================================================================================
+func NewM3Role(info *M3Info) *M3Role {
+	if info.Terminals == nil {
+		info.Terminals = NewTerminalRegistry(MAX_NODES)
+	}
+	r := &M3Role{Info: info, sessions: make(map[int]*Fsm)}
+	info.Terminals.Subscribe(r.terminalRegistryEvent)
+	return r
+}
+
+// Start - register the messages M3 handles, on top of what the Node does, and
+// start watching the terminals
+func (r *M3Role) Start(node *Node) error {
+	if r.node != node {
+		r.node = node
+		node.Incarnations.Subscribe(r.peerRestarted)
+	}
+	err := r.initFsm()
+	if err != nil {
+		return err
+	}
+	r.Info.Liveness = NewLivenessMonitor(time.Duration(r.Info.TerminalHelloTimerLength)*time.Millisecond,
+		time.Duration(r.Info.TerminalDeadInterval)*time.Millisecond)
+	r.Info.Liveness.Subscribe(r.livenessEvent)
+
+	// TODO: GROUNDINFO will require some rethinking how to handle
+	// TODO: may need to rebroadcast for nodes that aare out of range
+	// Note that in order to cure the situation where a node might have been out of reach
+	// at the time the STEP message was sent, GROUND will insert the latest value for
+	//the StepMode in all GROUNDINFO messages .... but we need to process those ...
+	node.Subscribe(MSG_TYPE_GROUND_INFO, nil, r.handleGroundInfoMsg)
+	node.Subscribe(MSG_TYPE_CONNECT, func() interface{} { return new(MsgCodeSession) }, r.handleConnectMsg)
+	node.Subscribe(MSG_TYPE_CONNECTED, func() interface{} { return new(MsgCodeSession) }, r.handleConnectedMsg)
+	node.Subscribe(MSG_TYPE_DISCONNECT, func() interface{} { return new(MsgCodeSession) }, r.handleDisconnectMsg)
+	node.Subscribe(MSG_TYPE_DISCONNECTED, func() interface{} { return new(MsgCodeSession) }, ControlPlaneLogMessage)
+	// accepted, but nothing to do with them yet
+	node.Subscribe(MSG_TYPE_UPDATE, nil, ControlPlaneLogMessage)
+	node.Subscribe(MSG_TYPE_STEP, func() interface{} { return new(MsgCodeStep) }, ControlPlaneLogMessage)
+	return nil
+}
+
+//====================================================================================
+// Tick - drop the terminals we have not heard of for too long, and say hello to
+// the ones that are still there
+//====================================================================================
+func (r *M3Role) Tick(node *Node, tick time.Time) {
+	currTimeMilliSec := TBtimestampMilli()
+
+	for _, term := range r.Info.Terminals.Snapshot() {
+		// Not a word for TerminalDiscoveryTimeout, and DOWN for a while ? then it is gone
+		removeAfter := time.Duration(r.Info.TerminalDiscoveryTimeout) * time.Millisecond
+		if deadAfter := r.Info.Liveness.DeadAfter(term.TerminalId); removeAfter < 2*deadAfter {
+			removeAfter = 2 * deadAfter
+		}
+		if tick.Sub(term.TerminalMsgLastRcvdAt) > removeAfter {
+			r.Info.Terminals.Remove(term.TerminalId)
+		}
+	}
+	// Hello to every terminal that is not DOWN, once every TerminalHelloTimerLength
+	for _, id := range r.Info.Liveness.Check(tick) {
+		term, ok := r.Info.Terminals.Get(id)
+		if !ok {
+			continue
+		}
+		node.SendHello(id, term.TerminalIPandPort, 0)
+		r.Info.Terminals.Update(id, func(t *TerminalInfo) { t.TerminalLastHelloSendTime = currTimeMilliSec })
+	}
+}
+
+// Discovery - the state of the session with each terminal is in its FSM, see tbM3Fsm.go
+func (r *M3Role) Discovery(_ *Node, packet InboundPacket, msgHeader *MessageHeader,
+	discoveryMsg *DiscoveryMsgBody) {
+	r.discoveryMessage(packet, msgHeader, discoveryMsg)
+}
+
+func (r *M3Role) Command(_ *Node, cmd []string) bool {
+	switch cmd[0] {
+	case "disconnect": // disconnect <terminal id> - end its session
+		id := 0
+		if len(cmd) > 1 {
+			id, _ = strconv.Atoi(cmd[1])
+		}
+		_, ok := r.Info.Terminals.Get(id)
+		if !ok || r.sessionFsm(id).Fire(EVENT_CLOSE, &m3Event{terminalId: id, reason: "M3 console",
+			reliable: true}) != nil {
+			fmt.Println("SESSION: no session with terminal", id)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func (r *M3Role) Status(_ *Node) {
+	for _, term := range r.Info.Terminals.Snapshot() {
+		fmt.Println("TERMINAL: ID=", term.TerminalId, " Name=", term.TerminalName, " at",
+			term.TerminalIPandPort, " MAC=", term.TerminalMac, " Active=", term.TerminalActive,
+			" Session=", term.TerminalState, " Position=", term.TerminalPosition)
+		if liveness, ok := r.Info.Liveness.Get(term.TerminalId); ok {
+			fmt.Println("LIVENESS: ID=", term.TerminalId, " State=", liveness.State,
+				" last heard", liveness.LastHeard)
+		}
+	}
+}
+
+// Stop - end every session, we are going away
+func (r *M3Role) Stop(_ *Node) {
+	r.disconnectAll("M3 shutting down")
+}
+
+//====================================================================================
+// A terminal was added to or removed from Terminals. A terminal that went away
+// and comes back may have restarted, so forget which messages it sent
+//====================================================================================
+func (r *M3Role) terminalRegistryEvent(event RegistryEvent) {
+	term := event.Terminal
+	fmt.Println("TERMINAL", event.Kind, ": ID=", term.TerminalId, " Name=", term.TerminalName,
+		" at", term.TerminalIPandPort)
+	if event.Kind == REGISTRY_REMOVED {
+		r.node.Duplicates.Forget(term.TerminalId)
+		r.node.Incarnations.Forget(term.TerminalId)
+		r.Info.Liveness.Remove(term.TerminalId)
+		_ = r.sessionFsm(term.TerminalId).Fire(EVENT_LOST, lostEvent(term, "terminal gone"))
+		r.forgetSession(term.TerminalId)
+	}
+}
+
+//====================================================================================
+// A terminal went UP, SUSPECT or DOWN. Only DOWN terminals are inactive, they
+// get no hellos until they are heard from again, and lose their session
+//====================================================================================
+func (r *M3Role) livenessEvent(event LivenessEvent) {
+	fmt.Println("LIVENESS: ID=", event.Id, event.From, "->", event.To, " last heard", event.LastHeard)
+	if r.Log != nil {
+		r.Log.Warning(r.Log, "LIVENESS: ID=", event.Id, " ", event.From, " -> ", event.To)
+	}
+	r.Info.Terminals.Update(event.Id, func(t *TerminalInfo) {
+		t.TerminalActive = event.To != LIVENESS_DOWN
+	})
+	if term, ok := r.Info.Terminals.Get(event.Id); ok && event.To == LIVENESS_DOWN {
+		_ = r.sessionFsm(event.Id).Fire(EVENT_LOST, lostEvent(term, "terminal DOWN"))
+	}
+}
+
+//====================================================================================
+// A peer restarted: start its counters over, and if it is one of our terminals,
+// its session is gone
+//====================================================================================
+func (r *M3Role) peerRestarted(event IncarnationEvent) {
+	if !r.Info.Terminals.Update(event.Id, func(term *TerminalInfo) {
+		term.TerminalNextMsgSeq = event.Seq
+		term.TerminalMsgsSent = 0
+		term.TerminalMsgsRcvd = 0
+		term.TerminalReceiveCount = 0
+		term.TerminalSendCount = 0
+	}) {
+		return
+	}
+	term, _ := r.Info.Terminals.Get(event.Id)
+	_ = r.sessionFsm(event.Id).Fire(EVENT_LOST, lostEvent(term, "terminal restarted"))
+}
+
+//====================================================================================
+// ControlPlaneMessage CONNECT - an M2 asks for a session, accept it unless all
+// MaxSessions are in use or there is no room left in Terminals
+//====================================================================================
+func (r *M3Role) handleConnectMsg(packet InboundPacket, msgHeader *MessageHeader, msg interface{}) {
+	sender := msgHeader.SrcId
+	if sender == GROUND_STATION_ID {
+		return
+	}
+	ev := &m3Event{terminalId: sender, replyAddress: packet.ReplyAddress(msgHeader),
+		session: msg.(*MsgCodeSession).MsgSession}
+	_, err := r.Info.Terminals.Register(sender, func(term *TerminalInfo) {
+		term.TerminalName = msgHeader.SrcName
+		term.TerminalIPandPort = ev.replyAddress
+		term.TerminalMsgLastRcvdAt = time.Now()
+	})
+	if err != nil {
+		fmt.Println("SESSION: CONNECT from ID=", sender, " rejected: ", err)
+		r.sendSessionPacket(MSG_TYPE_CONNECTING, sender, ev.replyAddress,
+			SessionMsgBody{SessionId: ev.session.SessionId, Reason: err.Error()}, true)
+		return
+	}
+	r.Info.Liveness.Heard(sender)
+	_ = r.sessionFsm(sender).Fire(EVENT_CONNECT, ev)
+}
+
+//====================================================================================
+// ControlPlaneMessage CONNECTED - the M2 confirmed, the session is up. If we do
+// not know the session, tell it so it asks again
+//====================================================================================
+func (r *M3Role) handleConnectedMsg(packet InboundPacket, msgHeader *MessageHeader, msg interface{}) {
+	ev := &m3Event{terminalId: msgHeader.SrcId, replyAddress: packet.ReplyAddress(msgHeader),
+		session: msg.(*MsgCodeSession).MsgSession}
+	if r.sessionFsm(ev.terminalId).Fire(EVENT_CONFIRMED, ev) != nil {
+		r.sendSessionPacket(MSG_TYPE_DISCONNECT, ev.terminalId, ev.replyAddress,
+			SessionMsgBody{SessionId: ev.session.SessionId, Reason: "no such session"}, true)
+	}
+}
+
+//====================================================================================
+// ControlPlaneMessage DISCONNECT - the M2 ends its session
+//====================================================================================
+func (r *M3Role) handleDisconnectMsg(packet InboundPacket, msgHeader *MessageHeader, msg interface{}) {
+	ev := &m3Event{terminalId: msgHeader.SrcId, replyAddress: packet.ReplyAddress(msgHeader),
+		session: msg.(*MsgCodeSession).MsgSession}
+	fmt.Println("SESSION: DISCONNECT from ID=", ev.terminalId, " session=", ev.session.SessionId, " reason=",
+		ev.session.Reason)
+	_ = r.sessionFsm(ev.terminalId).Fire(EVENT_PEER_DISCONNECT, ev)
+	r.sendSessionPacket(MSG_TYPE_DISCONNECTED, ev.terminalId, ev.replyAddress,
+		SessionMsgBody{SessionId: ev.session.SessionId}, false)
+}
+
+// countSessions - terminals other than except that are connected or connecting
+func (r *M3Role) countSessions(except int) int {
+	count := 0
+	for _, term := range r.Info.Terminals.Snapshot() {
+		if term.TerminalId != except &&
+			(term.TerminalState == SESSION_CONNECTING || term.TerminalState == SESSION_CONNECTED) {
+			count++
+		}
+	}
+	return count
+}
+
+// disconnectAll - end every session, we are going away
+func (r *M3Role) disconnectAll(reason string) {
+	for _, term := range r.Info.Terminals.Snapshot() {
+		fsm := r.sessionFsm(term.TerminalId)
+		closing := &m3Event{terminalId: term.TerminalId, reason: reason}
+		if fsm.Can(EVENT_CLOSE, closing) {
+			_ = fsm.Fire(EVENT_CLOSE, closing)
+		}
+	}
+}
+
+//====================================================================================
+// ControlPlaneMessage   GROUNDINFO
+//====================================================================================
+func (r *M3Role) handleGroundInfoMsg(packet InboundPacket, msgHeader *MessageHeader, _ interface{}) {
+	info := r.Info
+	if msgHeader.Hops == 0 {
+		info.Connectivity.Ground.HeardGround(packet.ReplyAddress(msgHeader))
+	}
+	var err error
+	// TODO  ... add to msg the playing field size .... hmm ?? relation to random etc
+	info.GroundFullName.Name = msgHeader.SrcName //.DstName
+	info.GroundIP = packet.SourceIP()            // not msgHeader.SrcIP, the ground may be behind a NAT
+	info.GroundIPandPort = packet.ReplyAddress(msgHeader)
+	_, groundPort, _ := net.SplitHostPort(info.GroundIPandPort)
+	myPort, _ := strconv.Atoi(groundPort)
+	info.GroundUdpPort = myPort
+	info.GroundIsKnown = true //msg.GroundUp
+
+	info.GroundUdpAddrSTR, err = net.ResolveUDPAddr("udp", info.GroundIPandPort)
+	myPort, _ = strconv.Atoi(msgHeader.DstPort)
+	info.GroundUdpAddrSTR = &net.UDPAddr{IP: net.ParseIP(msgHeader.DstIP), Port: myPort}
+	info.GroundFullName = NameId{Name: msgHeader.DstName, Address: *info.GroundUdpAddrSTR}
+
+	if err != nil {
+		fmt.Println("ERROR in net.ResolveUDPAddr = ", err)
+		fmt.Println("ERROR locating master, will retry")
+		return
+	}
+}
+
+//==========================================================================
+// Me=0, M1=1, M2=2..5
+//===========================================================================
+func (r *M3Role) discoveryMessage(packet InboundPacket, msgHeader *MessageHeader,
+	discoveryMsg *DiscoveryMsgBody) {
+	sender := msgHeader.SrcId
+	// TODO ... make sure we only handle configured M1 and M2s
+	if sender == GROUND_STATION_ID {
+		return // the Node checked the range, the ground is not one of our terminals
+	}
+	if msgHeader.Hops > 0 {
+		return // out of our range, our hellos would not get there
+	}
+	// update info for the sending terminal, new ones are added
+	_, err := r.Info.Terminals.Register(sender, func(term *TerminalInfo) {
+		term.TerminalName = msgHeader.SrcName
+		term.TerminalIP = msgHeader.SrcIP
+		if sourceIP := packet.SourceIP(); sourceIP != "" {
+			term.TerminalIP = sourceIP // where it really is, SrcIP may be spoofed or NATed
+		}
+		term.TerminalMac = msgHeader.SrcMAC
+		term.TerminalPort = msgHeader.SrcPort
+		term.TerminalIPandPort = packet.ReplyAddress(msgHeader)
+		term.TerminalNextMsgSeq = msgHeader.SrcSeq
+		// Check if terminal was rebooted
+		term.TerminalTimeCreated = discoveryMsg.TimeCreated // incarnation #
+		term.TerminalLastChangeTime = discoveryMsg.LastChangeTime
+		term.TerminalActive = discoveryMsg.NodeActive
+		term.TerminalMsgsSent = discoveryMsg.MsgsSent
+		term.TerminalMsgsRcvd = discoveryMsg.MsgsRcvd
+		term.TerminalMsgLastSentAt = discoveryMsg.MsgLastSentAt
+		term.TerminalPosition = discoveryMsg.Position
+		term.TerminalMsgLastRcvdAt = time.Now() // TBtimestampNano() // time.Now()
+		term.TerminalLastHelloReceiveTime = TBtimestampMilli()
+		if term.TerminalState == "" {
+			term.TerminalState = SESSION_DOWN // until it CONNECTs
+		}
+
+		term.TerminalActive = true
+	})
+	if err != nil {
+		fmt.Println("DISCOVERY from SrcID=", sender, " not registered: ", err)
+		return
+	}
+	r.Info.Liveness.HeardHello(sender, time.Duration(discoveryMsg.HelloInterval)*time.Millisecond)
+	if discoveryMsg.SessionId != 0 { // an M2's hello, is the session still there ?
+		_ = r.sessionFsm(sender).Fire(EVENT_HELLO, &m3Event{terminalId: sender,
+			replyAddress: packet.ReplyAddress(msgHeader), session: SessionMsgBody{SessionId: discoveryMsg.SessionId}})
+	}
+}
+
+//=================================================================================
+// Format and send a session msg, CONNECTING, DISCONNECT ..., to terminal dstId,
+// along our route to it, or to address, where we heard from it, if we have none
+//=================================================================================
+func (r *M3Role) sendSessionPacket(msgCode string, dstId int, address string, session SessionMsgBody,
+	reliable bool) {
+	myMsg := MsgCodeSession{
+		MsgHeader:  r.node.Header(msgCode, 3, dstId, address),
+		MsgSession: session,
+	}
+	err := r.node.SendTo(&myMsg.MsgHeader, &myMsg, reliable)
+	if errors.Is(err, ErrNoRoute) {
+		if !reliable {
+			r.node.Send(myMsg, address)
+			return
+		}
+		err = r.node.SendReliable(&myMsg.MsgHeader, &myMsg, address)
+	}
+	if err != nil {
+		fmt.Println("ERROR sending", msgCode, " to ID=", dstId, " at", address, " err=", err)
+	}
+}
@@ -0,0 +1,282 @@
+//=============================================================================
+// FILE NAME: tbMatrix.go
+// DESCRIPTION:
+// Connectivity matrix of the mesh: for every node, the set of nodes it hears.
+// Each node records who it hears DISCOVERY from directly, and sends that set
+// as a BitMask in its own DISCOVERY messages; relayed DISCOVERYs carry the
+// sets of nodes further away, so every node ends up with a row per node.
+// Row N holds the nodes N hears, i.e. the nodes that reach N in one hop.
+// ReachableInHops walks the rows back from a node to find who reaches it in
+// k hops. Rows and neighbors not heard of for MATRIX_TIMEOUT msec are dropped.
+// BitMask has no fixed size, so node ids above 64 work the same.
+//================================================================================
+package common
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//====================================================================================
+// BitMask
+//====================================================================================
+
+// Set - add node id
+func (b *BitMask) Set(id int) {
+	if id < 0 {
+		return
+	}
+	for len(b.Words) <= id/64 {
+		b.Words = append(b.Words, 0)
+	}
+	b.Words[id/64] |= 1 << uint(id%64)
+}
+
+// Clear - remove node id
+func (b *BitMask) Clear(id int) {
+	if id < 0 || id/64 >= len(b.Words) {
+		return
+	}
+	b.Words[id/64] &^= 1 << uint(id%64)
+}
+
+// Has - true if node id is in the set
+func (b BitMask) Has(id int) bool {
+	if id < 0 || id/64 >= len(b.Words) {
+		return false
+	}
+	return b.Words[id/64]&(1<<uint(id%64)) != 0
+}
+
+// Count - number of nodes in the set
+func (b BitMask) Count() int {
+	count := 0
+	for _, word := range b.Words {
+		count += bits.OnesCount64(word)
+	}
+	return count
+}
+
+// IsEmpty - true if there are no nodes in the set
+func (b BitMask) IsEmpty() bool {
+	for _, word := range b.Words {
+		if word != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Or - add all the nodes in other
+func (b *BitMask) Or(other BitMask) {
+	for len(b.Words) < len(other.Words) {
+		b.Words = append(b.Words, 0)
+	}
+	for i, word := range other.Words {
+		b.Words[i] |= word
+	}
+}
+
+// Equal - same nodes, however many words either has
+func (b BitMask) Equal(other BitMask) bool {
+	for i := 0; i < len(b.Words) || i < len(other.Words); i++ {
+		var mine, theirs uint64
+		if i < len(b.Words) {
+			mine = b.Words[i]
+		}
+		if i < len(other.Words) {
+			theirs = other.Words[i]
+		}
+		if mine != theirs {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone - copy that does not share words with b
+func (b BitMask) Clone() BitMask {
+	if b.Words == nil {
+		return BitMask{}
+	}
+	return BitMask{Words: append([]uint64(nil), b.Words...)}
+}
+
+// Members - node ids in the set, lowest first
+func (b BitMask) Members() []int {
+	var ids []int
+	for i, word := range b.Words {
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			ids = append(ids, i*64+bit)
+			word &^= 1 << uint(bit)
+		}
+	}
+	return ids
+}
+
+func (b BitMask) String() string {
+	ids := b.Members()
+	text := make([]string, len(ids))
+	for i, id := range ids {
+		text[i] = fmt.Sprint(id)
+	}
+	return "{" + strings.Join(text, ",") + "}"
+}
+
+//====================================================================================
+// NeighborMatrix
+//====================================================================================
+
+type MatrixRow struct {
+	NodeId    int
+	Neighbors BitMask // nodes NodeId hears
+	Updated   time.Time
+}
+
+type NeighborMatrix struct {
+	Timeout time.Duration // rows and neighbors not heard of for this long are dropped
+
+	selfId int
+	mutex  sync.Mutex
+	heard  map[int]time.Time  // our neighbors, when we last heard each directly
+	rows   map[int]*MatrixRow // the other nodes, by NodeId
+}
+
+func NewNeighborMatrix(selfId int) *NeighborMatrix {
+	return &NeighborMatrix{
+		Timeout: MATRIX_TIMEOUT * time.Millisecond,
+		selfId:  selfId,
+		heard:   make(map[int]time.Time),
+		rows:    make(map[int]*MatrixRow),
+	}
+}
+
+//====================================================================================
+// Heard - we received a DISCOVERY directly from node id
+//====================================================================================
+func (m *NeighborMatrix) Heard(id int) {
+	if id == m.selfId || id < 1 {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.heard[id]; !ok {
+		fmt.Println("MATRIX: HEAR", id)
+	}
+	m.heard[id] = time.Now()
+}
+
+//====================================================================================
+// Update - node id told us, in its DISCOVERY, the nodes it hears
+//====================================================================================
+func (m *NeighborMatrix) Update(id int, neighbors BitMask) {
+	if id == m.selfId || id < 1 {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.rows[id] = &MatrixRow{NodeId: id, Neighbors: neighbors.Clone(), Updated: time.Now()}
+}
+
+//====================================================================================
+// Expire - drop neighbors and rows not heard of for Timeout
+//====================================================================================
+func (m *NeighborMatrix) Expire(now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for id, heard := range m.heard {
+		if now.Sub(heard) > m.Timeout {
+			fmt.Println("MATRIX: NO LONGER HEAR", id)
+			delete(m.heard, id)
+		}
+	}
+	for id, row := range m.rows {
+		if now.Sub(row.Updated) > m.Timeout {
+			delete(m.rows, id)
+		}
+	}
+}
+
+// Own - the nodes we hear, to put in our DISCOVERY
+func (m *NeighborMatrix) Own() BitMask {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.own()
+}
+
+func (m *NeighborMatrix) own() BitMask {
+	var own BitMask
+	for id := range m.heard {
+		own.Set(id)
+	}
+	return own
+}
+
+// Neighbors - the nodes id hears, false if we have no row for it
+func (m *NeighborMatrix) Neighbors(id int) (BitMask, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.neighbors(id)
+}
+
+func (m *NeighborMatrix) neighbors(id int) (BitMask, bool) {
+	if id == m.selfId {
+		return m.own(), true
+	}
+	row, ok := m.rows[id]
+	if !ok {
+		return BitMask{}, false
+	}
+	return row.Neighbors.Clone(), true
+}
+
+// CanHear - true if, as far as we know, node a hears node b
+func (m *NeighborMatrix) CanHear(a, b int) bool {
+	neighbors, _ := m.Neighbors(a)
+	return neighbors.Has(b)
+}
+
+//====================================================================================
+// ReachableInHops - nodes that reach node id in k hops or fewer, id itself not
+// included. Nodes we have no row for count as hearing nobody
+//====================================================================================
+func (m *NeighborMatrix) ReachableInHops(id, k int) BitMask {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var reached, visited BitMask
+	visited.Set(id)
+	frontier := []int{id}
+	for hop := 0; hop < k && len(frontier) > 0; hop++ {
+		var next []int
+		for _, node := range frontier {
+			neighbors, _ := m.neighbors(node)
+			for _, neighbor := range neighbors.Members() {
+				if visited.Has(neighbor) {
+					continue
+				}
+				visited.Set(neighbor)
+				reached.Set(neighbor)
+				next = append(next, neighbor)
+			}
+		}
+		frontier = next
+	}
+	return reached
+}
+
+// Rows - copy of the matrix, ours included, by NodeId
+func (m *NeighborMatrix) Rows() []MatrixRow {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	rows := []MatrixRow{{NodeId: m.selfId, Neighbors: m.own(), Updated: time.Now()}}
+	for _, row := range m.rows {
+		rows = append(rows, MatrixRow{NodeId: row.NodeId, Neighbors: row.Neighbors.Clone(), Updated: row.Updated})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].NodeId < rows[j].NodeId })
+	return rows
+}
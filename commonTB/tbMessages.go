@@ -20,11 +20,9 @@ type hashArray struct {
 	state     [MAX_MSG_SEQUENCE]uint8
 }
 
+// BitMask - set of node ids, grows to whatever ids are put in it
 type BitMask struct {
-	M_Mask     uint64
-	M_TempMask uint64
-	M_Offset   int
-	M_BitMask  [MAX_NODES]uint64
+	Words []uint64 // node id i is bit i%64 of Words[i/64]
 }
 
 type NameId struct {
@@ -54,8 +52,16 @@ type MessageHeader struct {
 	DstId       int // destination node
 	DstIP       string
 	DstPort     string
-	GroundRange int // are we in range to ground - true or false, or distance?
-	Hash        int // hash value for the packet header
+	GroundRange int  // km from the sender to the ground station, or GROUND_NOT_VISIBLE, GROUND_RANGE_UNKNOWN
+	Hash        int  // hash value for the packet header
+	AckReq      bool // sender wants a MSG_TYPE_ACK for SrcSeq, see ReliableSender
+	Hops        int  // relays the message went through, 0 if heard from SrcId itself
+
+	SrcX       float64 // km, where the node that sent this packet, relays too, was; see RadioLink
+	SrcY       float64
+	SrcZ       float64
+	Positioned bool // SrcX/SrcY/SrcZ are set, packets with no position are heard by all
+	SrcECEF    bool // SrcX/SrcY/SrcZ are ECEF, the sender is in orbit
 }
 
 //type MessageTypeCode struct {0
@@ -89,9 +95,45 @@ const MSG_TYPE_CTRL_3 = "CTRL_3"
 // Messages from ground control
 const MSG_TYPE_DRONE_MOVE = "DRONE_MOVE"
 
+// Kinds of move
+const MOVE_ABSOLUTE = "ABSOLUTE" // to Latitude, Longitude and Altitude
+const MOVE_RELATIVE = "RELATIVE" // DX, DY and DZ from where we are
+
 type MoveMsgBody struct {
-	longitude float64
-	latitude  float64
+	Kind      string  // MOVE_ABSOLUTE or MOVE_RELATIVE
+	Latitude  float64 // degrees, MOVE_ABSOLUTE
+	Longitude float64 // degrees
+	Altitude  float64 // km
+	DX        float64 // km east, MOVE_RELATIVE
+	DY        float64 // km north
+	DZ        float64 // km up
+	Velocity  float64 // km/hour, 0 = fast enough to be there in ETA
+	ETA       float64 // sec, simulated, to get there if no Velocity; both 0 = DEFAULT_VELOCITY
+}
+type MsgCodeMove struct {
+	MsgHeader MessageHeader
+	MsgMove   MoveMsgBody
+}
+
+// The node's answer to a DRONE_MOVE, once when it gets it and again when it
+// gets there
+const MSG_TYPE_DRONE_MOVE_ACK = "DRONE_MOVE_ACK"
+
+type MoveAckMsgBody struct {
+	MoveSeq   int     // SrcSeq of the DRONE_MOVE
+	Accepted  bool    // false and the node does not move
+	Arrived   bool    // the node is where it was told to go
+	Reason    string  // why not accepted, or what was changed, e.g. clipped to the playing field
+	Latitude  float64 // degrees, where the node is now
+	Longitude float64
+	Altitude  float64  // km
+	Position  Position // km, in the playing field, or ECEF if in orbit
+	Target    Position // km, where it is going
+	ETA       float64  // sec, simulated, until it gets there
+}
+type MsgCodeMoveAck struct {
+	MsgHeader  MessageHeader
+	MsgMoveAck MoveAckMsgBody
 }
 
 const MSG_TYPE_STATUS_REQ = "DRONE_STATUS_REQ"
@@ -123,8 +165,12 @@ type StatusReplyMsgBody struct {
 
 const MSG_TYPE_DRONE_TERMINATE = "DRONE_TERMINATE"
 
+type TerminateMsgBody struct {
+	When float64 // nanosec, 0 = now
+}
 type MsgCodeTerminate struct {
-	when float64
+	MsgHeader    MessageHeader
+	MsgTerminate TerminateMsgBody
 }
 
 const MSG_TYPE_GROUND_INFO = "GROUNDINFO"
@@ -151,13 +197,46 @@ type MsgCodeDiscovery struct {
 	MsgDiscovery DiscoveryMsgBody
 }
 type DiscoveryMsgBody struct {
-	TimeCreated    float64 // nanosec
+	TimeCreated    float64 // nanosec, the sender's incarnation #, see TBincarnation
 	NodeActive     bool
 	LastChangeTime float64 // string	// last time our role changed
 	MsgLastSentAt  float64 //time.Time //string // time
 	MsgLastRcvdAt  float64 // time.Time //string // time
 	MsgsSent       int64
 	MsgsRcvd       int64
+	GroundDistance float64  // km to the ground station, GROUND_DISTANCE_UNKNOWN if not known
+	GroundRange    float64  // km, radio range to the ground station
+	Neighbors      BitMask  // nodes we hear directly
+	Position       Position // km, where the sender is now, see Mobility
+	HelloInterval  int64    // msec, the sender's next hello to us comes within it, 0 = not said
+	SessionId      int      // the sender's session with us, 0 = none, see SessionMsgBody
+}
+
+const MSG_TYPE_UPDATE = "UPDATE"
+
+const MSG_TYPE_ACK = "ACK"
+
+type AckMsgBody struct {
+	AckSeq int // SrcSeq of the message we acknowledge
+}
+type MsgCodeAck struct {
+	MsgHeader MessageHeader
+	MsgAck    AckMsgBody
+}
+
+const MSG_TYPE_ROUTES = "ROUTES"
+
+type RouteEntry struct {
+	DstId   int
+	NextHop int // so the next hop can tell the route goes through it, see RoutingTable
+	Metric  int // hops, ROUTE_INFINITY = unreachable
+}
+type RoutesMsgBody struct {
+	Routes []RouteEntry
+}
+type MsgCodeRoutes struct {
+	MsgHeader MessageHeader
+	MsgRoutes RoutesMsgBody
 }
 
 const MSG_TYPE_CMD = "COMMANDS"
@@ -174,24 +253,28 @@ type MsgCmdReply struct {
 	CmdReply string
 }
 
+//--------------------------------------------------
+// Session between an M2 and its M3, all with a SessionMsgBody:
+//  M2 -> CONNECT, to any M3 that hears it, SessionId picked by M2
+//  M3 -> CONNECTING, Accepted or not, with the Reason
+//  M2 -> CONNECTED, the session is up on both sides
+// Either one ends it with DISCONNECT, answered with DISCONNECTED. M2 says hello
+// to its M3 in the session, M3 answers DISCONNECT if it does not have it
+//--------------------------------------------------
 const MSG_TYPE_CONNECT = "CONNECT"
-
-type MsgConnect struct {
-}
-
 const MSG_TYPE_CONNECTING = "CONNECTING"
-
-type MsgConnecting struct {
-}
-
 const MSG_TYPE_CONNECTED = "CONNECTED"
-
-type MsgConnected struct {
-}
-
 const MSG_TYPE_DISCONNECT = "DISCONNECT"
+const MSG_TYPE_DISCONNECTED = "MSG_DISCONNECTED"
 
-type MsgDisconnect struct {
+type SessionMsgBody struct {
+	SessionId int    // same in every message of a session
+	Accepted  bool   // CONNECTING only, false = rejected
+	Reason    string // why rejected or disconnected
+}
+type MsgCodeSession struct {
+	MsgHeader  MessageHeader
+	MsgSession SessionMsgBody
 }
 
 const MSG_TYPE_DISCONNECTING = "DISCONNECTING"
@@ -199,11 +282,6 @@ const MSG_TYPE_DISCONNECTING = "DISCONNECTING"
 type MsgDisconnecting struct {
 }
 
-const MSG_TYPE_DISCONNECTED = "MSG_DISCONNECTED"
-
-type MsgDisconnected struct {
-}
-
 const MSG_TYPE_TERMINATING = "MSG_TERMINATING"
 
 type MsgTerminating struct {
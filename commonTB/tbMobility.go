@@ -0,0 +1,397 @@
+//=============================================================================
+// FILE NAME: tbMobility.go
+// DESCRIPTION:
+// How nodes move around the X_WIDTH x Y_WIDTH x Z_WIDTH km playing field.
+// A MobilityModel says where a node is after some simulated time: static,
+// a straight line bouncing off the edges of the field, random waypoints,
+// waypoints scripted in a file, going where a DRONE_MOVE said (goto), or in
+// orbit, see Orbit.
+// Mobility runs the model on every tick of the node, with simulated time going
+// scale times faster than real time, as speeds of DEFAULT_VELOCITY km/hour
+// would not change much in a few minutes. Positions are in km from the corner
+// of the field at FIELD_LATITUDE, FIELD_LONGITUDE, see TBpositionFromLatLong.
+// Unless THREE_DIMENSIONAL, nodes stay at the altitude they started at.
+// Typical use:
+//   model, err := common.NewMobilityModel(config) // config.MobilityModel = common.MOBILITY_WAYPOINT
+//   Node.Mobility = common.NewMobility(model, config.Position, common.DEFAULT_VELOCITY_SCALE)
+//   position, moved := Node.Mobility.Advance(tick)         // on every tick
+//================================================================================
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// Mobility models, for NewMobilityModel
+const MOBILITY_STATIC = "static"
+const MOBILITY_LINEAR = "linear"
+const MOBILITY_WAYPOINT = "waypoint"
+const MOBILITY_SCRIPTED = "scripted"
+const MOBILITY_ORBIT = "orbit" // see Orbit, positions are ECEF
+const MOBILITY_GOTO = "goto"   // where a DRONE_MOVE sent us, not for configuration
+
+const MOBILITY_WAYPOINT_PAUSE = 10 // sec, simulated, a random waypoint node stays at each waypoint
+
+//====================================================================================
+// MobilityModel - where a node at position is elapsed simulated time later
+//====================================================================================
+type MobilityModel interface {
+	Next(position Position, elapsed time.Duration) Position
+	Name() string
+}
+
+//====================================================================================
+// NewMobilityModel - the model config.MobilityModel names, at config.Velocity,
+// along config.Heading, through config.MobilityScript or in config.Orbit
+//====================================================================================
+func NewMobilityModel(config NodeConfig) (MobilityModel, error) {
+	velocity := config.Velocity
+	if velocity <= 0 {
+		velocity = DEFAULT_VELOCITY
+	}
+	switch config.MobilityModel {
+	case "", MOBILITY_STATIC:
+		return StaticMobility{}, nil
+	case MOBILITY_LINEAR:
+		return NewLinearMobility(velocity, config.Heading), nil
+	case MOBILITY_WAYPOINT:
+		return NewRandomWaypointMobility(velocity, MOBILITY_WAYPOINT_PAUSE*time.Second), nil
+	case MOBILITY_SCRIPTED:
+		if config.MobilityScript == "" {
+			return nil, fmt.Errorf("mobility %s: no script file", config.MobilityModel)
+		}
+		return NewScriptedMobility(config.MobilityScript, velocity)
+	case MOBILITY_ORBIT:
+		if config.Orbit.Eccentricity < 0 || config.Orbit.Eccentricity >= 1 {
+			return nil, fmt.Errorf("mobility %s: eccentricity %v is not an orbit", config.MobilityModel,
+				config.Orbit.Eccentricity)
+		}
+		return NewOrbitMobility(config.Orbit), nil
+	}
+	return nil, fmt.Errorf("mobility %s: no such model", config.MobilityModel)
+}
+
+//====================================================================================
+// Mobility - a node's position, moved by its model as time goes by
+//====================================================================================
+type Mobility struct {
+	mutex    sync.Mutex
+	model    MobilityModel
+	scale    float64 // simulated seconds per real second
+	position Position
+	last     time.Time     // last Advance, zero until the first
+	simTime  time.Duration // simulated, since the first Advance
+}
+
+// NewMobility - a node at position, moved by model, scale times faster than real time
+func NewMobility(model MobilityModel, position Position, scale float64) *Mobility {
+	if model == nil {
+		model = StaticMobility{}
+	}
+	if scale <= 0 {
+		scale = 1
+	}
+	return &Mobility{model: model, scale: scale, position: position}
+}
+
+//====================================================================================
+// Advance - move to where the model says we are now, true if we moved
+//====================================================================================
+func (m *Mobility) Advance(now time.Time) (Position, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.last.IsZero() || !now.After(m.last) {
+		m.last = now
+		return m.position, false
+	}
+	elapsed := time.Duration(float64(now.Sub(m.last)) * m.scale)
+	m.last = now
+	m.simTime += elapsed
+	position := m.model.Next(m.position, elapsed)
+	moved := position != m.position
+	m.position = position
+	return position, moved
+}
+
+func (m *Mobility) Position() Position {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.position
+}
+
+// SetPosition - we were put somewhere else, the model goes on from there
+func (m *Mobility) SetPosition(position Position) {
+	m.mutex.Lock()
+	m.position = position
+	m.mutex.Unlock()
+}
+
+func (m *Mobility) Model() MobilityModel {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.model
+}
+
+// SetModel - move on the way model says from now on
+func (m *Mobility) SetModel(model MobilityModel) {
+	m.mutex.Lock()
+	m.model = model
+	m.mutex.Unlock()
+}
+
+// SimTime - simulated time since the first Advance
+func (m *Mobility) SimTime() time.Duration {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.simTime
+}
+
+//====================================================================================
+// StaticMobility - stays where it is
+//====================================================================================
+type StaticMobility struct{}
+
+func (StaticMobility) Next(position Position, _ time.Duration) Position { return position }
+func (StaticMobility) Name() string                                     { return MOBILITY_STATIC }
+
+//====================================================================================
+// LinearMobility - a straight line at constant velocity, bouncing off the edges
+// of the playing field
+//====================================================================================
+type LinearMobility struct {
+	velocity Position // km/hour along each axis
+}
+
+func NewLinearMobility(velocity, heading float64) *LinearMobility {
+	radians := heading * math.Pi / 180
+	return &LinearMobility{velocity: Position{
+		X: velocity * math.Sin(radians),
+		Y: velocity * math.Cos(radians),
+	}}
+}
+
+func (l *LinearMobility) Next(position Position, elapsed time.Duration) Position {
+	hours := elapsed.Hours()
+	position.X, l.velocity.X = bounce(position.X+l.velocity.X*hours, l.velocity.X, X_WIDTH)
+	position.Y, l.velocity.Y = bounce(position.Y+l.velocity.Y*hours, l.velocity.Y, Y_WIDTH)
+	if THREE_DIMENSIONAL {
+		position.Z, l.velocity.Z = bounce(position.Z+l.velocity.Z*hours, l.velocity.Z, Z_WIDTH)
+	}
+	return position
+}
+
+func (l *LinearMobility) Name() string { return MOBILITY_LINEAR }
+
+// bounce - x, moving at velocity, back into 0..width, turning around at the edges
+func bounce(x, velocity, width float64) (float64, float64) {
+	for x < 0 || x > width {
+		if x < 0 {
+			x = -x
+		} else {
+			x = 2*width - x
+		}
+		velocity = -velocity
+	}
+	return x, velocity
+}
+
+//====================================================================================
+// RandomWaypointMobility - heads for a random point of the field, waits there a
+// while, then for another one
+//====================================================================================
+type RandomWaypointMobility struct {
+	velocity  float64 // km/hour
+	pause     time.Duration
+	random    *rand.Rand
+	target    Position
+	hasTarget bool
+	waiting   time.Duration // left to wait at the waypoint we reached
+}
+
+func NewRandomWaypointMobility(velocity float64, pause time.Duration) *RandomWaypointMobility {
+	return &RandomWaypointMobility{
+		velocity: velocity,
+		pause:    pause,
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (w *RandomWaypointMobility) Next(position Position, elapsed time.Duration) Position {
+	if w.waiting > 0 {
+		w.waiting -= elapsed
+		return position
+	}
+	if !w.hasTarget {
+		w.target = Position{X: w.random.Float64() * X_WIDTH, Y: w.random.Float64() * Y_WIDTH, Z: position.Z}
+		if THREE_DIMENSIONAL {
+			w.target.Z = w.random.Float64() * Z_WIDTH
+		}
+		w.hasTarget = true
+	}
+	position, arrived := moveToward(position, w.target, w.velocity*elapsed.Hours())
+	if arrived {
+		w.hasTarget = false
+		w.waiting = w.pause
+	}
+	return position
+}
+
+func (w *RandomWaypointMobility) Name() string { return MOBILITY_WAYPOINT }
+
+//====================================================================================
+// ScriptedMobility - through the waypoints of a file, in order, over and over if
+// the script says Loop. The file is JSON:
+//   {"Loop": true, "Waypoints": [{"X": 10, "Y": 20, "Velocity": 50, "Pause": 30}, ...]}
+//====================================================================================
+type Waypoint struct {
+	X        float64 // km, clipped to the playing field
+	Y        float64
+	Z        float64
+	Velocity float64 // km/hour on the way here, 0 = the model's
+	Pause    float64 // sec, simulated, to stay once here
+}
+
+type MobilityScript struct {
+	Loop      bool // start over after the last waypoint, otherwise stay there
+	Waypoints []Waypoint
+}
+
+type ScriptedMobility struct {
+	script   MobilityScript
+	velocity float64 // km/hour, for waypoints that do not say
+	next     int     // waypoint we are heading for, len(Waypoints) once done
+	waiting  time.Duration
+}
+
+//====================================================================================
+// NewScriptedMobility - the waypoints in fileName, a JSON MobilityScript, at
+// velocity km/hour unless they say otherwise
+//====================================================================================
+func NewScriptedMobility(fileName string, velocity float64) (*ScriptedMobility, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("mobility %s: %w", MOBILITY_SCRIPTED, err)
+	}
+	var script MobilityScript
+	if err = json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("mobility %s: %s: %w", MOBILITY_SCRIPTED, fileName, err)
+	}
+	return NewScriptedMobilityFrom(script, velocity), nil
+}
+
+func NewScriptedMobilityFrom(script MobilityScript, velocity float64) *ScriptedMobility {
+	for i := range script.Waypoints {
+		waypoint := &script.Waypoints[i]
+		clipped, _ := TBinField(Position{X: waypoint.X, Y: waypoint.Y, Z: waypoint.Z})
+		waypoint.X, waypoint.Y, waypoint.Z = clipped.X, clipped.Y, clipped.Z
+	}
+	return &ScriptedMobility{script: script, velocity: velocity}
+}
+
+func (s *ScriptedMobility) Next(position Position, elapsed time.Duration) Position {
+	if s.waiting > 0 {
+		s.waiting -= elapsed
+		return position
+	}
+	if s.next >= len(s.script.Waypoints) {
+		if !s.script.Loop || len(s.script.Waypoints) == 0 {
+			return position
+		}
+		s.next = 0
+	}
+	waypoint := s.script.Waypoints[s.next]
+	velocity := waypoint.Velocity
+	if velocity <= 0 {
+		velocity = s.velocity
+	}
+	target := Position{X: waypoint.X, Y: waypoint.Y, Z: position.Z}
+	if THREE_DIMENSIONAL {
+		target.Z = waypoint.Z
+	}
+	position, arrived := moveToward(position, target, velocity*elapsed.Hours())
+	if arrived {
+		s.next++
+		s.waiting = time.Duration(waypoint.Pause * float64(time.Second))
+	}
+	return position
+}
+
+func (s *ScriptedMobility) Name() string { return MOBILITY_SCRIPTED }
+
+//====================================================================================
+// GotoMobility - straight to target at velocity km/hour, then stays there
+//====================================================================================
+type GotoMobility struct {
+	target   Position
+	velocity float64
+	arrived  bool
+}
+
+func NewGotoMobility(target Position, velocity float64) *GotoMobility {
+	return &GotoMobility{target: target, velocity: velocity}
+}
+
+func (g *GotoMobility) Next(position Position, elapsed time.Duration) Position {
+	if g.arrived {
+		return position
+	}
+	position, g.arrived = moveToward(position, g.target, g.velocity*elapsed.Hours())
+	return position
+}
+
+func (g *GotoMobility) Name() string { return MOBILITY_GOTO }
+
+func (g *GotoMobility) Target() Position { return g.target }
+
+// Arrived - true once at target
+func (g *GotoMobility) Arrived() bool { return g.arrived }
+
+// TBinField - position clipped to the playing field, false if it had to be
+func TBinField(position Position) (Position, bool) {
+	clipped := Position{
+		X: math.Max(0, math.Min(X_WIDTH, position.X)),
+		Y: math.Max(0, math.Min(Y_WIDTH, position.Y)),
+		Z: math.Max(0, math.Min(Z_WIDTH, position.Z)),
+	}
+	return clipped, clipped == position
+}
+
+//====================================================================================
+// TBpositionFromLatLong - where latitude and longitude, in degrees, and altitude,
+// in km, are in the playing field. The field is small enough to be taken as flat
+//====================================================================================
+func TBpositionFromLatLong(latitude, longitude, altitude float64) Position {
+	return Position{
+		X: (longitude - FIELD_LONGITUDE) * math.Pi / 180 * EARTH_RADIUS * math.Cos(FIELD_LATITUDE*math.Pi/180),
+		Y: (latitude - FIELD_LATITUDE) * math.Pi / 180 * EARTH_RADIUS,
+		Z: altitude,
+	}
+}
+
+// TBlatLongFromPosition - latitude, longitude and altitude of position, in the
+// playing field or ECEF
+func TBlatLongFromPosition(position Position) (latitude, longitude, altitude float64) {
+	if position.ECEF {
+		return TBlatLongFromECEF(position)
+	}
+	latitude = FIELD_LATITUDE + position.Y/EARTH_RADIUS*180/math.Pi
+	longitude = FIELD_LONGITUDE + position.X/(EARTH_RADIUS*math.Cos(FIELD_LATITUDE*math.Pi/180))*180/math.Pi
+	return latitude, longitude, position.Z
+}
+
+// moveToward - distance km from position on the way to target, true once there
+func moveToward(position, target Position, distance float64) (Position, bool) {
+	dx, dy, dz := target.X-position.X, target.Y-position.Y, target.Z-position.Z
+	left := math.Sqrt(dx*dx + dy*dy + dz*dz)
+	if left <= distance {
+		return target, true
+	}
+	share := distance / left
+	return Position{X: position.X + dx*share, Y: position.Y + dy*share, Z: position.Z + dz*share}, false
+}
@@ -89,7 +89,6 @@ func GetMastersIP(master string) string {
 			if strings.Contains(a[0], master) {
 				// FOUND
 				return currIp
-				break
 			}
 		}
 	}
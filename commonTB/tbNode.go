@@ -0,0 +1,1034 @@
+//=============================================================================
+// FILE NAME: tbNode.go
+// DESCRIPTION:
+// What every terminal does, whatever its role: who and where it is, moving (see
+// Mobility), the control plane, RF range emulation (see RadioLink), dropping
+// duplicates, flooding, routing, ground forwarding, the neighbor matrix, telling
+// when peers restart, whether the ground station sees us (see GroundStation),
+// STATUS requests and DRONE_MOVE orders, the timer, the common console commands
+// and the main loop.
+// What makes a terminal an M2, an M3 or anything else plugs in as a Role: it
+// subscribes to its own messages in Start, runs its timers in Tick, looks at
+// every DISCOVERY once the node is done with it, adds console commands and
+// status lines, and says goodbye in Stop. Reading its configuration is up to
+// the role too, it hands the node a NodeConfig and its ConnectivityInfo.
+// Messages, the timer and the console are all handled on the main loop, one
+// at a time and run to completion, so roles need no locking of their own.
+// Typical use:
+//   Node = common.NewNode(config, &M2.M2Connectivity, common.NewM2Role(&M2))
+//   err := Node.Start(ctx, ConsoleInput)
+//   <-Node.Done()
+//================================================================================
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type NodeConfig struct {
+	Id               int
+	Name             string
+	IP               string         // "" = the first non-loopback address we have
+	Port             string         // unicast, what we put in SrcPort
+	TickInterval     time.Duration  // between timer ticks, 0 = NODE_TICK_INTERVAL
+	DistanceToGround float64        // km, GROUND_DISTANCE_UNKNOWN if not known
+	GroundRadioRange float64        // km
+	Position         Position       // where we start, see RadioLink
+	RadioRange       float64        // km we hear other nodes from, 0 = all the network delivers
+	RadioLoss        float64        // share of the packets lost from RadioRange away, fewer closer
+	RadioLatency     time.Duration  // added to packets from RadioRange away, less closer
+	MobilityModel    string         // MOBILITY_STATIC ("" too), MOBILITY_LINEAR, MOBILITY_WAYPOINT or MOBILITY_SCRIPTED
+	Velocity         float64        // km/hour, 0 = DEFAULT_VELOCITY
+	Heading          float64        // degrees clockwise from the Y axis, MOBILITY_LINEAR only
+	MobilityScript   string         // waypoints file, MOBILITY_SCRIPTED only
+	TimeScale        float64        // simulated seconds per real second, 0 = DEFAULT_VELOCITY_SCALE
+	Orbit            Orbit          // MOBILITY_ORBIT only, Position is where the orbit starts then
+	GroundStation    *GroundStation // where the ground station is, nil = we do not know
+	ControllerId     int            // who may TERMINATE us, besides the ground, 0 = nobody
+}
+
+//====================================================================================
+// Role - the role specific part of a Node. All of it is called on the main loop
+//====================================================================================
+type Role interface {
+	// Start - subscribe to the role's messages; called by Node.Start, once the
+	// control plane is up and before anything is received
+	Start(node *Node) error
+	// Tick - the role's timers, before the node's own
+	Tick(node *Node, tick time.Time)
+	// Discovery - a DISCOVERY, after the node updated ground, matrix and incarnations
+	Discovery(node *Node, packet InboundPacket, msgHeader *MessageHeader, discovery *DiscoveryMsgBody)
+	// Command - a console command the node does not know, false if the role does not either
+	Command(node *Node, cmd []string) bool
+	// Status - the role's lines of the status command
+	Status(node *Node)
+	// Stop - we are going away, before the connections are closed
+	Stop(node *Node)
+}
+
+type Node struct {
+	NodeConfig
+	Mac            string
+	State          string // the role's, see ChangeState
+	Active         bool
+	TimeCreated    time.Time // our incarnation, see TBincarnation
+	LastChangeTime float64   // nanosec, last ChangeState
+	MsgsSent       int64
+	MsgsRcvd       int64
+	HelloInterval  time.Duration // between the role's hellos, told in DISCOVERY, 0 = it sends none
+
+	Connectivity *ConnectivityInfo
+	Channels     MyChannels
+	Dispatcher   *Dispatcher         // MsgCode -> handler, see Subscribe
+	Duplicates   *DupCache           // messages already processed, by SrcId and SrcSeq
+	Incarnations *IncarnationTracker // incarnation # of every peer, to tell when one restarted
+	Mobility     *Mobility           // where we are and how we move, the radio goes along
+
+	role       Role
+	mutex      sync.Mutex // nextSeq
+	nextSeq    int
+	delayed    chan delayedPacket // packets the radio heard, late
+	moveOrder  *moveOrder         // the DRONE_MOVE we are carrying out, nil if none
+	groundLook Look               // how the ground station sees us, if we know where it is
+	configErr  error              // what NewNode could not make of the config, Start fails with it
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+type delayedPacket struct {
+	packet    InboundPacket
+	msgHeader *MessageHeader
+}
+
+// moveOrder - a move, to tell whoever asked for it once we get there
+type moveOrder struct {
+	seq     int    // SrcSeq of the DRONE_MOVE
+	srcId   int    // who sent it
+	ttl     int    // how far away they may be
+	address string // where we heard them, "" if it came from our console
+	model   *GotoMobility
+}
+
+//====================================================================================
+// NewNode - a node playing role, on connectivity as the role configured it
+//====================================================================================
+func NewNode(config NodeConfig, connectivity *ConnectivityInfo, role Role) *Node {
+	if config.TickInterval <= 0 {
+		config.TickInterval = NODE_TICK_INTERVAL * time.Millisecond
+	}
+	if config.TimeScale <= 0 {
+		config.TimeScale = DEFAULT_VELOCITY_SCALE
+	}
+	node := &Node{
+		NodeConfig:     config,
+		TimeCreated:    time.Now(),
+		LastChangeTime: float64(TBtimestampNano()),
+		Connectivity:   connectivity,
+		Channels: MyChannels{
+			CmdChannel:              make(chan []string),
+			UnicastRcvCtrlChannel:   make(chan InboundPacket),
+			BroadcastRcvCtrlChannel: make(chan InboundPacket),
+			MulticastRcvCtrlChannel: make(chan InboundPacket),
+		},
+		Dispatcher:   NewDispatcher(),
+		Duplicates:   NewDupCache(),
+		Incarnations: NewIncarnationTracker(),
+		role:         role,
+		delayed:      make(chan delayedPacket),
+	}
+	if node.IP == "" {
+		node.IP, node.Mac = GetLocalIp()
+		fmt.Println(node.Name, "Local IP=", node.IP, " MAC=", node.Mac)
+	}
+	node.Incarnations.Subscribe(node.peerRestarted)
+	model, err := NewMobilityModel(config)
+	if err != nil {
+		fmt.Println("MOBILITY:", err)
+		node.configErr = err
+		model = StaticMobility{}
+	}
+	if orbit, ok := model.(*OrbitMobility); ok {
+		node.Position = orbit.Position()
+	}
+	node.Mobility = NewMobility(model, node.Position, config.TimeScale)
+	connectivity.Radio = NewRadioLink(config.RadioRange, node.Position)
+	connectivity.Radio.SetLoss(config.RadioLoss)
+	connectivity.Radio.SetLatency(config.RadioLatency)
+	return node
+}
+
+//====================================================================================
+// NodeConfigFileName - config<id>.yml if the command line names the node,
+// config.yml otherwise
+//====================================================================================
+func NodeConfigFileName() string {
+	if len(os.Args) > 2 && os.Args[1] != "" && os.Args[1] != "0" {
+		return "config" + os.Args[2] + ".yml"
+	}
+	return "config.yml"
+}
+
+//====================================================================================
+// DefaultPlatformInfo - where the config file does not say: no RF emulation, we
+// do not move and do not know how far the ground is
+//====================================================================================
+func DefaultPlatformInfo() PlatformInfo {
+	return PlatformInfo{
+		DistanceToGround: GROUND_DISTANCE_UNKNOWN,
+		GroundRadioRange: RANGE_2D,
+		MobilityModel:    MOBILITY_STATIC,
+	}
+}
+
+//====================================================================================
+// ToNodeConfig - set what config needs to know of where we are, how we move and
+// where the ground station is, from p
+//====================================================================================
+func (p PlatformInfo) ToNodeConfig(config *NodeConfig) {
+	config.DistanceToGround = p.DistanceToGround
+	config.GroundRadioRange = p.GroundRadioRange
+	config.Position = Position{X: p.PositionX, Y: p.PositionY, Z: p.PositionZ}
+	config.RadioRange = p.RadioRange
+	config.RadioLoss = p.RadioLoss
+	config.RadioLatency = time.Duration(p.RadioLatency) * time.Millisecond
+	config.MobilityModel = p.MobilityModel
+	config.Velocity = p.Velocity
+	config.Heading = p.Heading
+	config.MobilityScript = p.MobilityScript
+	config.TimeScale = p.TimeScale
+	config.Orbit = Orbit{
+		Altitude:     p.OrbitAltitude,
+		Eccentricity: p.OrbitEccentricity,
+		Inclination:  p.OrbitInclination,
+		RAAN:         p.OrbitRAAN,
+		ArgPerigee:   p.OrbitArgPerigee,
+		Phase:        p.OrbitPhase,
+	}
+	config.GroundStation = nil
+	if p.GroundPositionKnown {
+		config.GroundStation = &GroundStation{
+			Latitude:     p.GroundLatitude,
+			Longitude:    p.GroundLongitude,
+			Altitude:     p.GroundAltitude,
+			MinElevation: p.GroundMinElevation,
+		}
+	}
+}
+
+// Print - what the config file said, with the rest of the configuration
+func (p PlatformInfo) Print() {
+	fmt.Println("DistanceToGround         = ", p.DistanceToGround)
+	fmt.Println("GroundRadioRange         = ", p.GroundRadioRange)
+	fmt.Println("Position                 = ", p.PositionX, p.PositionY, p.PositionZ)
+	fmt.Println("RadioRange               = ", p.RadioRange)
+	fmt.Println("RadioLoss                = ", p.RadioLoss)
+	fmt.Println("RadioLatency             = ", p.RadioLatency)
+	fmt.Println("MobilityModel            = ", p.MobilityModel)
+	fmt.Println("Velocity                 = ", p.Velocity)
+	fmt.Println("Heading                  = ", p.Heading)
+	fmt.Println("MobilityScript           = ", p.MobilityScript)
+	fmt.Println("TimeScale                = ", p.TimeScale)
+	fmt.Println("Orbit                    = ", p.OrbitAltitude, p.OrbitEccentricity, p.OrbitInclination,
+		p.OrbitRAAN, p.OrbitArgPerigee, p.OrbitPhase)
+	fmt.Println("GroundPositionKnown      = ", p.GroundPositionKnown)
+	fmt.Println("Ground Lat/Long/Alt      = ", p.GroundLatitude, p.GroundLongitude, p.GroundAltitude)
+	fmt.Println("GroundMinElevation       = ", p.GroundMinElevation)
+}
+
+//====================================================================================
+// NodeCommandLine - overwrite config with the command line arguments, if any:
+//   [Name Id [IP [Port [groundIP groundPort]]]], 0 anywhere for default
+// Returns groundIP:groundPort, "" if not given
+//====================================================================================
+func NodeCommandLine(config *NodeConfig) string {
+	for index, arg := range os.Args {
+		fmt.Println("Arg", index, "=", arg)
+	}
+	argNum := len(os.Args) // Number of arguments supplied, including the command
+	fmt.Println("Number of Arguments = ", argNum)
+	if argNum > 2 && os.Args[1] != "" && os.Args[1] != "0" {
+		fmt.Println("NAME = ", os.Args[1])
+		config.Name = os.Args[1]
+		config.Id, _ = strconv.Atoi(os.Args[2])
+	}
+	if argNum > 3 && os.Args[3] != "" && os.Args[3] != "0" {
+		fmt.Println("Satellite IP   = ", os.Args[3])
+		config.IP = os.Args[3]
+		// TODO: Set eth0 IP address to config.IP !!!
+		var ifCmd = exec.Command("sudo", "ifconfig", "eth0", config.IP, "up", "", "")
+		output, err := ifCmd.Output()
+		fmt.Println("SET MY IP=", "sudo", "ifconfig", "eth0", config.IP, "up",
+			" -OUTPUT:", string(output), " ERR:", err)
+	}
+	if argNum > 4 && os.Args[4] != "" && os.Args[4] != "0" {
+		config.Port = os.Args[4]
+	}
+	if argNum > 6 && os.Args[5] != "" && os.Args[5] != "0" && os.Args[6] != "" && os.Args[6] != "0" {
+		return net.JoinHostPort(os.Args[5], os.Args[6])
+	}
+	return ""
+}
+
+//====================================================================================
+// Start - open the control plane, start the role, the receive threads, the timer
+// and the main loop, which also reads console. Runs until ctx is cancelled, Stop
+// is called, or "quit" is entered; Done tells when it is over, after which the
+// node can be started again. Fails if NewNode could not make sense of the config,
+// a mobility script it could not read for instance
+//====================================================================================
+func (n *Node) Start(ctx context.Context, console <-chan []string) error {
+	if n.configErr != nil {
+		return n.configErr
+	}
+	err := ControlPlaneInit(n.Connectivity, n.Channels)
+	if err != nil {
+		return err
+	}
+
+	// acknowledged unicast, for the messages that must get through
+	n.Connectivity.Reliable = NewReliableSender(n.Connectivity, MessageHeader{
+		SrcMAC:  n.Mac,
+		SrcName: n.Name,
+		SrcId:   n.Id,
+		SrcIP:   n.IP,
+		SrcPort: n.Port,
+	}, n.deliveryFailed)
+	n.Connectivity.Flood = NewFlooder(n.Connectivity, n.Id)
+	n.Connectivity.Routes = NewRoutingTable(n.Id)
+	n.Connectivity.Ground = NewGroundService(n.Id, n.DistanceToGround, n.GroundRadioRange)
+	n.Connectivity.Matrix = NewNeighborMatrix(n.Id)
+	n.lookAtGround()
+
+	n.Subscribe(MSG_TYPE_ACK, func() interface{} { return new(MsgCodeAck) }, n.Connectivity.Reliable.HandleAck)
+	n.Subscribe(MSG_TYPE_DISCOVERY, func() interface{} { return new(MsgCodeDiscovery) }, n.handleDiscoveryMsg)
+	n.Subscribe(MSG_TYPE_ROUTES, func() interface{} { return new(MsgCodeRoutes) }, n.handleRoutesMsg)
+	n.Subscribe(MSG_TYPE_GROUND_INFO, nil, n.handleGroundInfoMsg)
+	n.Subscribe(MSG_TYPE_STATUS_REQ, nil, n.handleStatusRequest)
+	n.Subscribe(MSG_TYPE_DRONE_TERMINATE, func() interface{} { return new(MsgCodeTerminate) }, n.handleTerminateMsg)
+	n.Subscribe(MSG_TYPE_DRONE_MOVE, func() interface{} { return new(MsgCodeMove) }, n.handleMoveMsg)
+	n.Subscribe(MSG_TYPE_DRONE_MOVE_ACK, func() interface{} { return new(MsgCodeMoveAck) }, ControlPlaneLogMessage)
+	err = n.role.Start(n)
+	if err != nil {
+		<-ControlPlaneCloseConnections(n.Connectivity)
+		return err
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	n.cancel = cancel
+	n.done = make(chan struct{})
+	// START SEND AND RECEIVE THREADS:
+	err = ControlPlaneRecvThread(runCtx, n.Connectivity, n.Channels)
+	if err != nil {
+		cancel()
+		<-ControlPlaneCloseConnections(n.Connectivity)
+		return err
+	}
+	go n.run(runCtx, console)
+	return nil
+}
+
+// Stop - as if quit was entered on the console
+func (n *Node) Stop() {
+	if n.cancel != nil {
+		n.cancel()
+	}
+}
+
+// Done - closed once a started node has stopped
+func (n *Node) Done() <-chan struct{} {
+	return n.done
+}
+
+// Subscribe - handle msgCode with handler, see Dispatcher.Register
+func (n *Node) Subscribe(msgCode string, newMsg func() interface{}, handler MessageHandler) {
+	n.Dispatcher.Register(msgCode, newMsg, handler)
+}
+
+//====================================================================================
+// ChangeState - the role moved to newState
+//====================================================================================
+func (n *Node) ChangeState(newState string) {
+	fmt.Println(n.Name, "OldState=", n.State, " NewState=", newState)
+	n.State = newState
+	n.LastChangeTime = float64(TBtimestampNano())
+}
+
+//====================================================================================
+// lookAtGround - where we are now, can the ground station see us ? Our distance
+// to the ground is the slant range if it can, not known otherwise
+//====================================================================================
+func (n *Node) lookAtGround() {
+	if n.GroundStation == nil {
+		return
+	}
+	look := n.GroundStation.Look(n.Mobility.Position())
+	if look.Visible != n.groundLook.Visible {
+		fmt.Println("VISIBILITY: ground station in sight=", look.Visible, " Range=", look.Range,
+			" Elevation=", look.Elevation, " Azimuth=", look.Azimuth)
+	}
+	n.groundLook = look
+	n.Connectivity.Radio.SetGroundRange(look.GroundRange())
+	if look.Visible {
+		n.Connectivity.Ground.SetDistance(look.Range, n.GroundRadioRange)
+	} else {
+		n.Connectivity.Ground.SetDistance(GROUND_DISTANCE_UNKNOWN, n.GroundRadioRange)
+	}
+}
+
+//====================================================================================
+// RECEIVE AND PROCESS MESSAGES: Control Plane msgs, the timer and Commands from
+// console. Note that this software is implemented as FSM with run to completion
+//====================================================================================
+func (n *Node) run(ctx context.Context, console <-chan []string) {
+	defer close(n.done)
+	defer n.cancel()
+
+	fmt.Println(n.Name, "MAIN: Starting a new Timer Ticker at ", n.TickInterval)
+	ticker := time.NewTicker(n.TickInterval)
+	defer ticker.Stop()
+
+	var stopped <-chan struct{} // closed once the receive threads are gone
+	done := ctx.Done()
+	shutdown := func() {
+		if stopped == nil {
+			n.role.Stop(n)
+			stopped = ControlPlaneCloseConnections(n.Connectivity)
+			ticker.Stop()
+		}
+	}
+	for {
+		select {
+		case <-done:
+			done = nil
+			shutdown()
+		case <-stopped:
+			// everything the receive threads read has been processed by now
+			fmt.Println(n.Name, "MAIN: STOPPED")
+			return
+		case tick := <-ticker.C:
+			n.tick(tick)
+		case UnicastMsg := <-n.Channels.UnicastRcvCtrlChannel:
+			n.ControlPlaneMessages(UnicastMsg)
+		case BroadcastMsg := <-n.Channels.BroadcastRcvCtrlChannel:
+			n.ControlPlaneMessages(BroadcastMsg)
+		case MulticastMsg := <-n.Channels.MulticastRcvCtrlChannel:
+			n.ControlPlaneMessages(MulticastMsg)
+		case late := <-n.delayed:
+			n.receive(late.packet, late.msgHeader)
+		case CmdText, ok := <-console: // These are messsages from local console
+			if !ok {
+				fmt.Println("ERROR Reading input from stdin:", CmdText)
+				console = nil
+				break
+			}
+			fmt.Println(n.Name, "====> MAIN: Console Input: ", CmdText)
+			if CmdText[0] == "quit" || CmdText[0] == "exit" {
+				shutdown()
+				break
+			}
+			n.command(CmdText)
+		}
+	}
+}
+
+//====================================================================================
+// Every TickInterval: move, the role's timers, then forget what we have not heard
+// of lately and tell the neighbors what we know
+//====================================================================================
+func (n *Node) tick(tick time.Time) {
+	if position, moved := n.Mobility.Advance(tick); moved {
+		n.Connectivity.Radio.SetPosition(position)
+		n.lookAtGround()
+	}
+	if order := n.moveOrder; order != nil && order.model.Arrived() {
+		n.moveOrder = nil
+		n.sendMoveAck(order, MoveAckMsgBody{Accepted: true, Arrived: true, Target: order.model.Target()})
+	}
+	n.role.Tick(n, tick)
+
+	n.Connectivity.Routes.Expire(tick)
+	n.Connectivity.Ground.Expire(tick)
+	n.Connectivity.Matrix.Expire(tick)
+	if n.Connectivity.Routes.AdvertiseDue(tick) {
+		n.SendRoutes()
+	}
+}
+
+//====================================================================================
+// Console commands every node knows, the rest go to the role
+//====================================================================================
+func (n *Node) command(cmd []string) {
+	switch cmd[0] {
+	case "status":
+		fmt.Println("STATUS REPLY: Name=", n.Name, " State=", n.State, " Active=", n.Active,
+			" Unknown MsgCodes=", n.Dispatcher.UnknownCodes(), " Decode errors=", n.Dispatcher.DecodeFailures(),
+			" Duplicates=", n.Duplicates.Duplicates(), " Restarts=", n.Incarnations.Restarts())
+		relayed, expired := n.Connectivity.Flood.Stats()
+		fmt.Println("FLOOD: Relayed=", relayed, " TTL expired=", expired)
+		heard, outOfRange, lost := n.Connectivity.Radio.Stats()
+		fmt.Println("RADIO: Position=", n.Connectivity.Radio.Position(), " Range=", n.Connectivity.Radio.Range(),
+			" Heard=", heard, " Out of range=", outOfRange, " Lost=", lost)
+		fmt.Println("MOBILITY: Model=", n.Mobility.Model().Name(), " Position=", n.Mobility.Position(),
+			" simulated time=", n.Mobility.SimTime())
+		if orbit, ok := n.Mobility.Model().(*OrbitMobility); ok {
+			latitude, longitude, altitude := TBlatLongFromPosition(n.Mobility.Position())
+			fmt.Println("ORBIT:", orbit.Orbit(), " Period=", orbit.Orbit().Period(),
+				" Lat/Long/Alt=", latitude, longitude, altitude)
+		}
+		if n.GroundStation != nil {
+			fmt.Println("VISIBILITY: Visible=", n.groundLook.Visible, " Range=", n.groundLook.Range,
+				" Elevation=", n.groundLook.Elevation, " Azimuth=", n.groundLook.Azimuth,
+				" Mask=", n.GroundStation.MinElevation)
+		}
+		if forwarder, ok := n.Connectivity.Ground.Forwarder(); ok {
+			fmt.Println("GROUND: Forwarder=", forwarder.Id, " distance=", forwarder.Distance,
+				" next hop to ground=", n.Connectivity.Ground.Next())
+		}
+		n.role.Status(n)
+		for _, route := range n.Connectivity.Routes.Routes() {
+			fmt.Println("ROUTE: DstID=", route.DstId, " NextHop=", route.NextHop, " at", route.Address,
+				" Metric=", route.Metric)
+		}
+		for _, row := range n.Connectivity.Matrix.Rows() {
+			fmt.Println("MATRIX: ID=", row.NodeId, " hears", row.Neighbors)
+		}
+	case "reach": // reach <node> <hops> - who reaches node in that many hops
+		if len(cmd) < 3 {
+			fmt.Println("usage: reach <node> <hops>")
+			break
+		}
+		node, err1 := strconv.Atoi(cmd[1])
+		hops, err2 := strconv.Atoi(cmd[2])
+		if err1 != nil || err2 != nil {
+			fmt.Println("usage: reach <node> <hops>")
+			break
+		}
+		fmt.Println("MATRIX: reach", node, "in", hops, "hops:", n.Connectivity.Matrix.ReachableInHops(node, hops))
+	case "move": // move <id> <dx> <dy> [<dz>], or move <id> to <lat> <long> [<alt>] - id 0 is us
+		if len(cmd) < 2 {
+			fmt.Println("usage: move <id> <dx> <dy> [<dz>] | move <id> to <lat> <long> [<alt>]")
+			break
+		}
+		id, err := strconv.Atoi(cmd[1])
+		move, ok := parseMove(cmd[2:])
+		if err != nil || !ok {
+			fmt.Println("usage: move <id> <dx> <dy> [<dz>] | move <id> to <lat> <long> [<alt>]")
+			break
+		}
+		if id == 0 || id == n.Id {
+			n.move(move, &moveOrder{})
+			break
+		}
+		if err = n.SendMove(id, move); err != nil {
+			fmt.Println("MOVE: ID=", id, " err=", err)
+		}
+	case "contacts": // contacts [<hours>] - when the ground station will see us next
+		hours := float64(CONTACT_HORIZON)
+		if len(cmd) > 1 {
+			hours, _ = strconv.ParseFloat(cmd[1], 64)
+		}
+		orbit, ok := n.Mobility.Model().(*OrbitMobility)
+		if !ok || n.GroundStation == nil {
+			fmt.Println("VISIBILITY: contacts are predicted in orbit, with a known ground station")
+			break
+		}
+		now := n.Mobility.SimTime()
+		horizon := time.Duration(hours * float64(time.Hour))
+		for _, window := range n.GroundStation.ContactWindows(orbit.Orbit(), now, horizon, 0) {
+			fmt.Println("CONTACT: in", window.Rise-now, " for", window.Set-window.Rise,
+				" max elevation=", window.MaxElevation)
+		}
+	case "enable":
+		n.Active = true
+	case "disable":
+		n.Active = false
+	default:
+		if !n.role.Command(n, cmd) {
+			fmt.Println(n.Name, "MAIN: unknown command", cmd[0])
+		}
+	}
+}
+
+//====================================================================================
+// ControlPlaneMessages() - handle Control Plane messages: drop ours, the ones our
+// radio would not have heard and the ones we already got, relay, acknowledge
+// and dispatch the rest
+//====================================================================================
+func (n *Node) ControlPlaneMessages(packet InboundPacket) {
+	msgHeader, err1 := TBdecodeHeader(packet.Payload)
+	if err1 != nil {
+		println("Error unmarshalling message: ", err1.Error())
+		return
+	}
+	// Was this msg originated by us ?
+	if msgHeader.SrcIP == n.IP && msgHeader.SrcId == n.Id {
+		return
+	}
+	// Nor can the ground station and us hear each other with it below our horizon
+	if msgHeader.SrcId == GROUND_STATION_ID && msgHeader.Hops == 0 && n.GroundStation != nil &&
+		!n.groundLook.Visible {
+		return
+	}
+	//============================================================================
+	// Is the other side within the RF range ?
+	// we need to do this for ethernet connectivity as we receive everything
+	//============================================================================
+	delay, heard := n.Connectivity.Radio.Receive(msgHeader)
+	if !heard {
+		return
+	}
+	if delay > 0 {
+		done := n.done
+		time.AfterFunc(delay, func() {
+			select {
+			case n.delayed <- delayedPacket{packet: packet, msgHeader: msgHeader}:
+			case <-done: // we stopped meanwhile, the packet is gone with the rest
+			}
+		})
+		return
+	}
+	n.receive(packet, msgHeader)
+}
+
+// receive - the packet, whose header is msgHeader, reached us over the air
+func (n *Node) receive(packet InboundPacket, msgHeader *MessageHeader) {
+	sender := msgHeader.SrcId
+	// Did SrcIP survive the trip ? Either spoofed or rewritten by a NAT, in both
+	// cases anything we send back goes to where the packet really came from
+	if packet.SourceMismatch(msgHeader) {
+		fmt.Println("SOURCE MISMATCH: SrcIP=", msgHeader.SrcIP, " real source=", packet.Source,
+			" on", packet.Socket, " SrcID=", msgHeader.SrcId, " MsgCode=", msgHeader.MsgCode)
+	}
+	// First check that the senders id is valid, any id from 1 up
+	if sender == n.Id || sender < 1 {
+		println("Sender id WRONG: ", sender, " MsgCode=", msgHeader.MsgCode)
+		return
+	}
+	// Heard directly, so the sender is one of our neighbors
+	if msgHeader.Hops == 0 {
+		n.Connectivity.Routes.HeardFrom(sender, packet.ReplyAddress(msgHeader))
+	}
+	forUs := msgHeader.DstId == 0 || msgHeader.DstId == n.Id
+	// Already got this one, on another socket or through another relay ?
+	if n.Duplicates.Seen(msgHeader) {
+		if forUs && msgHeader.AckReq {
+			n.ack(packet, msgHeader) // our first ACK may have been lost
+		}
+		return
+	}
+	// Pass it on: towards the ground or along our route if it is unicast to another
+	// node, otherwise for those out of range of the sender
+	if !forUs && (ControlPlaneGroundForward(n.Connectivity, packet, msgHeader) ||
+		ControlPlaneRouteForward(n.Connectivity, packet, msgHeader)) {
+		return
+	}
+	n.Connectivity.Flood.Relay(packet, msgHeader)
+	if !forUs {
+		return
+	}
+	n.MsgsRcvd++
+	// The sender wants to know we got it
+	if msgHeader.AckReq {
+		n.ack(packet, msgHeader)
+	}
+	n.Dispatcher.Dispatch(packet, msgHeader)
+}
+
+//====================================================================================
+// ack - tell the sender of msgHeader, received in packet, we got it: straight
+// back if we heard it from the sender, otherwise along our route to it, or
+// flooded as far as the message came if we have none
+//====================================================================================
+func (n *Node) ack(packet InboundPacket, msgHeader *MessageHeader) {
+	if msgHeader.Hops == 0 {
+		n.Connectivity.Reliable.Ack(packet, msgHeader)
+		return
+	}
+	myMsg := MsgCodeAck{
+		MsgHeader: n.Header(MSG_TYPE_ACK, replyTtl(msgHeader), msgHeader.SrcId, ""),
+		MsgAck:    AckMsgBody{AckSeq: msgHeader.SrcSeq},
+	}
+	err := n.SendTo(&myMsg.MsgHeader, &myMsg, false)
+	if errors.Is(err, ErrNoRoute) {
+		n.Send(myMsg, "")
+	} else if err != nil {
+		fmt.Println("RELIABLE: ERROR sending ACK to SrcID=", msgHeader.SrcId, " err=", err)
+	}
+}
+
+// replyTtl - the Ttl msgHeader was sent with, an answer may need to go as far
+func replyTtl(msgHeader *MessageHeader) int {
+	return msgHeader.Ttl + msgHeader.Hops
+}
+
+//====================================================================================
+// A reliable message was never acknowledged. Called from the retransmit timer,
+// so only report it, do not touch the node
+//====================================================================================
+func (n *Node) deliveryFailed(msgHeader MessageHeader, address string) {
+	fmt.Println("DELIVERY FAILED: MsgCode=", msgHeader.MsgCode, " seq=", msgHeader.SrcSeq,
+		" DstID=", msgHeader.DstId, " to", address)
+}
+
+// A peer restarted, what we know of its messages is stale
+func (n *Node) peerRestarted(event IncarnationEvent) {
+	fmt.Println("RESTART: ID=", event.Id, " incarnation", int64(event.Old), "->", int64(event.New),
+		" seq=", event.Seq)
+	n.Duplicates.Forget(event.Id)
+}
+
+//====================================================================================
+// ControlPlaneMessage DISCOVERY
+//====================================================================================
+func (n *Node) handleDiscoveryMsg(packet InboundPacket, msgHeader *MessageHeader, msg interface{}) {
+	discoveryMsg := msg.(*MsgCodeDiscovery)
+	// a new incarnation of SrcId ? then forget the old one first
+	n.Incarnations.Observe(msgHeader.SrcId, discoveryMsg.MsgDiscovery.TimeCreated, msgHeader.SrcSeq)
+	// a possible ground forwarder, if it is one of our neighbors
+	if msgHeader.Hops == 0 {
+		n.Connectivity.Ground.Update(msgHeader.SrcId, packet.ReplyAddress(msgHeader),
+			discoveryMsg.MsgDiscovery.GroundDistance, discoveryMsg.MsgDiscovery.GroundRange)
+		n.Connectivity.Matrix.Heard(msgHeader.SrcId)
+	}
+	// who SrcId hears, relayed or not
+	n.Connectivity.Matrix.Update(msgHeader.SrcId, discoveryMsg.MsgDiscovery.Neighbors)
+	n.role.Discovery(n, packet, msgHeader, &discoveryMsg.MsgDiscovery)
+}
+
+//====================================================================================
+// ControlPlaneMessage ROUTES - a neighbor's routing table
+//====================================================================================
+func (n *Node) handleRoutesMsg(packet InboundPacket, msgHeader *MessageHeader, msg interface{}) {
+	if msgHeader.Hops > 0 {
+		return // only our neighbors' routes are of any use to us
+	}
+	routesMsg := msg.(*MsgCodeRoutes)
+	n.Connectivity.Routes.Update(msgHeader.SrcId, packet.ReplyAddress(msgHeader), routesMsg.MsgRoutes.Routes)
+}
+
+//====================================================================================
+// ControlPlaneMessage GROUNDINFO - roles that want more out of it subscribe their
+// own handler, and call HeardGround themselves
+//====================================================================================
+func (n *Node) handleGroundInfoMsg(packet InboundPacket, msgHeader *MessageHeader, _ interface{}) {
+	if msgHeader.Hops == 0 {
+		n.Connectivity.Ground.HeardGround(packet.ReplyAddress(msgHeader))
+	}
+}
+
+//====================================================================================
+// ControlPlaneMessage STATUS REQ - reply, through the ground forwarder if the
+// request came that way
+//====================================================================================
+func (n *Node) handleStatusRequest(packet InboundPacket, msgHeader *MessageHeader, _ interface{}) {
+	fmt.Println("...... STATUS REQUEST: srcIP=", msgHeader.SrcIP, " SrcMAC=", msgHeader.SrcMAC,
+		" DstID=", msgHeader.DstId, " SrcID=", msgHeader.SrcId)
+	replyAddress := n.replyAddress(packet, msgHeader)
+	myMsg := MsgCodeStatusReply{
+		MsgHeader: n.Header(MSG_TYPE_STATUS_REPLY, 3, msgHeader.SrcId, replyAddress), // 3, may have to go through the ground forwarder
+		MsgStatusReply: StatusReplyMsgBody{
+			TimeCreated:    TBincarnation(n.TimeCreated),
+			LastChangeTime: n.LastChangeTime,
+			NodeActive:     n.Active,
+			MsgsSent:       n.MsgsSent,
+			MsgsRcvd:       n.MsgsRcvd,
+		},
+	}
+	// the ground is waiting for this one, resend until it is acknowledged
+	err := n.SendReliable(&myMsg.MsgHeader, &myMsg, replyAddress)
+	if err != nil {
+		fmt.Println("ERROR sending STATUS REPLY to", replyAddress, " err=", err)
+	}
+}
+
+//====================================================================================
+// ControlPlaneMessage DRONE MOVE - go where we are told, and say where we are
+//====================================================================================
+func (n *Node) handleMoveMsg(packet InboundPacket, msgHeader *MessageHeader, msg interface{}) {
+	move := msg.(*MsgCodeMove).MsgMove
+	fmt.Println(n.Name, "MOVE from SrcID=", msgHeader.SrcId, " Kind=", move.Kind,
+		" Lat/Long/Alt=", move.Latitude, move.Longitude, move.Altitude, " DX/DY/DZ=", move.DX, move.DY, move.DZ,
+		" Velocity=", move.Velocity, " ETA=", move.ETA)
+	n.move(move, &moveOrder{
+		seq:     msgHeader.SrcSeq,
+		srcId:   msgHeader.SrcId,
+		ttl:     replyTtl(msgHeader),
+		address: n.replyAddress(packet, msgHeader),
+	})
+}
+
+//====================================================================================
+// SendMove - order node dstId to move, along our route to it. It tells us with a
+// DRONE_MOVE_ACK what it makes of it, and another one once it gets there
+//====================================================================================
+func (n *Node) SendMove(dstId int, move MoveMsgBody) error {
+	myMsg := MsgCodeMove{
+		MsgHeader: n.Header(MSG_TYPE_DRONE_MOVE, 3, dstId, ""),
+		MsgMove:   move,
+	}
+	return n.SendTo(&myMsg.MsgHeader, &myMsg, true)
+}
+
+// parseMove - <dx> <dy> [<dz>], or to <lat> <long> [<alt>], false if it is neither
+func parseMove(args []string) (MoveMsgBody, bool) {
+	move := MoveMsgBody{Kind: MOVE_RELATIVE}
+	if len(args) > 0 && args[0] == "to" {
+		move.Kind = MOVE_ABSOLUTE
+		args = args[1:]
+	}
+	if len(args) < 2 || len(args) > 3 {
+		return move, false
+	}
+	values := []float64{0, 0, 0}
+	for i, arg := range args {
+		value, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return move, false
+		}
+		values[i] = value
+	}
+	if move.Kind == MOVE_ABSOLUTE {
+		move.Latitude, move.Longitude, move.Altitude = values[0], values[1], values[2]
+	} else {
+		move.DX, move.DY, move.DZ = values[0], values[1], values[2]
+	}
+	return move, true
+}
+
+//====================================================================================
+// move - head where move says, in a straight line, and tell who ordered it what
+// we make of it. Targets outside the playing field are clipped to it, unless
+// THREE_DIMENSIONAL we stay at our altitude, and in orbit we do not move at all
+//====================================================================================
+func (n *Node) move(move MoveMsgBody, order *moveOrder) {
+	position := n.Mobility.Position()
+	if position.ECEF {
+		n.sendMoveAck(order, MoveAckMsgBody{Reason: "in orbit, we go where the orbit takes us", Target: position})
+		return
+	}
+	var target Position
+	switch move.Kind {
+	case MOVE_ABSOLUTE:
+		target = TBpositionFromLatLong(move.Latitude, move.Longitude, move.Altitude)
+	case MOVE_RELATIVE:
+		target = Position{X: position.X + move.DX, Y: position.Y + move.DY, Z: position.Z + move.DZ}
+	default:
+		n.sendMoveAck(order, MoveAckMsgBody{Reason: "no such kind of move: " + move.Kind, Target: position})
+		return
+	}
+	if !THREE_DIMENSIONAL {
+		target.Z = position.Z
+	}
+	ack := MoveAckMsgBody{Accepted: true}
+	target, inField := TBinField(target)
+	if !inField {
+		ack.Reason = "clipped to the playing field"
+	}
+	dx, dy, dz := target.X-position.X, target.Y-position.Y, target.Z-position.Z
+	distance := math.Sqrt(dx*dx + dy*dy + dz*dz)
+	velocity := move.Velocity
+	if velocity <= 0 && move.ETA > 0 {
+		velocity = distance / (move.ETA / 3600)
+	}
+	if velocity <= 0 {
+		velocity = DEFAULT_VELOCITY
+	}
+	order.model = NewGotoMobility(target, velocity)
+	n.Mobility.SetModel(order.model)
+	n.moveOrder = order // a move we had not finished yet is forgotten
+	ack.Target = target
+	ack.ETA = distance / velocity * 3600
+	n.sendMoveAck(order, ack)
+}
+
+//====================================================================================
+// sendMoveAck - ack, with where we are now, to whoever ordered the move, along
+// our route to them, or to where we heard them if we have none
+//====================================================================================
+func (n *Node) sendMoveAck(order *moveOrder, ack MoveAckMsgBody) {
+	ack.MoveSeq = order.seq
+	ack.Position = n.Mobility.Position()
+	ack.Latitude, ack.Longitude, ack.Altitude = TBlatLongFromPosition(ack.Position)
+	fmt.Println(n.Name, "MOVE: Accepted=", ack.Accepted, " Arrived=", ack.Arrived, " Reason=", ack.Reason,
+		" Position=", ack.Position, " Target=", ack.Target, " ETA=", ack.ETA)
+	if order.address == "" {
+		return // from our console
+	}
+	myMsg := MsgCodeMoveAck{
+		MsgHeader:  n.Header(MSG_TYPE_DRONE_MOVE_ACK, order.ttl, order.srcId, order.address),
+		MsgMoveAck: ack,
+	}
+	err := n.SendTo(&myMsg.MsgHeader, &myMsg, true)
+	if errors.Is(err, ErrNoRoute) {
+		err = n.SendReliable(&myMsg.MsgHeader, &myMsg, order.address)
+	}
+	if err != nil {
+		fmt.Println("ERROR sending MOVE ACK to ID=", order.srcId, " at", order.address, " err=", err)
+	}
+}
+
+//====================================================================================
+// replyAddress - where to answer the packet, whose header is msgHeader: through
+// the ground forwarder if it came from the ground that way
+//====================================================================================
+func (n *Node) replyAddress(packet InboundPacket, msgHeader *MessageHeader) string {
+	if msgHeader.SrcId == GROUND_STATION_ID && msgHeader.Hops > 0 {
+		if next := n.Connectivity.Ground.Next(); next != "" {
+			return next
+		}
+	}
+	return packet.ReplyAddress(msgHeader)
+}
+
+//====================================================================================
+// ControlPlaneMessage TERMINATE - stop, as if quit was entered on the console,
+// if the ground or our controller says so
+//====================================================================================
+func (n *Node) handleTerminateMsg(packet InboundPacket, msgHeader *MessageHeader, _ interface{}) {
+	if msgHeader.SrcId != GROUND_STATION_ID && (n.ControllerId == 0 || msgHeader.SrcId != n.ControllerId) {
+		fmt.Println(n.Name, "TERMINATE from SrcID=", msgHeader.SrcId, " at", packet.Source,
+			" ignored, not the ground or our controller")
+		return
+	}
+	fmt.Println(n.Name, "TERMINATE from SrcID=", msgHeader.SrcId, " at", packet.Source)
+	n.Stop()
+}
+
+//====================================================================================
+// Header - for a msgCode message to node dstId at address, or to everybody if
+// address is "". It gets our next SrcSeq and its Hash, put the body next to it
+// and Send it
+//====================================================================================
+func (n *Node) Header(msgCode string, ttl, dstId int, address string) MessageHeader {
+	n.mutex.Lock()
+	seq := n.nextSeq
+	n.nextSeq = TBnextSeq(n.nextSeq)
+	n.mutex.Unlock()
+
+	msgHeader := MessageHeader{
+		MsgCode:  msgCode,
+		Ttl:      ttl,
+		TimeSent: float64(TBtimestampNano()),
+		SrcSeq:   seq,
+		SrcMAC:   n.Mac,
+		SrcName:  n.Name,
+		SrcId:    n.Id, // node ids are 1 based
+		SrcIP:    n.IP,
+		SrcPort:  n.Port,
+		DstName:  "UNICAST",
+		DstId:    dstId,
+	}
+	if address == "" {
+		msgHeader.DstName = "BROADCAST"
+		msgHeader.DstIP, msgHeader.DstPort = n.Connectivity.BroadcastTxIP, n.Connectivity.BroadcastTxPort
+	} else {
+		msgHeader.DstIP, msgHeader.DstPort, _ = net.SplitHostPort(address)
+	}
+	msgHeader.Hash = TBheaderHash(&msgHeader) // lets receivers drop duplicates
+	n.Connectivity.Radio.Stamp(&msgHeader)
+	return msgHeader
+}
+
+//====================================================================================
+// Send - msg, with a header from Header, to address; if address is "" to our
+// multicast group, or broadcast if we have none
+//====================================================================================
+func (n *Node) Send(msg interface{}, address string) {
+	msgOut, err := TBencode(n.Connectivity.WireCodec, msg)
+	if err != nil {
+		fmt.Println("ERROR encoding message for", address, " err=", err)
+		return
+	}
+	n.MsgsSent++
+	switch {
+	case address != "":
+		ControlPlaneUnicastSend(*n.Connectivity, msgOut, address)
+	case n.Connectivity.MulticastAddress != "":
+		ControlPlaneMulticastSend(*n.Connectivity, msgOut)
+	default:
+		ControlPlaneBroadcastSend(*n.Connectivity, msgOut, n.Connectivity.BroadcastTxStruct)
+	}
+}
+
+//====================================================================================
+// SendReliable - msg, whose header msgHeader points to, to address, resent until
+// acknowledged
+//====================================================================================
+func (n *Node) SendReliable(msgHeader *MessageHeader, msg interface{}, address string) error {
+	n.MsgsSent++
+	return n.Connectivity.Reliable.Send(msgHeader, msg, address)
+}
+
+//====================================================================================
+// SendTo - msg, whose header msgHeader points to, to node msgHeader.DstId along
+// our route to it; resent until acknowledged if reliable. The header is addressed
+// to the next hop. Fails with ErrNoRoute if we have no route to DstId
+//====================================================================================
+func (n *Node) SendTo(msgHeader *MessageHeader, msg interface{}, reliable bool) error {
+	route, ok := n.Connectivity.Routes.Lookup(msgHeader.DstId)
+	if !ok {
+		return fmt.Errorf("%w %d", ErrNoRoute, msgHeader.DstId)
+	}
+	msgHeader.DstName = "UNICAST"
+	msgHeader.DstIP, msgHeader.DstPort, _ = net.SplitHostPort(route.Address)
+	if reliable {
+		return n.SendReliable(msgHeader, msg, route.Address)
+	}
+	msgOut, err := TBencode(n.Connectivity.WireCodec, msg)
+	if err != nil {
+		return err
+	}
+	n.MsgsSent++
+	return ControlPlaneRouteSend(*n.Connectivity, msgOut, msgHeader.DstId)
+}
+
+//====================================================================================
+// SendDiscovery - tell address, or everybody if it is "", we are here
+//====================================================================================
+func (n *Node) SendDiscovery(address string) {
+	n.SendHello(0, address, 0)
+}
+
+// SendHello - SendDiscovery, to peer dstId at address, which we have session
+// sessionId with, 0 if none
+func (n *Node) SendHello(dstId int, address string, sessionId int) {
+	discBody := DiscoveryMsgBody{
+		SessionId:      sessionId,
+		TimeCreated:    TBincarnation(n.TimeCreated),
+		NodeActive:     n.Active,
+		LastChangeTime: n.LastChangeTime,
+		MsgsSent:       n.MsgsSent,
+		MsgsRcvd:       n.MsgsRcvd,
+	}
+	discBody.GroundDistance, discBody.GroundRange = n.Connectivity.Ground.Own()
+	discBody.Neighbors = n.Connectivity.Matrix.Own()
+	discBody.Position = n.Mobility.Position()
+	if n.HelloInterval > 0 { // hellos go out on a tick, the next one may be a tick late
+		discBody.HelloInterval = int64((n.HelloInterval + n.TickInterval) / time.Millisecond)
+	}
+	myMsg := MsgCodeDiscovery{
+		MsgHeader:    n.Header(MSG_TYPE_DISCOVERY, 3, dstId, address),
+		MsgDiscovery: discBody,
+	}
+	n.Send(myMsg, address)
+}
+
+//====================================================================================
+// SendRoutes - our routing table, to our neighbors only
+//====================================================================================
+func (n *Node) SendRoutes() {
+	myMsg := MsgCodeRoutes{
+		MsgHeader: n.Header(MSG_TYPE_ROUTES, 1, 0, ""),
+		MsgRoutes: RoutesMsgBody{Routes: n.Connectivity.Routes.Advertise()},
+	}
+	n.Send(myMsg, "")
+}
@@ -0,0 +1,154 @@
+//=============================================================================
+// FILE NAME: tbOrbit.go
+// DESCRIPTION:
+// Keplerian orbits, so satellite terminals know where they are as simulated
+// time goes by. An Orbit is given by the altitude of its semi-major axis above
+// EARTH_RADIUS, eccentricity (0 for circular), inclination, right ascension of
+// the ascending node (RAAN), argument of perigee and phase, the mean anomaly at
+// the start of the simulation. It gives the satellite's position Earth centered
+// inertial (ECI), and Earth centered, Earth fixed (ECEF), with the two frames
+// lined up when the simulation starts. The Earth is a sphere of EARTH_RADIUS,
+// and nothing but its center pulls on the satellite.
+// OrbitMobility is the MobilityModel of nodes in orbit, their positions are
+// ECEF, see Position. Positions in the playing field are turned into ECEF by
+// TBecef, to compare the two.
+// Typical use:
+//   orbit := common.Orbit{Altitude: 550, Inclination: 53, RAAN: 0, Phase: 90}
+//   position := orbit.ECEF(Node.Mobility.SimTime())
+//   latitude, longitude, altitude := common.TBlatLongFromPosition(position)
+//================================================================================
+package common
+
+import (
+	"math"
+	"time"
+)
+
+const EARTH_MU = 398600.4418        // km^3/sec^2, Earth's gravitational parameter
+const EARTH_ROTATION = 7.2921159e-5 // rad/sec, sidereal
+const KEPLER_ITERATIONS = 16        // at most, solving Kepler's equation
+const KEPLER_TOLERANCE = 1e-12      // rad, close enough for Kepler's equation
+
+type Orbit struct {
+	Altitude     float64 // km above EARTH_RADIUS, of the semi-major axis
+	Eccentricity float64 // 0 = circular, up to but not 1
+	Inclination  float64 // degrees
+	RAAN         float64 // degrees, right ascension of the ascending node
+	ArgPerigee   float64 // degrees, argument of perigee, no meaning for circular orbits
+	Phase        float64 // degrees, mean anomaly when the simulation starts
+}
+
+// SemiMajorAxis - km, from the center of the Earth
+func (o Orbit) SemiMajorAxis() float64 {
+	return EARTH_RADIUS + o.Altitude
+}
+
+// Period - of one revolution
+func (o Orbit) Period() time.Duration {
+	return time.Duration(2 * math.Pi / o.meanMotion() * float64(time.Second))
+}
+
+// rad/sec
+func (o Orbit) meanMotion() float64 {
+	a := o.SemiMajorAxis()
+	return math.Sqrt(EARTH_MU / (a * a * a))
+}
+
+//====================================================================================
+// ECI - where the satellite is, simTime after the simulation started, km from
+// the center of the Earth, X towards the vernal equinox and Z to the north pole.
+// Not a node's position, ECEF is
+//====================================================================================
+func (o Orbit) ECI(simTime time.Duration) Position {
+	e := math.Max(0, math.Min(0.999, o.Eccentricity))
+	meanAnomaly := math.Mod(o.Phase*math.Pi/180+o.meanMotion()*simTime.Seconds(), 2*math.Pi)
+
+	// Kepler's equation, M = E - e sin E, by Newton
+	eccentricAnomaly := meanAnomaly
+	for i := 0; i < KEPLER_ITERATIONS; i++ {
+		step := (eccentricAnomaly - e*math.Sin(eccentricAnomaly) - meanAnomaly) / (1 - e*math.Cos(eccentricAnomaly))
+		eccentricAnomaly -= step
+		if math.Abs(step) < KEPLER_TOLERANCE {
+			break
+		}
+	}
+	trueAnomaly := 2 * math.Atan2(math.Sqrt(1+e)*math.Sin(eccentricAnomaly/2),
+		math.Sqrt(1-e)*math.Cos(eccentricAnomaly/2))
+	radius := o.SemiMajorAxis() * (1 - e*math.Cos(eccentricAnomaly))
+
+	// from the orbital plane, u from the ascending node, to ECI
+	u := o.ArgPerigee*math.Pi/180 + trueAnomaly
+	raan := o.RAAN * math.Pi / 180
+	inclination := o.Inclination * math.Pi / 180
+	return Position{
+		X: radius * (math.Cos(raan)*math.Cos(u) - math.Sin(raan)*math.Sin(u)*math.Cos(inclination)),
+		Y: radius * (math.Sin(raan)*math.Cos(u) + math.Cos(raan)*math.Sin(u)*math.Cos(inclination)),
+		Z: radius * math.Sin(u) * math.Sin(inclination),
+	}
+}
+
+//====================================================================================
+// ECEF - where the satellite is, simTime after the simulation started, over the
+// Earth that turned under it meanwhile
+//====================================================================================
+func (o Orbit) ECEF(simTime time.Duration) Position {
+	eci := o.ECI(simTime)
+	angle := EARTH_ROTATION * simTime.Seconds()
+	return Position{
+		X:    math.Cos(angle)*eci.X + math.Sin(angle)*eci.Y,
+		Y:    -math.Sin(angle)*eci.X + math.Cos(angle)*eci.Y,
+		Z:    eci.Z,
+		ECEF: true,
+	}
+}
+
+//====================================================================================
+// OrbitMobility - a node in orbit, wherever the orbit takes it
+//====================================================================================
+type OrbitMobility struct {
+	orbit   Orbit
+	simTime time.Duration // since the simulation started
+}
+
+func NewOrbitMobility(orbit Orbit) *OrbitMobility {
+	return &OrbitMobility{orbit: orbit}
+}
+
+func (o *OrbitMobility) Next(_ Position, elapsed time.Duration) Position {
+	o.simTime += elapsed
+	return o.orbit.ECEF(o.simTime)
+}
+
+func (o *OrbitMobility) Name() string { return MOBILITY_ORBIT }
+
+func (o *OrbitMobility) Orbit() Orbit { return o.orbit }
+
+// Position - where the orbit has taken us
+func (o *OrbitMobility) Position() Position {
+	return o.orbit.ECEF(o.simTime)
+}
+
+//====================================================================================
+// TBecef - position as ECEF, positions in the playing field are on the Earth
+// around FIELD_LATITUDE, FIELD_LONGITUDE
+//====================================================================================
+func TBecef(position Position) Position {
+	if position.ECEF {
+		return position
+	}
+	latitude, longitude, altitude := TBlatLongFromPosition(position)
+	x, y, z := ConvertLatLongToXYZ(latitude, longitude, EARTH_RADIUS+altitude)
+	return Position{X: x, Y: y, Z: z, ECEF: true}
+}
+
+// TBlatLongFromECEF - latitude and longitude, in degrees, and altitude, in km,
+// over the spherical Earth, of ECEF position
+func TBlatLongFromECEF(position Position) (latitude, longitude, altitude float64) {
+	radius := math.Sqrt(position.X*position.X + position.Y*position.Y + position.Z*position.Z)
+	if radius == 0 {
+		return 0, 0, -EARTH_RADIUS
+	}
+	latitude = math.Asin(position.Z/radius) * 180 / math.Pi
+	longitude = math.Atan2(position.Y, position.X) * 180 / math.Pi
+	return latitude, longitude, radius - EARTH_RADIUS
+}
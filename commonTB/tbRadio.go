@@ -0,0 +1,181 @@
+//=============================================================================
+// FILE NAME: tbRadio.go
+// DESCRIPTION:
+// RF link emulation, so a flat ethernet or Docker network, where every node
+// receives everything, behaves like a mesh of radios. Every packet carries where
+// the node that put it on the air was, SrcX/SrcY/SrcZ, stamped by the sender and
+// again by every relay. A receiver with a RadioRange drops what comes from
+// farther away than that, see TBdistance, and optionally loses
+// some of the rest, more the farther the sender, and delays it. Packets with no
+// position (ground station, older nodes) are always heard.
+// Typical use:
+//   connectivity.Radio = common.NewRadioLink(common.RANGE_2D, position)
+//   connectivity.Radio.Stamp(&msgHeader)                  // on everything we send
+//   delay, heard := connectivity.Radio.Receive(msgHeader) // on everything received
+//================================================================================
+package common
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Position - km, inside X_WIDTH x Y_WIDTH x Z_WIDTH, or from the center of the
+// Earth for nodes in orbit
+type Position struct {
+	X    float64
+	Y    float64
+	Z    float64
+	ECEF bool // Earth centered, Earth fixed, see Orbit; not in the playing field
+}
+
+type RadioLink struct {
+	mutex       sync.Mutex
+	position    Position
+	radioRange  float64       // km, 0 = no emulation, we hear whatever the network delivers
+	edgeLoss    float64       // probability of losing a packet sent from radioRange away
+	edgeDelay   time.Duration // latency of a packet sent from radioRange away
+	groundRange int           // for MessageHeader.GroundRange, see Look
+	random      *rand.Rand
+	heard       int64
+	outOfRange  int64
+	lost        int64
+}
+
+//====================================================================================
+// NewRadioLink - a radio at position hearing up to radioRange km away, with no
+// loss or latency until SetLoss and SetLatency say otherwise
+//====================================================================================
+func NewRadioLink(radioRange float64, position Position) *RadioLink {
+	return &RadioLink{
+		position:   position,
+		radioRange: radioRange,
+		random:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// TBradioRange - the default radio range, 3D or 2D as configured
+func TBradioRange() float64 {
+	if THREE_DIMENSIONAL {
+		return RANGE_3D
+	}
+	return RANGE_2D
+}
+
+//====================================================================================
+// SetLoss - edgeLoss of the packets from the edge of our range get lost, fewer
+// from closer, with the square of the distance
+//====================================================================================
+func (r *RadioLink) SetLoss(edgeLoss float64) {
+	r.mutex.Lock()
+	r.edgeLoss = math.Max(0, math.Min(1, edgeLoss))
+	r.mutex.Unlock()
+}
+
+// SetLatency - packets from the edge of our range arrive edgeDelay late, closer ones less
+func (r *RadioLink) SetLatency(edgeDelay time.Duration) {
+	r.mutex.Lock()
+	r.edgeDelay = edgeDelay
+	r.mutex.Unlock()
+}
+
+// SetPosition - we moved
+func (r *RadioLink) SetPosition(position Position) {
+	r.mutex.Lock()
+	r.position = position
+	r.mutex.Unlock()
+}
+
+func (r *RadioLink) Position() Position {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.position
+}
+
+func (r *RadioLink) Range() float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.radioRange
+}
+
+// SetGroundRange - what the ground station makes of where we are now, see Look.GroundRange
+func (r *RadioLink) SetGroundRange(groundRange int) {
+	r.mutex.Lock()
+	r.groundRange = groundRange
+	r.mutex.Unlock()
+}
+
+// Stamp - put where we are, and if we see the ground, into msgHeader, before
+// sending or relaying it
+func (r *RadioLink) Stamp(msgHeader *MessageHeader) {
+	r.mutex.Lock()
+	msgHeader.GroundRange = r.groundRange
+	msgHeader.SrcX, msgHeader.SrcY, msgHeader.SrcZ = r.position.X, r.position.Y, r.position.Z
+	msgHeader.Positioned = true
+	msgHeader.SrcECEF = r.position.ECEF
+	r.mutex.Unlock()
+}
+
+//====================================================================================
+// Distance - km from us to where msgHeader was sent from, -1 if it does not say
+//====================================================================================
+func (r *RadioLink) Distance(msgHeader *MessageHeader) float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.distance(msgHeader)
+}
+
+func (r *RadioLink) distance(msgHeader *MessageHeader) float64 {
+	if !msgHeader.Positioned {
+		return -1
+	}
+	return TBdistance(r.position,
+		Position{X: msgHeader.SrcX, Y: msgHeader.SrcY, Z: msgHeader.SrcZ, ECEF: msgHeader.SrcECEF})
+}
+
+//====================================================================================
+// TBdistance - km between a and b. In the playing field 2D unless THREE_DIMENSIONAL,
+// with a node in orbit always 3D, as ECEF
+//====================================================================================
+func TBdistance(a, b Position) float64 {
+	if a.ECEF || b.ECEF {
+		a, b = TBecef(a), TBecef(b)
+	} else if !THREE_DIMENSIONAL {
+		a.Z, b.Z = 0, 0
+	}
+	dx, dy, dz := b.X-a.X, b.Y-a.Y, b.Z-a.Z
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+//====================================================================================
+// Receive - did our radio hear the packet whose header is msgHeader, and how late
+//====================================================================================
+func (r *RadioLink) Receive(msgHeader *MessageHeader) (time.Duration, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	distance := r.distance(msgHeader)
+	if r.radioRange <= 0 || distance < 0 {
+		r.heard++
+		return 0, true
+	}
+	if distance > r.radioRange {
+		r.outOfRange++
+		return 0, false
+	}
+	share := distance / r.radioRange
+	if r.edgeLoss > 0 && r.random.Float64() < r.edgeLoss*share*share {
+		r.lost++
+		return 0, false
+	}
+	r.heard++
+	return time.Duration(float64(r.edgeDelay) * share), true
+}
+
+// Stats - packets heard, dropped as out of range, and lost in range
+func (r *RadioLink) Stats() (heard, outOfRange, lost int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.heard, r.outOfRange, r.lost
+}
@@ -0,0 +1,182 @@
+//=============================================================================
+// FILE NAME: tbRegistry.go
+// DESCRIPTION:
+// Registry of the terminals an M3 knows about, one TerminalInfo per terminal
+// id, for up to maxTerminals of them, whatever their ids. Safe to use from the main loop, the timer
+// and the console at the same time.
+// Terminals are added by Register the first time they are heard of and
+// removed when they go quiet; listeners registered with Subscribe are told
+// about both, after the registry is unlocked, so they may use the registry
+// themselves. Lookups return copies, change a terminal in place with Update.
+//================================================================================
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const REGISTRY_ADDED = "ADDED"
+const REGISTRY_REMOVED = "REMOVED"
+
+var ErrRegistryFull = errors.New("terminal registry full")
+
+type RegistryEvent struct {
+	Kind     string // REGISTRY_ADDED or REGISTRY_REMOVED
+	Terminal TerminalInfo
+}
+
+type RegistryListener func(event RegistryEvent)
+
+type TerminalRegistry struct {
+	mutex        sync.RWMutex
+	maxTerminals int
+	terminals    map[int]*TerminalInfo // by TerminalId
+	listeners    []RegistryListener
+}
+
+// NewTerminalRegistry - room for maxTerminals, MAX_NODES if 0 or less
+func NewTerminalRegistry(maxTerminals int) *TerminalRegistry {
+	if maxTerminals <= 0 {
+		maxTerminals = MAX_NODES
+	}
+	return &TerminalRegistry{
+		maxTerminals: maxTerminals,
+		terminals:    make(map[int]*TerminalInfo),
+	}
+}
+
+// Subscribe - listener is called for every terminal added or removed from now on
+func (r *TerminalRegistry) Subscribe(listener RegistryListener) {
+	r.mutex.Lock()
+	r.listeners = append(r.listeners, listener)
+	r.mutex.Unlock()
+}
+
+//====================================================================================
+// Register - update terminal id with fn, adding it first if we did not know it.
+// Returns true if it was added
+//====================================================================================
+func (r *TerminalRegistry) Register(id int, fn func(terminal *TerminalInfo)) (bool, error) {
+	if id < 1 {
+		return false, fmt.Errorf("terminal id %d not valid", id)
+	}
+	r.mutex.Lock()
+	terminal, ok := r.terminals[id]
+	if !ok {
+		if len(r.terminals) >= r.maxTerminals {
+			r.mutex.Unlock()
+			return false, ErrRegistryFull
+		}
+		terminal = &TerminalInfo{TerminalId: id}
+		r.terminals[id] = terminal
+	}
+	fn(terminal)
+	terminal.TerminalId = id // fn has no say in that
+	event := RegistryEvent{Kind: REGISTRY_ADDED, Terminal: *terminal}
+	listeners := r.listeners
+	r.mutex.Unlock()
+
+	if !ok {
+		r.notify(listeners, event)
+	}
+	return !ok, nil
+}
+
+// Update - change terminal id with fn, false if we do not know it
+func (r *TerminalRegistry) Update(id int, fn func(terminal *TerminalInfo)) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	terminal, ok := r.terminals[id]
+	if ok {
+		fn(terminal)
+		terminal.TerminalId = id
+	}
+	return ok
+}
+
+// Remove terminal id, false if we did not know it
+func (r *TerminalRegistry) Remove(id int) bool {
+	r.mutex.Lock()
+	terminal, ok := r.terminals[id]
+	if !ok {
+		r.mutex.Unlock()
+		return false
+	}
+	delete(r.terminals, id)
+	event := RegistryEvent{Kind: REGISTRY_REMOVED, Terminal: *terminal}
+	listeners := r.listeners
+	r.mutex.Unlock()
+
+	r.notify(listeners, event)
+	return true
+}
+
+func (r *TerminalRegistry) notify(listeners []RegistryListener, event RegistryEvent) {
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
+
+// Get - terminal by id
+func (r *TerminalRegistry) Get(id int) (TerminalInfo, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	terminal, ok := r.terminals[id]
+	if !ok {
+		return TerminalInfo{}, false
+	}
+	return *terminal, true
+}
+
+// FindByIP - terminal with TerminalIP ip
+func (r *TerminalRegistry) FindByIP(ip string) (TerminalInfo, bool) {
+	return r.find(func(terminal *TerminalInfo) bool { return terminal.TerminalIP == ip })
+}
+
+// FindByMAC - terminal with TerminalMac mac
+func (r *TerminalRegistry) FindByMAC(mac string) (TerminalInfo, bool) {
+	return r.find(func(terminal *TerminalInfo) bool { return terminal.TerminalMac == mac })
+}
+
+// FindByName - terminal with TerminalName name
+func (r *TerminalRegistry) FindByName(name string) (TerminalInfo, bool) {
+	return r.find(func(terminal *TerminalInfo) bool { return terminal.TerminalName == name })
+}
+
+// find - lowest id terminal match is true for
+func (r *TerminalRegistry) find(match func(terminal *TerminalInfo) bool) (TerminalInfo, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	var found *TerminalInfo
+	for _, terminal := range r.terminals {
+		if match(terminal) && (found == nil || terminal.TerminalId < found.TerminalId) {
+			found = terminal
+		}
+	}
+	if found == nil {
+		return TerminalInfo{}, false
+	}
+	return *found, true
+}
+
+// Snapshot - copy of all terminals, by id
+func (r *TerminalRegistry) Snapshot() []TerminalInfo {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	terminals := make([]TerminalInfo, 0, len(r.terminals))
+	for _, terminal := range r.terminals {
+		terminals = append(terminals, *terminal)
+	}
+	sort.Slice(terminals, func(i, j int) bool { return terminals[i].TerminalId < terminals[j].TerminalId })
+	return terminals
+}
+
+// Len - number of terminals
+func (r *TerminalRegistry) Len() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.terminals)
+}
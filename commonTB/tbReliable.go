@@ -0,0 +1,219 @@
+//=============================================================================
+// FILE NAME: tbReliable.go
+// DESCRIPTION:
+// Optional reliable unicast on top of the fire-and-forget control plane.
+// ReliableSender.Send sets AckReq in the header and keeps retransmitting the
+// message, backing off each time, until the peer sends back an ACK carrying
+// our SrcSeq, or MaxRetries is reached and OnFailure is called.
+// On the receiving side the terminal calls Ack for every message with AckReq
+// set it heard from the sender itself, routes or floods the ACK for relayed
+// ones (see Node.ack), and registers HandleAck with its Dispatcher for
+// MSG_TYPE_ACK.
+// Typical use:
+//   M3.Connectivity.Reliable = common.NewReliableSender(&M3.Connectivity, self, onFailure)
+//   Dispatcher.Register(common.MSG_TYPE_ACK,
+//       func() interface{} { return new(common.MsgCodeAck) }, M3.Connectivity.Reliable.HandleAck)
+//   M3.Connectivity.Reliable.Send(&myMsg.MsgHeader, &myMsg, address)
+//================================================================================
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrReliableStopped = errors.New("reliable sender stopped")
+
+//====================================================================================
+// DeliveryFailure - called when a message was never acknowledged. Runs on the
+// retransmit timer's goroutine, not on the terminal's main loop
+//====================================================================================
+type DeliveryFailure func(msgHeader MessageHeader, address string)
+
+// pendingMessage - what it takes to resend a message is fixed when it is first sent,
+// the retransmit timer does not look at the connectivity the main loop changes
+type pendingMessage struct {
+	msgHeader MessageHeader
+	fragments [][]byte // the message, in MTU sized fragments
+	transport Transport
+	address   string
+	retries   int
+	backoff   time.Duration
+	timer     *time.Timer
+}
+
+type ReliableSender struct {
+	RetryTimeout time.Duration // first retransmit, doubled after each one
+	MaxBackoff   time.Duration // retransmit interval never grows beyond this
+	MaxRetries   int           // retransmits before OnFailure
+	OnFailure    DeliveryFailure
+
+	connectivity *ConnectivityInfo
+	self         MessageHeader // Src* fields put into our ACKs
+	mutex        sync.Mutex
+	pending      map[int]*pendingMessage // by SrcSeq
+	stopped      bool
+}
+
+//====================================================================================
+// NewReliableSender - self provides SrcName, SrcId, SrcIP, SrcPort and SrcMAC
+// for the ACKs we send
+//====================================================================================
+func NewReliableSender(connectivity *ConnectivityInfo, self MessageHeader, onFailure DeliveryFailure) *ReliableSender {
+	return &ReliableSender{
+		RetryTimeout: RELIABLE_RETRY_TIMEOUT * time.Millisecond,
+		MaxBackoff:   RELIABLE_MAX_BACKOFF * time.Millisecond,
+		MaxRetries:   RELIABLE_MAX_RETRIES,
+		OnFailure:    onFailure,
+		connectivity: connectivity,
+		self:         self,
+		pending:      make(map[int]*pendingMessage),
+	}
+}
+
+//====================================================================================
+// Send msg, whose header msgHeader points to, to address and keep resending it
+// until acknowledged. msgHeader.SrcSeq must be unique among our pending messages
+//====================================================================================
+func (r *ReliableSender) Send(msgHeader *MessageHeader, msg interface{}, address string) error {
+	msgHeader.AckReq = true
+	msgOut, err := TBencode(r.connectivity.WireCodec, msg)
+	if err != nil {
+		return err
+	}
+	fragments, err := TBfragment(msgOut, r.connectivity.MTU)
+	if err != nil {
+		return err
+	}
+	pending := &pendingMessage{
+		msgHeader: *msgHeader,
+		fragments: fragments,
+		transport: r.connectivity.Transport,
+		address:   address,
+		backoff:   r.RetryTimeout,
+	}
+	r.mutex.Lock()
+	if r.stopped {
+		r.mutex.Unlock()
+		return ErrReliableStopped
+	}
+	seq := msgHeader.SrcSeq
+	if old, ok := r.pending[seq]; ok {
+		old.timer.Stop() // same seq reused, the old one is gone anyway
+	}
+	r.pending[seq] = pending
+	pending.timer = time.AfterFunc(pending.backoff, func() { r.retransmit(seq, pending) })
+	r.mutex.Unlock()
+
+	pending.send()
+	return nil
+}
+
+func (r *ReliableSender) retransmit(seq int, pending *pendingMessage) {
+	r.mutex.Lock()
+	if r.stopped || r.pending[seq] != pending {
+		r.mutex.Unlock()
+		return // acknowledged meanwhile
+	}
+	if pending.retries >= r.MaxRetries {
+		delete(r.pending, seq)
+		r.mutex.Unlock()
+		fmt.Println("RELIABLE: NO ACK for", pending.msgHeader.MsgCode, " seq=", seq,
+			" to", pending.address, " after", pending.retries, "retries")
+		if r.OnFailure != nil {
+			r.OnFailure(pending.msgHeader, pending.address)
+		}
+		return
+	}
+	pending.retries++
+	pending.backoff *= 2
+	if pending.backoff > r.MaxBackoff {
+		pending.backoff = r.MaxBackoff
+	}
+	pending.timer = time.AfterFunc(pending.backoff, func() { r.retransmit(seq, pending) })
+	r.mutex.Unlock()
+
+	pending.send()
+}
+
+// send - the message, once more
+func (pending *pendingMessage) send() {
+	for _, fragment := range pending.fragments {
+		if err := pending.transport.UnicastSend(fragment, pending.address); err != nil {
+			fmt.Println("ERROR UNICAST Sending Out to", pending.address, " Err=", err)
+			return
+		}
+	}
+}
+
+//====================================================================================
+// HandleAck - MessageHandler for MSG_TYPE_ACK
+//====================================================================================
+func (r *ReliableSender) HandleAck(packet InboundPacket, msgHeader *MessageHeader, msg interface{}) {
+	ack := msg.(*MsgCodeAck)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	pending, ok := r.pending[ack.MsgAck.AckSeq]
+	if !ok {
+		return // late ACK for a retransmitted message
+	}
+	if pending.msgHeader.DstId != 0 && pending.msgHeader.DstId != msgHeader.SrcId {
+		fmt.Println("RELIABLE: ACK seq=", ack.MsgAck.AckSeq, " from SrcID=", msgHeader.SrcId,
+			" but sent to DstID=", pending.msgHeader.DstId)
+		return
+	}
+	pending.timer.Stop()
+	delete(r.pending, ack.MsgAck.AckSeq)
+}
+
+//====================================================================================
+// Ack - acknowledge msgHeader, received in packet, to where it really came from.
+// Only for messages from a neighbor, the ACK goes no further
+//====================================================================================
+func (r *ReliableSender) Ack(packet InboundPacket, msgHeader *MessageHeader) {
+	ackHeader := r.self
+	ackHeader.MsgCode = MSG_TYPE_ACK
+	ackHeader.Ttl = 1
+	ackHeader.TimeSent = float64(TBtimestampNano())
+	ackHeader.SrcSeq = 0 // ACKs are not acknowledged, nor retransmitted
+	ackHeader.DstName = msgHeader.SrcName
+	ackHeader.DstId = msgHeader.SrcId
+	ackHeader.DstIP = msgHeader.SrcIP
+	ackHeader.DstPort = msgHeader.SrcPort
+	if r.connectivity.Radio != nil {
+		r.connectivity.Radio.Stamp(&ackHeader)
+	}
+
+	myMsg := MsgCodeAck{
+		MsgHeader: ackHeader,
+		MsgAck:    AckMsgBody{AckSeq: msgHeader.SrcSeq},
+	}
+	msgOut, err := TBencode(r.connectivity.WireCodec, myMsg)
+	if err != nil {
+		fmt.Println("RELIABLE: ERROR encoding ACK err=", err)
+		return
+	}
+	ControlPlaneUnicastSend(*r.connectivity, msgOut, packet.ReplyAddress(msgHeader))
+}
+
+// Pending - number of messages still waiting for an ACK
+func (r *ReliableSender) Pending() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.pending)
+}
+
+//====================================================================================
+// Stop retransmitting, pending messages are dropped without calling OnFailure
+//====================================================================================
+func (r *ReliableSender) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stopped = true
+	for seq, pending := range r.pending {
+		pending.timer.Stop()
+		delete(r.pending, seq)
+	}
+}
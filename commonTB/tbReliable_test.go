@@ -0,0 +1,110 @@
+package common
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// helloRole - a node that does nothing but tell its neighbors it is there
+type helloRole struct{}
+
+func (helloRole) Start(_ *Node) error                                                       { return nil }
+func (helloRole) Tick(node *Node, _ time.Time)                                              { node.SendDiscovery("") }
+func (helloRole) Discovery(_ *Node, _ InboundPacket, _ *MessageHeader, _ *DiscoveryMsgBody) {}
+func (helloRole) Command(_ *Node, _ []string) bool                                          { return false }
+func (helloRole) Status(_ *Node)                                                            {}
+func (helloRole) Stop(_ *Node)                                                              {}
+
+// nodesInLine - a node at each x, ids 1 up, saying hello and nothing more
+func nodesInLine(t *testing.T, xs ...float64) ([]*Node, []chan []string) {
+	t.Helper()
+	network := NewLoopbackNetwork()
+	var nodes []*Node
+	var consoles []chan []string
+	for i, x := range xs {
+		connectivity := loopbackConnectivity(network, fmt.Sprint("10.0.0.", i+1))
+		node, console := nodeAt(t, i+1, x, &connectivity, helloRole{})
+		nodes = append(nodes, node)
+		consoles = append(consoles, console)
+	}
+	return nodes, consoles
+}
+
+// nodeAt - node id, at 10.0.0.id, playing role at x, its radio reaching 50 km, so
+// nodes in line only hear the ones next to them if they are 40 km apart
+func nodeAt(t *testing.T, id int, x float64, connectivity *ConnectivityInfo, role Role) (*Node, chan []string) {
+	t.Helper()
+	config := testNodeConfig(id, fmt.Sprint("10.0.0.", id), testTickInterval)
+	config.RadioRange = 50
+	config.Position = Position{X: x, Y: 50}
+	return startNode(t, config, connectivity, role)
+}
+
+//====================================================================================
+// A reliable message two hops away, 1 -> 2 -> 3, is acknowledged along the same
+// route back, and so is the DRONE_MOVE_ACK 3 answers a DRONE_MOVE with
+//====================================================================================
+func TestReliableMultiHop(t *testing.T) {
+	nodes, consoles := nodesInLine(t, 10, 50, 90)
+	eventually(t, 5*time.Second, "a route from 1 to 3 through 2", func() bool {
+		route, ok := nodes[0].Connectivity.Routes.Lookup(3)
+		return ok && route.NextHop == 2
+	})
+
+	consoles[0] <- []string{"move", "3", "5", "0"}
+	eventually(t, 2*time.Second, "3 moving", func() bool { return nodes[2].Mobility.Position().X != 90 })
+	eventually(t, time.Second, "the DRONE_MOVE acknowledged", func() bool {
+		return nodes[0].Connectivity.Reliable.Pending() == 0
+	})
+	eventually(t, time.Second, "the DRONE_MOVE_ACK acknowledged", func() bool {
+		return nodes[2].Connectivity.Reliable.Pending() == 0
+	})
+}
+
+// countingTransport - a Transport that counts what it unicasts, and sends nothing
+type countingTransport struct {
+	Transport
+	sent int32
+}
+
+func (c *countingTransport) UnicastSend(_ []byte, _ string) error {
+	atomic.AddInt32(&c.sent, 1)
+	return nil
+}
+
+//====================================================================================
+// A message nobody acknowledges is resent MaxRetries times on the transport it was
+// first sent on, whatever the main loop does to the connectivity meanwhile
+//====================================================================================
+func TestReliableRetransmit(t *testing.T) {
+	first, second := &countingTransport{}, &countingTransport{}
+	connectivity := ConnectivityInfo{Transport: first}
+	failed := make(chan MessageHeader, 1)
+	reliable := NewReliableSender(&connectivity, MessageHeader{SrcId: 1}, func(msgHeader MessageHeader, _ string) {
+		failed <- msgHeader
+	})
+	reliable.RetryTimeout, reliable.MaxBackoff, reliable.MaxRetries = time.Millisecond, 4*time.Millisecond, 3
+	defer reliable.Stop()
+
+	msgHeader := MessageHeader{MsgCode: MSG_TYPE_DRONE_MOVE, SrcId: 1, SrcSeq: 7, DstId: 2}
+	if err := reliable.Send(&msgHeader, &MsgCodeMove{MsgHeader: msgHeader}, "10.0.0.2:4000"); err != nil {
+		t.Fatal(err)
+	}
+	connectivity.Transport, connectivity.MTU = second, 100
+	select {
+	case msgHeader := <-failed:
+		if msgHeader.SrcSeq != 7 {
+			t.Errorf("failed SrcSeq %d, want 7", msgHeader.SrcSeq)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no failure after 3 retries")
+	}
+	if sent := atomic.LoadInt32(&first.sent); sent != 4 {
+		t.Errorf("%d sent on the first transport, want 4", sent)
+	}
+	if sent := atomic.LoadInt32(&second.sent); sent != 0 {
+		t.Errorf("%d sent on the second transport, want 0", sent)
+	}
+}
@@ -0,0 +1,249 @@
+//=============================================================================
+// FILE NAME: tbRouting.go
+// DESCRIPTION:
+// Distance-vector routing between the mesh nodes, RIP style.
+// Every node we hear directly (Hops 0) is a neighbor, one hop away. Every
+// ROUTE_ADVERTISE_INTERVAL msec, or sooner when something changed, each node
+// broadcasts a ROUTES message listing its routes; neighbors add one hop and
+// keep whichever route to a node is shortest.
+// Routes are advertised with their next hop, and a node ignores routes that
+// go through itself (split horizon with poisoned reverse), so two nodes never
+// count to infinity through each other.
+// A route not heard of for ROUTE_TIMEOUT msec is poisoned, Metric set to
+// ROUTE_INFINITY, and so is every route through a neighbor we lost; poisoned
+// routes are advertised for another ROUTE_TIMEOUT, so the others drop them
+// too, then deleted.
+// ControlPlaneRouteSend sends to a node id along its route, see Node.SendTo, and
+// ControlPlaneRouteForward passes on unicast messages addressed to others.
+//================================================================================
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrNoRoute = errors.New("no route to node")
+
+type Route struct {
+	DstId   int
+	NextHop int    // neighbor the route goes through, DstId itself for neighbors
+	Address string // IP:Port of NextHop
+	Metric  int    // hops, ROUTE_INFINITY = unreachable
+	Updated time.Time
+}
+
+type RoutingTable struct {
+	Interval time.Duration // between advertisements
+	Timeout  time.Duration // routes not heard of for this long are poisoned
+
+	selfId         int
+	mutex          sync.Mutex
+	routes         map[int]*Route // by DstId
+	changed        bool           // advertise without waiting for Interval
+	lastAdvertised time.Time
+}
+
+func NewRoutingTable(selfId int) *RoutingTable {
+	return &RoutingTable{
+		Interval: ROUTE_ADVERTISE_INTERVAL * time.Millisecond,
+		Timeout:  ROUTE_TIMEOUT * time.Millisecond,
+		selfId:   selfId,
+		routes:   make(map[int]*Route),
+	}
+}
+
+//====================================================================================
+// HeardFrom - we received a message directly from neighbor id at address
+//====================================================================================
+func (t *RoutingTable) HeardFrom(id int, address string) {
+	if id == t.selfId {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	route, ok := t.routes[id]
+	if !ok || route.NextHop != id || route.Metric != 1 || route.Address != address {
+		fmt.Println("ROUTE: NEIGHBOR", id, "at", address)
+		route = &Route{DstId: id, NextHop: id, Address: address, Metric: 1}
+		t.routes[id] = route
+		t.changed = true
+	}
+	route.Updated = time.Now()
+}
+
+//====================================================================================
+// Update - merge the routes advertised by neighbor fromId, reachable at address.
+// Returns true if any of our routes changed
+//====================================================================================
+func (t *RoutingTable) Update(fromId int, address string, routes []RouteEntry) bool {
+	now := time.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	changed := false
+	for _, entry := range routes {
+		if entry.DstId == t.selfId || entry.DstId == fromId {
+			continue
+		}
+		metric := entry.Metric + 1
+		if entry.NextHop == t.selfId || metric > ROUTE_INFINITY {
+			metric = ROUTE_INFINITY // poisoned reverse, or gone
+		}
+		route, ok := t.routes[entry.DstId]
+		switch {
+		case !ok:
+			if metric == ROUTE_INFINITY {
+				continue
+			}
+			t.routes[entry.DstId] = &Route{DstId: entry.DstId, NextHop: fromId, Address: address,
+				Metric: metric, Updated: now}
+			changed = true
+		case route.NextHop == fromId:
+			// our route goes through fromId, so take whatever it says now
+			if route.Metric != metric {
+				route.Metric = metric
+				route.Updated = now
+				changed = true
+			} else if metric < ROUTE_INFINITY {
+				route.Updated = now
+			}
+			route.Address = address
+		case metric < route.Metric:
+			*route = Route{DstId: entry.DstId, NextHop: fromId, Address: address, Metric: metric, Updated: now}
+			changed = true
+		}
+	}
+	if changed {
+		t.changed = true
+	}
+	return changed
+}
+
+//====================================================================================
+// Expire - poison routes not heard of for Timeout, and every route through a
+// neighbor we lost, delete routes poisoned for Timeout. Returns the lost neighbors
+//====================================================================================
+func (t *RoutingTable) Expire(now time.Time) []int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var lost []int
+	for id, route := range t.routes {
+		if now.Sub(route.Updated) <= t.Timeout {
+			continue
+		}
+		if route.Metric == ROUTE_INFINITY {
+			delete(t.routes, id)
+			continue
+		}
+		if route.NextHop == route.DstId {
+			lost = append(lost, id)
+		}
+		route.Metric = ROUTE_INFINITY
+		route.Updated = now
+		t.changed = true
+	}
+	for _, neighbor := range lost {
+		fmt.Println("ROUTE: LOST NEIGHBOR", neighbor)
+		for _, route := range t.routes {
+			if route.NextHop == neighbor && route.Metric < ROUTE_INFINITY {
+				route.Metric = ROUTE_INFINITY
+				route.Updated = now
+			}
+		}
+	}
+	return lost
+}
+
+// AdvertiseDue - time to send our routes, the interval is up or they changed
+func (t *RoutingTable) AdvertiseDue(now time.Time) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.changed || now.Sub(t.lastAdvertised) >= t.Interval
+}
+
+//====================================================================================
+// Advertise - the routes to put in our next ROUTES message, poisoned ones included
+//====================================================================================
+func (t *RoutingTable) Advertise() []RouteEntry {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.changed = false
+	t.lastAdvertised = time.Now()
+	entries := make([]RouteEntry, 0, len(t.routes))
+	for _, route := range t.sorted() {
+		entries = append(entries, RouteEntry{DstId: route.DstId, NextHop: route.NextHop, Metric: route.Metric})
+	}
+	return entries
+}
+
+// Lookup - route to dstId, false if there is none or it is poisoned
+func (t *RoutingTable) Lookup(dstId int) (Route, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	route, ok := t.routes[dstId]
+	if !ok || route.Metric >= ROUTE_INFINITY {
+		return Route{}, false
+	}
+	return *route, true
+}
+
+// Routes - copy of the table, by DstId
+func (t *RoutingTable) Routes() []Route {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	routes := make([]Route, 0, len(t.routes))
+	for _, route := range t.sorted() {
+		routes = append(routes, *route)
+	}
+	return routes
+}
+
+func (t *RoutingTable) sorted() []*Route {
+	routes := make([]*Route, 0, len(t.routes))
+	for _, route := range t.routes {
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].DstId < routes[j].DstId })
+	return routes
+}
+
+//====================================================================================
+// ControlPlaneRouteSend - unicast msgOut to node dstId, through the next hop of
+// our route to it
+//====================================================================================
+func ControlPlaneRouteSend(connectivity ConnectivityInfo, msgOut []byte, dstId int) error {
+	if connectivity.Routes == nil {
+		return ErrNoRoute
+	}
+	route, ok := connectivity.Routes.Lookup(dstId)
+	if !ok {
+		return fmt.Errorf("%w %d", ErrNoRoute, dstId)
+	}
+	ControlPlaneUnicastSend(connectivity, msgOut, route.Address)
+	return nil
+}
+
+//====================================================================================
+// ControlPlaneRouteForward - pass a unicast message addressed to another node on
+// along our route to it. Returns false if it is not ours to forward: not unicast,
+// no route, or Ttl used up; flooding may still get it there
+//====================================================================================
+func ControlPlaneRouteForward(connectivity *ConnectivityInfo, packet InboundPacket, msgHeader *MessageHeader) bool {
+	if packet.Socket != SOCKET_UNICAST || msgHeader.DstId == 0 || msgHeader.Ttl <= 1 || connectivity.Routes == nil {
+		return false
+	}
+	route, ok := connectivity.Routes.Lookup(msgHeader.DstId)
+	if !ok || route.NextHop == msgHeader.SrcId {
+		return false
+	}
+	msgOut, err := relayCopy(connectivity, packet.Payload, msgHeader)
+	if err != nil {
+		fmt.Println("ROUTE: ERROR forwarding", msgHeader.MsgCode, " to DstID=", msgHeader.DstId, " err=", err)
+		return false
+	}
+	ControlPlaneUnicastSend(*connectivity, msgOut, route.Address)
+	return true
+}
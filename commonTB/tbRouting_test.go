@@ -0,0 +1,122 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type advertisement struct {
+	fromId int
+	routes []RouteEntry
+}
+
+//====================================================================================
+// What node 1 makes of its neighbors' advertisements: the route it keeps to dstId,
+// metric 0 if none
+//====================================================================================
+func TestRoutingTableUpdate(t *testing.T) {
+	tests := []struct {
+		name        string
+		heard       []int
+		ads         []advertisement
+		dstId       int
+		wantNextHop int
+		wantMetric  int
+	}{
+		{"neighbor", []int{2}, nil, 2, 2, 1},
+		{"through a neighbor", []int{2}, []advertisement{{2, []RouteEntry{{3, 3, 1}}}}, 3, 2, 2},
+		{"shorter one wins", []int{2, 3}, []advertisement{
+			{2, []RouteEntry{{4, 5, 3}}},
+			{3, []RouteEntry{{4, 4, 1}}}}, 4, 3, 2},
+		{"longer one ignored", []int{2, 3}, []advertisement{
+			{2, []RouteEntry{{4, 4, 1}}},
+			{3, []RouteEntry{{4, 5, 3}}}}, 4, 2, 2},
+		{"longer one through the same neighbor taken", []int{2}, []advertisement{
+			{2, []RouteEntry{{4, 4, 1}}},
+			{2, []RouteEntry{{4, 5, 3}}}}, 4, 2, 4},
+		{"poisoned reverse", []int{2}, []advertisement{{2, []RouteEntry{{3, 1, 1}}}}, 3, 0, 0},
+		{"poisoned reverse, ours through it", []int{2}, []advertisement{
+			{2, []RouteEntry{{3, 3, 1}}},
+			{2, []RouteEntry{{3, 1, 2}}}}, 3, 0, 0},
+		{"poisoned reverse, another one kept", []int{2, 4}, []advertisement{
+			{4, []RouteEntry{{3, 3, 1}}},
+			{2, []RouteEntry{{3, 1, 2}}}}, 3, 4, 2},
+		{"unreachable", []int{2}, []advertisement{
+			{2, []RouteEntry{{3, 3, 1}}},
+			{2, []RouteEntry{{3, 3, ROUTE_INFINITY}}}}, 3, 0, 0},
+		{"too far", []int{2}, []advertisement{{2, []RouteEntry{{3, 5, ROUTE_INFINITY - 1}}}}, 3, 0, 0},
+		{"us", []int{2}, []advertisement{{2, []RouteEntry{{1, 1, 1}}}}, 1, 0, 0},
+		{"the neighbor itself", []int{2}, []advertisement{{2, []RouteEntry{{2, 4, 2}}}}, 2, 2, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table := NewRoutingTable(1)
+			for _, id := range test.heard {
+				table.HeardFrom(id, "10.0.0.x:4000")
+			}
+			for _, ad := range test.ads {
+				table.Update(ad.fromId, "10.0.0.x:4000", ad.routes)
+			}
+			route, ok := table.Lookup(test.dstId)
+			if !ok {
+				route = Route{}
+			}
+			if route.NextHop != test.wantNextHop || route.Metric != test.wantMetric {
+				t.Errorf("route to %d through %d metric %d, want through %d metric %d", test.dstId, route.NextHop,
+					route.Metric, test.wantNextHop, test.wantMetric)
+			}
+		})
+	}
+}
+
+//====================================================================================
+// Routes expire: node 1 hears 2 and 5, and of 3 through 2, then of 6 through 5 and
+// from 2 again; 5 goes silent first, then 2. Metric of each route after Expire at
+// ms from the start, deleted ones left out
+//====================================================================================
+func TestRoutingTableExpire(t *testing.T) {
+	table := NewRoutingTable(1)
+	table.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	table.HeardFrom(2, "10.0.0.2:4000")
+	table.Update(2, "10.0.0.2:4000", []RouteEntry{{3, 3, 1}})
+	table.HeardFrom(5, "10.0.0.5:4000")
+	time.Sleep(50 * time.Millisecond)
+	table.HeardFrom(2, "10.0.0.2:4000")
+	table.Update(2, "10.0.0.2:4000", []RouteEntry{{3, 3, 1}})
+	table.Update(5, "10.0.0.5:4000", []RouteEntry{{6, 6, 1}})
+
+	tests := []struct {
+		name     string
+		at       time.Duration
+		wantLost []int
+		want     map[int]int
+	}{
+		{"all there", 80, nil, map[int]int{2: 1, 3: 2, 5: 1, 6: 2}},
+		{"5 lost, and 6 through it", 120, []int{5},
+			map[int]int{2: 1, 3: 2, 5: ROUTE_INFINITY, 6: ROUTE_INFINITY}},
+		{"2 lost, and 3 through it", 210, []int{2},
+			map[int]int{2: ROUTE_INFINITY, 3: ROUTE_INFINITY, 5: ROUTE_INFINITY, 6: ROUTE_INFINITY}},
+		{"5 and 6 deleted", 260, nil, map[int]int{2: ROUTE_INFINITY, 3: ROUTE_INFINITY}},
+		{"all deleted", 320, nil, map[int]int{}},
+	}
+	for _, test := range tests {
+		lost := table.Expire(start.Add(test.at * time.Millisecond))
+		if !reflect.DeepEqual(lost, test.wantLost) {
+			t.Errorf("%s: lost %v, want %v", test.name, lost, test.wantLost)
+		}
+		got := make(map[int]int)
+		for _, route := range table.Routes() {
+			got[route.DstId] = route.Metric
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: routes %v, want %v", test.name, got, test.want)
+		}
+		for dstId, metric := range test.want {
+			if _, ok := table.Lookup(dstId); ok != (metric < ROUTE_INFINITY) {
+				t.Errorf("%s: Lookup %d = %v with metric %d", test.name, dstId, ok, metric)
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+//go:build windows
+// +build windows
+
+//=============================================================================
+// FILE NAME: tbSockOptWindows.go
+// DESCRIPTION: socket options the net package does not expose (windows)
+//================================================================================
+package common
+
+import (
+	"net"
+	"syscall"
+)
+
+//====================================================================================
+// Set how many router hops our multicast packets may travel
+//====================================================================================
+func setMulticastTTL(connection *net.UDPConn, ttl int) error {
+	rawConnection, err := connection.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = rawConnection.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, ttl)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -2,12 +2,13 @@ package common
 
 import (
 	"net"
+	"sync"
 	"time"
 )
 
 //======================================================================
-// M3 terminal has one of these for each of the 4 M2 terminals
-// and one of these for the M1 terminal
+// M3 terminal has one of these for each M1 and M2 terminal it knows,
+// kept in its TerminalRegistry
 //======================================================================
 // For synapse this should be removed one of these days ...
 type TerminalInfo struct {
@@ -23,7 +24,8 @@ type TerminalInfo struct {
 	TerminalMac               string
 	TerminalPort              string
 	TerminalIPandPort         string
-	TerminalState             string
+	TerminalState             string // of its session with us: DOWN, CONNECTING or CONNECTED
+	TerminalSessionId         int
 	//----------------------------
 	TerminalNextMsgSeq    int
 	TerminalMsgsSent      int64
@@ -43,6 +45,7 @@ type TerminalInfo struct {
 	TerminalConnectionTimer  int
 	TerminalReceiveCount     int64
 	TerminalSendCount        int64
+	TerminalPosition         Position // km, as its last DISCOVERY said
 }
 
 type ConnectivityInfo struct {
@@ -64,46 +67,110 @@ type ConnectivityInfo struct {
 	BroadcastFullName   NameId
 	BroadcastConnection net.PacketConn
 	BroadcastTxStruct   *net.UDPAddr
+	//----------
+	MulticastIP         string // group to join, e.g. "239.0.0.0", empty = no multicast
+	MulticastPort       string
+	MulticastAddress    string // IP:Port, set by ControlPlaneInit from the two above
+	MulticastInterface  string // interface to join on, empty = system default
+	MulticastTTL        int    // router hops for our multicast packets, 0 = system default
+	MulticastConnection net.PacketConn
+	//----------
+	WireCodec       string          // WIRE_CODEC_JSON (default) or WIRE_CODEC_BINARY, for what we send
+	MaxDatagramSize int             // largest packet we can receive, 0 = MAX_DATAGRAM_SIZE
+	MTU             int             // largest packet we send, longer ones are fragmented, 0 = MAX_DATAGRAM_SIZE
+	Transport       Transport       // link used by the control plane, UDP unless set before ControlPlaneInit
+	Reliable        *ReliableSender // acknowledged unicast, nil until the terminal creates one
+	Reassembly      *Reassembler    // fragments waiting for the rest of their message
+	Flood           *Flooder        // relays messages for others, nil = we do not relay
+	Routes          *RoutingTable   // next hops to the other nodes, nil = no routing
+	Ground          *GroundService  // how we reach the ground station, nil = we do not
+	Matrix          *NeighborMatrix // who hears whom in the mesh, nil = we do not track it
+	Radio           *RadioLink      // where we are and who we hear, nil = we hear all the network delivers
+	receivers       *sync.WaitGroup
 }
 
 type MyChannels struct {
 	ControlChannel          chan []byte
 	CmdChannel              chan []string
-	UnicastRcvCtrlChannel   chan []byte
-	BroadcastRcvCtrlChannel chan []byte
-	MulticastRcvCtrlChannel chan []byte
+	UnicastRcvCtrlChannel   chan InboundPacket
+	BroadcastRcvCtrlChannel chan InboundPacket
+	MulticastRcvCtrlChannel chan InboundPacket
 }
 
-// m2 terminal own info
-type M2Info struct {
-	M2TerminalState     			string
-	M2TerminalActive    			bool
+// Socket names in InboundPacket.Socket
+const SOCKET_UNICAST = "UNICAST"
+const SOCKET_BROADCAST = "BROADCAST"
+const SOCKET_MULTICAST = "MULTICAST"
+
+//======================================================================
+// InboundPacket - what the receive threads hand to the main loop.
+// Source is the address the transport saw the packet come from, unlike
+// SrcIP/SrcPort in the MessageHeader which are whatever the sender wrote
+//======================================================================
+type InboundPacket struct {
+	Payload    []byte
+	Source     net.Addr  // real sender, as returned by ReadFrom
+	ReceivedAt time.Time // when the receive thread got it
+	Socket     string    // SOCKET_UNICAST, SOCKET_BROADCAST or SOCKET_MULTICAST
+}
 
+//======================================================================
+// PlatformInfo - where a terminal is, how it moves, what its radio reaches
+// and where the ground station is, as the config file says. The same for
+// M2 and M3, see NodeConfig
+//======================================================================
+type PlatformInfo struct {
+	DistanceToGround    float64 // km, -1 = not known
+	GroundRadioRange    float64 // km
+	PositionX           float64 // km, where we start
+	PositionY           float64
+	PositionZ           float64
+	RadioRange          float64 // km we hear other nodes from, 0 = no RF emulation
+	RadioLoss           float64 // share of packets lost from RadioRange away
+	RadioLatency        int64   // msec added to packets from RadioRange away
+	MobilityModel       string  // "static", "linear", "waypoint", "scripted" or "orbit"
+	Velocity            float64 // km/hour, 0 = DEFAULT_VELOCITY
+	Heading             float64 // degrees clockwise from the Y axis, "linear" only
+	MobilityScript      string  // waypoints file, "scripted" only
+	TimeScale           float64 // simulated seconds per real second, 0 = DEFAULT_VELOCITY_SCALE
+	OrbitAltitude       float64 // km, of the semi-major axis, "orbit" only
+	OrbitEccentricity   float64 // 0 = circular
+	OrbitInclination    float64 // degrees
+	OrbitRAAN           float64 // degrees, right ascension of the ascending node
+	OrbitArgPerigee     float64 // degrees
+	OrbitPhase          float64 // degrees, mean anomaly when we start
+	GroundPositionKnown bool    // the ground station is at GroundLatitude, ...
+	GroundLatitude      float64 // degrees
+	GroundLongitude     float64 // degrees
+	GroundAltitude      float64 // km
+	GroundMinElevation  float64 // degrees, the ground station does not see us lower than this
+}
+
+// m2 terminal own info, what the config file says; state, sequence numbers and
+// counters are in its common.Node
+type M2Info struct {
 	M2TerminalName      			string
-	M2TerminalFullName  			NameId
 	M2TerminalId        			int
 
 	M2Connectivity        			ConnectivityInfo
-	M2Channels            			MyChannels
 	//-----------------------
 	M2TerminalConnectionTimer  		int64
 	M2TerminalReceiveCount     		int64
 	M2TerminalSendCount        		int64
 	M2TerminalMsgLastSentAt  		float64
 
-	M2TerminalTimeCreated      		time.Time
-	M2TerminalLastChangeTime   		float64
 	M2TerminalHelloTimerLength		int64
 	M2TerminalLastHelloSendTime  	int64
 	M2TerminalLastHelloReceiveTime 	int64
 
 	M3TerminalIP					string
 	M3TerminalPort					string
+	M3TerminalId					int // the M3 we have a session with, 0 = none
+	M3HelloInterval					int64 // msec between its hellos, as its DISCOVERY says, 0 until it does
+	M2SessionId						int
 
 	M2TerminalIP        			string
 	M2TerminalPort      			string
-	M2TerminalIPandPort 			string
-	M2TerminalMac       			string
 
 	M2BroadcastTxPort				string
 	M2BroadcastTxIP					string
@@ -114,44 +181,50 @@ type M2Info struct {
 	M2UnicastRxIP					string //       "239.0.0.0"
 	M2UnicastTxPort					string
 
-	M2TerminalNextMsgSeq     		int
-	M2TerminalMsgsSent       		int64
-	M2TerminalMsgsRcvd       		int64
+	M2MulticastIP					string // group to join, empty = broadcast only
+	M2MulticastPort					string
+	M2MulticastInterface			string
+	M2MulticastTTL					int
 
-	M2TerminalUdpAddrStructure *net.UDPAddr
+	M2WireCodec						string // "json" or "binary", what we send
+	M2MaxDatagramSize				int
+	M2MTU							int
+
+	PlatformInfo					`mapstructure:",squash"` // radio, mobility, orbit and ground station
 
 	M2TerminalLogPath string
 }
 
 // m3Info ======================================================
 // The following structure is host's m3 owned structure
-// TerminalInfo (above) is one per each m1 and m2
+// TerminalInfo (above) is one per each m1 and m2. State, sequence numbers
+// and counters are in its common.Node
 //===============================================================
 type M3Info struct {
-	M3TerminalState     string
-	M3TerminalActive    bool
-	Terminal            [5]TerminalInfo // 0=M1, 1,2,3,4=M2
-	M3TerminalName      string
-	M3TerminalId        int
-	M3TerminalIP        string
-	M3TerminalPort      string
-	M3TerminalIPandPort string
-	M3TerminalMac       string
-	M3TerminalFullName  NameId
-	Connectivity        ConnectivityInfo
-	Channels            MyChannels
+	Terminals          *TerminalRegistry // the M1 and M2s we know, by id
+	Liveness           *LivenessMonitor  // UP, SUSPECT or DOWN, for each of the Terminals
+	M3TerminalName     string
+	M3TerminalId       int
+	M3TerminalIP       string
+	M3TerminalPort     string
+	Connectivity       ConnectivityInfo
+	MulticastIP        string // group to join, empty = broadcast only
+	MulticastPort      string
+	MulticastInterface string
+	MulticastTTL       int
+	WireCodec          string // "json" or "binary", what we send
+	MaxDatagramSize    int
+	MTU                int
+	PlatformInfo       `mapstructure:",squash"` // radio, mobility, orbit and ground station
 	//-----------------------
 	TerminalConnectionTimer  int64
 	TerminalReceiveCount     int64
 	TerminalSendCount        int64
 	M3TerminalMsgLastSentAt  float64
 	TerminalDiscoveryTimeout int
-	TerminalTimeCreated      time.Time
-	TerminalLastChangeTime   float64
-	TerminalHelloTimerLength int64
-	M3TerminalNextMsgSeq     int
-	M3TerminalMsgsSent       int64
-	M3TerminalMsgsRcvd       int64
+	TerminalHelloTimerLength int64 // milli sec between hellos to each terminal
+	TerminalDeadInterval     int64 // milli sec a terminal may be silent before it is DOWN
+	MaxSessions              int   // M2s we accept sessions from, 0 = as many as Terminals holds
 	//-----------------
 	GroundFullName   NameId
 	GroundIsKnown    bool
@@ -159,9 +232,6 @@ type M3Info struct {
 	GroundUdpPort    int
 	GroundIPandPort  string
 	GroundUdpAddrSTR *net.UDPAddr
-	//--------------------------------------
-	TerminalUdpAddrStructure *net.UDPAddr
-
 	//-------------------------------------
 	TerminalLogPath string
 }
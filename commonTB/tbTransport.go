@@ -0,0 +1,207 @@
+//=============================================================================
+// FILE NAME: tbTransport.go
+// DESCRIPTION:
+// Transport abstraction used by the control plane. ControlPlaneInit and the
+// ControlPlane send/receive functions only talk to a Transport, so the
+// underlying link (UDP sockets, in-memory, emulated RF, TCP ...) can be
+// replaced without touching the terminals.
+// UDPTransport below is the default and keeps the original reuseport sockets.
+//================================================================================
+package common
+
+import (
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-reuseport"
+	"net"
+)
+
+var errMulticastNotJoined = errors.New("multicast group not joined")
+
+//====================================================================================
+// Transport - what the control plane needs from a link
+// Addresses are "IP:Port" strings, as used everywhere in ConnectivityInfo
+//====================================================================================
+type Transport interface {
+	// Open creates the unicast and broadcast endpoints described by connectivity,
+	// and joins the multicast group if connectivity.MulticastAddress is set
+	Open(connectivity *ConnectivityInfo) error
+	// UnicastSend sends pkt to one node at address
+	UnicastSend(pkt []byte, address string) error
+	// BroadcastSend sends pkt to everybody listening on address
+	BroadcastSend(pkt []byte, address string) error
+	// UnicastReceive blocks until a unicast packet is received
+	UnicastReceive(buffer []byte) (int, net.Addr, error)
+	// BroadcastReceive blocks until a broadcast packet is received
+	BroadcastReceive(buffer []byte) (int, net.Addr, error)
+	// MulticastSend sends pkt to the group at address
+	MulticastSend(pkt []byte, address string) error
+	// MulticastReceive blocks until a packet for our group is received
+	MulticastReceive(buffer []byte) (int, net.Addr, error)
+	// Close releases the endpoints, blocked receivers return an error
+	Close() error
+}
+
+//====================================================================================
+// UDPTransport - default transport, one reuseport socket for unicast, one
+// for broadcast and, if configured, one joined to the multicast group
+//====================================================================================
+type UDPTransport struct {
+	unicastConnection   net.PacketConn
+	broadcastConnection net.PacketConn
+	multicastConnection *net.UDPConn
+}
+
+func NewUDPTransport() *UDPTransport {
+	return &UDPTransport{}
+}
+
+//====================================================================================
+// Open the sockets, and record them in connectivity for anybody still looking.
+// If one of them fails, the ones opened before it are closed again
+//====================================================================================
+func (t *UDPTransport) Open(connectivity *ConnectivityInfo) error {
+	err := t.open(connectivity)
+	if err != nil {
+		_ = t.Close()
+		*t = UDPTransport{}
+		connectivity.UnicastConnection = nil
+		connectivity.BroadcastConnection = nil
+		connectivity.MulticastConnection = nil
+	}
+	return err
+}
+
+func (t *UDPTransport) open(connectivity *ConnectivityInfo) error {
+	var err error
+	// UNICAST
+	//----------------------------------------------------------------------------------------------
+	connectivity.UnicastRxStruct, err = net.ResolveUDPAddr("udp", ":"+connectivity.UnicastRxPort)
+	if err != nil {
+		fmt.Println("1Init ControlPlane ERROR UnicastRxStruct err=", err)
+		return err
+	}
+	fmt.Println("1Init OK ControlPlane UnicastRxStruct", connectivity.UnicastRxStruct)
+	t.unicastConnection, err = reuseport.ListenPacket("udp", ":"+connectivity.UnicastRxPort)
+	if err != nil {
+		fmt.Println("2Init ControlPlane ERROR UnicastConnection err=", err)
+		return err
+	}
+	fmt.Println("3Init ControlPlane OK: UnicastConnection=", t.unicastConnection.LocalAddr())
+	connectivity.UnicastConnection = t.unicastConnection
+
+	//BROADCAST
+	//----------------------------------------------------------------------------------------------
+	connectivity.BroadcastTxStruct, err = net.ResolveUDPAddr("udp", connectivity.BroadcastTxAddress)
+	if err != nil {
+		fmt.Println("4Init ControlPlane ERROR BroadcastTxStruct err=", err)
+		return err
+	}
+	fmt.Println("5Init ControlPlane OK: BroadcastTxStruct=", connectivity.BroadcastTxStruct)
+	fmt.Println("6Init ControlPlane Broadcast socket listen on ", connectivity.BroadcastRxAddress)
+	t.broadcastConnection, err = reuseport.ListenPacket("udp", connectivity.BroadcastRxAddress)
+	if err != nil {
+		fmt.Println("7Init ControlPlane ERROR BroadcastConnection err=", err)
+		return err
+	}
+	fmt.Println("8Init ControlPlane OK: BroadcastConnection=", t.broadcastConnection.LocalAddr())
+	connectivity.BroadcastConnection = t.broadcastConnection
+
+	// MULTICAST
+	//----------------------------------------------------------------------------------------------
+	if connectivity.MulticastAddress == "" {
+		return nil
+	}
+	groupAddress, err := net.ResolveUDPAddr("udp4", connectivity.MulticastAddress)
+	if err != nil {
+		fmt.Println("9Init ControlPlane ERROR MulticastAddress err=", err)
+		return err
+	}
+	var multicastInterface *net.Interface // nil = let the system pick
+	if connectivity.MulticastInterface != "" {
+		multicastInterface, err = net.InterfaceByName(connectivity.MulticastInterface)
+		if err != nil {
+			fmt.Println("10Init ControlPlane ERROR MulticastInterface err=", err)
+			return err
+		}
+	}
+	t.multicastConnection, err = net.ListenMulticastUDP("udp4", multicastInterface, groupAddress)
+	if err != nil {
+		fmt.Println("11Init ControlPlane ERROR MulticastConnection err=", err)
+		return err
+	}
+	if connectivity.MulticastTTL > 0 {
+		err = setMulticastTTL(t.multicastConnection, connectivity.MulticastTTL)
+		if err != nil {
+			fmt.Println("12Init ControlPlane ERROR MulticastTTL err=", err)
+			return err
+		}
+	}
+	fmt.Println("13Init ControlPlane OK: joined multicast group", groupAddress,
+		"interface=", connectivity.MulticastInterface, "TTL=", connectivity.MulticastTTL)
+	connectivity.MulticastConnection = t.multicastConnection
+	return nil
+}
+
+func (t *UDPTransport) UnicastSend(pkt []byte, address string) error {
+	udpAddress, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return err
+	}
+	_, err = t.unicastConnection.WriteTo(pkt, udpAddress)
+	return err
+}
+
+func (t *UDPTransport) BroadcastSend(pkt []byte, address string) error {
+	udpAddress, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return err
+	}
+	_, err = t.broadcastConnection.WriteTo(pkt, udpAddress)
+	return err
+}
+
+func (t *UDPTransport) MulticastSend(pkt []byte, address string) error {
+	if t.multicastConnection == nil {
+		return errMulticastNotJoined
+	}
+	udpAddress, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return err
+	}
+	_, err = t.multicastConnection.WriteTo(pkt, udpAddress)
+	return err
+}
+
+func (t *UDPTransport) UnicastReceive(buffer []byte) (int, net.Addr, error) {
+	return t.unicastConnection.ReadFrom(buffer)
+}
+
+func (t *UDPTransport) BroadcastReceive(buffer []byte) (int, net.Addr, error) {
+	return t.broadcastConnection.ReadFrom(buffer)
+}
+
+func (t *UDPTransport) MulticastReceive(buffer []byte) (int, net.Addr, error) {
+	if t.multicastConnection == nil {
+		return 0, nil, errMulticastNotJoined
+	}
+	return t.multicastConnection.ReadFrom(buffer)
+}
+
+func (t *UDPTransport) Close() error {
+	var err error
+	if t.unicastConnection != nil {
+		err = t.unicastConnection.Close()
+	}
+	if t.broadcastConnection != nil {
+		if err2 := t.broadcastConnection.Close(); err == nil {
+			err = err2
+		}
+	}
+	if t.multicastConnection != nil {
+		if err2 := t.multicastConnection.Close(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
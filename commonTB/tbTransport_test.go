@@ -0,0 +1,66 @@
+package common
+
+import (
+	"net"
+	"testing"
+)
+
+// freePort - a UDP port nobody is listening on, as far as we can tell
+func freePort(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+	return port
+}
+
+//====================================================================================
+// UDPTransport.Open failing half way leaves no socket open: the unicast port can
+// be taken without reuseport after it
+//====================================================================================
+func TestUDPTransportOpenFails(t *testing.T) {
+	taken, err := net.ListenPacket("udp", ":"+freePort(t)) // no reuseport, nobody can share it
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	tests := []struct {
+		name               string
+		broadcastRxAddress string
+		multicastAddress   string
+		multicastInterface string
+	}{
+		{"broadcast port taken", taken.LocalAddr().String(), "", ""},
+		{"multicast group not an address", ":" + freePort(t), "no such group", ""},
+		{"multicast interface missing", ":" + freePort(t), "239.1.2.3:4002", "no such interface"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			unicastPort := freePort(t)
+			connectivity := ConnectivityInfo{
+				UnicastRxPort:      unicastPort,
+				BroadcastTxAddress: "255.255.255.255:4001",
+				BroadcastRxAddress: test.broadcastRxAddress,
+				MulticastAddress:   test.multicastAddress,
+				MulticastInterface: test.multicastInterface,
+			}
+			transport := NewUDPTransport()
+			if err := transport.Open(&connectivity); err == nil {
+				_ = transport.Close()
+				t.Fatal("Open did not fail")
+			}
+			if connectivity.UnicastConnection != nil || connectivity.BroadcastConnection != nil {
+				t.Errorf("sockets left in connectivity after Open failed")
+			}
+			conn, err := net.ListenPacket("udp", ":"+unicastPort)
+			if err != nil {
+				t.Fatalf("unicast port still open after Open failed: %v", err)
+			}
+			_ = conn.Close()
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"os/exec"
 )
 
 func isUnicast(mac []byte) bool {
@@ -188,6 +189,24 @@ func formatReceiver(name string, osId int, udpAddress net.UDPAddr) NameId {
 	return receiver
 }
 
+// Commands from local terminal, or rcvd from ground controller
+const LOCAL_CMD = "LOCAL"
+const REMOTE_CMD = "REMOTE"
+
+//====================================================================================
+// TBrunLinuxCommand - run Cmd, for origin LOCAL_CMD or REMOTE_CMD, and wait for it
+//====================================================================================
+func TBrunLinuxCommand(origin, Cmd, Par1, Par2, Par3, Par4, Par5, Par6 string) error {
+	//cmd.Output() → run it, wait, get output
+	//cmd.Run() → run it, wait for it to finish.
+	//cmd.Start() → run it, don't wait. err = cmd.Wait() to get result.
+	var thisCmd = exec.Command(Cmd, Par1, Par2, Par3, Par4, Par5, Par6)
+	output, err := thisCmd.Output()
+	fmt.Println(origin, " CMD= ", Cmd, " ", Par1, Par2, " ", Par3, " ", Par4,
+		" ", Par5, " ", Par6, " :  RESULT:", string(output), "  ERR:", err)
+	return err
+}
+
 /*
 //====================================================================================
 // Save the pointer to my own row for faster handling
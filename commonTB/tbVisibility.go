@@ -0,0 +1,145 @@
+//=============================================================================
+// FILE NAME: tbVisibility.go
+// DESCRIPTION:
+// Can the ground station see a node, and when will it. Look gives the slant
+// range, elevation and azimuth of a node as seen from the ground station, and
+// whether it is above the station's minimum elevation mask. For nodes in orbit
+// the Earth is a sphere and positions are ECEF; the playing field is small
+// enough to be taken as flat, so a drone at the station's altitude is at 0
+// degrees elevation. ContactWindows predicts when a node in orbit is going to
+// be in sight of the station, rise to set.
+// Whether the ground is in sight goes into MessageHeader.GroundRange of every
+// packet we transmit, see RadioLink.
+// Typical use:
+//   station := common.GroundStation{Latitude: 40.7, Longitude: -74, MinElevation: 10}
+//   look := station.Look(Node.Mobility.Position())
+//   windows := station.ContactWindows(orbit, Node.Mobility.SimTime(), 24*time.Hour, 0)
+//================================================================================
+package common
+
+import (
+	"math"
+	"time"
+)
+
+// In MessageHeader.GroundRange, otherwise km to the ground station, at least 1
+const GROUND_RANGE_UNKNOWN = 0 // the sender does not know where the ground station is
+const GROUND_NOT_VISIBLE = -1  // the ground station is below the sender's horizon, or its mask
+
+const CONTACT_STEP = 30    // sec, simulated, between looks while predicting contact windows
+const CONTACT_HORIZON = 24 // hours, simulated, contact windows are predicted for
+
+type GroundStation struct {
+	Latitude     float64 // degrees
+	Longitude    float64 // degrees
+	Altitude     float64 // km
+	MinElevation float64 // degrees, nodes lower than this are not in sight
+}
+
+// Look - a node as the ground station sees it
+type Look struct {
+	Range     float64 // km, slant range
+	Elevation float64 // degrees above the horizon
+	Azimuth   float64 // degrees clockwise from north
+	Visible   bool    // at or above MinElevation
+}
+
+// GroundRange - what to put in MessageHeader.GroundRange
+func (l Look) GroundRange() int {
+	if !l.Visible {
+		return GROUND_NOT_VISIBLE
+	}
+	return int(math.Max(1, math.Ceil(l.Range)))
+}
+
+//====================================================================================
+// Look - where the node at position is, as seen from the ground station
+//====================================================================================
+func (g GroundStation) Look(position Position) Look {
+	var east, north, up float64
+	if position.ECEF {
+		station := TBecef(TBpositionFromLatLong(g.Latitude, g.Longitude, g.Altitude))
+		dx, dy, dz := position.X-station.X, position.Y-station.Y, position.Z-station.Z
+		latitude, longitude := g.Latitude*math.Pi/180, g.Longitude*math.Pi/180
+		east = -math.Sin(longitude)*dx + math.Cos(longitude)*dy
+		north = -math.Sin(latitude)*math.Cos(longitude)*dx - math.Sin(latitude)*math.Sin(longitude)*dy +
+			math.Cos(latitude)*dz
+		up = math.Cos(latitude)*math.Cos(longitude)*dx + math.Cos(latitude)*math.Sin(longitude)*dy +
+			math.Sin(latitude)*dz
+	} else {
+		station := TBpositionFromLatLong(g.Latitude, g.Longitude, g.Altitude)
+		east, north, up = position.X-station.X, position.Y-station.Y, position.Z-station.Z
+	}
+	look := Look{Range: math.Sqrt(east*east + north*north + up*up), Elevation: 90}
+	if look.Range > 0 {
+		look.Elevation = math.Asin(up/look.Range) * 180 / math.Pi
+		look.Azimuth = math.Mod(math.Atan2(east, north)*180/math.Pi+360, 360)
+	}
+	look.Visible = look.Elevation >= g.MinElevation
+	return look
+}
+
+type ContactWindow struct {
+	Rise         time.Duration // simulated, since the simulation started
+	Set          time.Duration
+	MaxElevation float64 // degrees, highest we looked at
+}
+
+//====================================================================================
+// ContactWindows - when a node in orbit is in sight of the ground station, from
+// simulated time from on, for horizon. We look every step, CONTACT_STEP if 0,
+// so shorter passes may be missed; rise and set are then found to the second.
+// A window open at from rises at from, one still open at the end sets there
+//====================================================================================
+func (g GroundStation) ContactWindows(orbit Orbit, from, horizon, step time.Duration) []ContactWindow {
+	if step <= 0 {
+		step = CONTACT_STEP * time.Second
+	}
+	var windows []ContactWindow
+	var window *ContactWindow
+	end := from + horizon
+	previous := from
+	for simTime := from; ; simTime += step {
+		if simTime > end {
+			simTime = end
+		}
+		look := g.Look(orbit.ECEF(simTime))
+		switch {
+		case look.Visible && window == nil:
+			rise := from
+			if simTime > from {
+				rise = g.edge(orbit, previous, simTime, false)
+			}
+			windows = append(windows, ContactWindow{Rise: rise})
+			window = &windows[len(windows)-1]
+		case !look.Visible && window != nil:
+			window.Set = g.edge(orbit, previous, simTime, true)
+			window = nil
+		}
+		if window != nil {
+			window.MaxElevation = math.Max(window.MaxElevation, look.Elevation)
+		}
+		if simTime >= end {
+			break
+		}
+		previous = simTime
+	}
+	if window != nil {
+		window.Set = end
+	}
+	return windows
+}
+
+// edge - when, between from and to, the node in orbit goes out of sight if
+// visible, comes in sight if not, to the second
+func (g GroundStation) edge(orbit Orbit, from, to time.Duration, visible bool) time.Duration {
+	for to-from > time.Second {
+		middle := from + (to-from)/2
+		if g.Look(orbit.ECEF(middle)).Visible == visible {
+			from = middle
+		} else {
+			to = middle
+		}
+	}
+	return to
+}
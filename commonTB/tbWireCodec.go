@@ -0,0 +1,390 @@
+//=============================================================================
+// FILE NAME: tbWireCodec.go
+// DESCRIPTION:
+// Compact binary encoding of the control plane messages, as an alternative to
+// the JSON produced by TBmarshal. Every binary message starts with
+//   WIRE_MAGIC, WIRE_VERSION
+// followed by the fields of the message struct. Each field that is not a zero
+// value is written as a varint key (field number << 3 | wire type) and its
+// value, field number being the position of the field in the struct (1 based):
+//   wire type 0 - varint: bool, ints (zigzag), uints
+//   wire type 1 - 8 bytes: float64
+//   wire type 2 - varint length + bytes: string, []byte, struct, slice, array
+//   wire type 5 - 4 bytes: float32
+// Unknown field numbers and fields whose wire type does not match are skipped,
+// so fields can be added at the end of any struct without breaking old nodes.
+// JSON messages always start with '{', so TBdecode can tell the two apart and
+// nodes accept both while the network is migrated; which one a node sends is
+// set by ConnectivityInfo.WireCodec.
+//================================================================================
+package common
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+const WIRE_MAGIC = 0xA5
+const WIRE_VERSION = 1
+
+// ConnectivityInfo.WireCodec values
+const WIRE_CODEC_JSON = "json"
+const WIRE_CODEC_BINARY = "binary"
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var ErrWireTruncated = errors.New("wire codec: message truncated")
+var ErrWireVersion = errors.New("wire codec: unsupported version")
+
+var binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+var binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+
+//====================================================================================
+// TBencode - encode key with codec, JSON unless codec is WIRE_CODEC_BINARY
+//====================================================================================
+func TBencode(codec string, key interface{}) ([]byte, error) {
+	if codec == WIRE_CODEC_BINARY {
+		return TBbinaryMarshal(key)
+	}
+	return TBmarshal(key)
+}
+
+//====================================================================================
+// TBdecode - decode a JSON or a binary message into key, whichever it is
+//====================================================================================
+func TBdecode(input []byte, key interface{}) error {
+	if TBisBinary(input) {
+		return TBbinaryUnmarshal(input, key)
+	}
+	return TBunmarshal(input, key)
+}
+
+// TBisBinary - true if input was produced by TBbinaryMarshal
+func TBisBinary(input []byte) bool {
+	return len(input) > 0 && input[0] == WIRE_MAGIC
+}
+
+//====================================================================================
+// TBbinaryMarshal - encode the struct (or pointer to struct) key
+//====================================================================================
+func TBbinaryMarshal(key interface{}) ([]byte, error) {
+	value := reflect.Indirect(reflect.ValueOf(key))
+	if value.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("wire codec: cannot encode %T, not a struct", key)
+	}
+	out := []byte{WIRE_MAGIC, WIRE_VERSION}
+	return appendStruct(out, value)
+}
+
+//====================================================================================
+// TBbinaryUnmarshal - decode input into the struct key points to
+//====================================================================================
+func TBbinaryUnmarshal(input []byte, key interface{}) error {
+	if len(input) < 2 || input[0] != WIRE_MAGIC {
+		return errors.New("wire codec: not a binary message")
+	}
+	if input[1] > WIRE_VERSION {
+		return fmt.Errorf("%w %d", ErrWireVersion, input[1])
+	}
+	value := reflect.ValueOf(key)
+	// accept **T as TBunmarshal does, allocating on the way
+	for value.Kind() == reflect.Ptr && value.Elem().Kind() == reflect.Ptr {
+		if value.Elem().IsNil() {
+			value.Elem().Set(reflect.New(value.Elem().Type().Elem()))
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("wire codec: cannot decode into %T", key)
+	}
+	return readStruct(input[2:], value.Elem())
+}
+
+//====================================================================================
+// Encoding
+//====================================================================================
+func wireType(t reflect.Type) (int, bool) {
+	if t.Implements(binaryMarshalerType) && reflect.PtrTo(t).Implements(binaryUnmarshalerType) {
+		return wireBytes, true
+	}
+	switch t.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return wireVarint, true
+	case reflect.Float64:
+		return wireFixed64, true
+	case reflect.Float32:
+		return wireFixed32, true
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Struct:
+		return wireBytes, true
+	case reflect.Ptr:
+		return wireType(t.Elem())
+	}
+	return 0, false // maps, interfaces, channels, funcs
+}
+
+func appendStruct(out []byte, value reflect.Value) ([]byte, error) {
+	var err error
+	structType := value.Type()
+	for i := 0; i < value.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" { // unexported, JSON does not send those either
+			continue
+		}
+		fieldValue := value.Field(i)
+		if fieldValue.IsZero() {
+			continue
+		}
+		wt, ok := wireType(field.Type)
+		if !ok {
+			return nil, fmt.Errorf("wire codec: %s.%s: unsupported type %s",
+				structType.Name(), field.Name, field.Type)
+		}
+		out = appendUvarint(out, uint64(i+1)<<3|uint64(wt))
+		out, err = appendValue(out, fieldValue)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// appendValue - value only, no key; length prefixed for wireBytes
+func appendValue(out []byte, value reflect.Value) ([]byte, error) {
+	var err error
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return appendUvarint(out, 0), nil // only reachable inside slices
+		}
+		value = value.Elem()
+	}
+	if value.Type().Implements(binaryMarshalerType) && reflect.PtrTo(value.Type()).Implements(binaryUnmarshalerType) {
+		data, err := value.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		out = appendUvarint(out, uint64(len(data)))
+		return append(out, data...), nil
+	}
+	switch value.Kind() {
+	case reflect.Bool:
+		if value.Bool() {
+			return append(out, 1), nil
+		}
+		return append(out, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendVarint(out, value.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return appendUvarint(out, value.Uint()), nil
+	case reflect.Float64:
+		return appendFixed64(out, math.Float64bits(value.Float())), nil
+	case reflect.Float32:
+		return appendFixed32(out, math.Float32bits(float32(value.Float()))), nil
+	case reflect.String:
+		out = appendUvarint(out, uint64(value.Len()))
+		return append(out, value.String()...), nil
+	case reflect.Struct:
+		body, err := appendStruct(nil, value)
+		if err != nil {
+			return nil, err
+		}
+		out = appendUvarint(out, uint64(len(body)))
+		return append(out, body...), nil
+	case reflect.Slice, reflect.Array:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			out = appendUvarint(out, uint64(value.Len()))
+			for i := 0; i < value.Len(); i++ {
+				out = append(out, byte(value.Index(i).Uint()))
+			}
+			return out, nil
+		}
+		// count, then each element as a value
+		body := appendUvarint(nil, uint64(value.Len()))
+		for i := 0; i < value.Len(); i++ {
+			body, err = appendValue(body, value.Index(i))
+			if err != nil {
+				return nil, err
+			}
+		}
+		out = appendUvarint(out, uint64(len(body)))
+		return append(out, body...), nil
+	}
+	return nil, fmt.Errorf("wire codec: unsupported type %s", value.Type())
+}
+
+// the encoding/binary Append functions need go 1.19
+func appendUvarint(out []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(out, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendVarint(out []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(out, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func appendFixed64(out []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(out, buf[:]...)
+}
+
+func appendFixed32(out []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(out, buf[:]...)
+}
+
+//====================================================================================
+// Decoding
+//====================================================================================
+func readStruct(input []byte, value reflect.Value) error {
+	structType := value.Type()
+	for len(input) > 0 {
+		key, n := binary.Uvarint(input)
+		if n <= 0 {
+			return ErrWireTruncated
+		}
+		input = input[n:]
+		number, wt := int(key>>3), int(key&7)
+		raw, rest, err := splitValue(input, wt)
+		if err != nil {
+			return err
+		}
+		input = rest
+		if number < 1 || number > value.NumField() {
+			continue // field from a newer version
+		}
+		field := structType.Field(number - 1)
+		if field.PkgPath != "" {
+			continue
+		}
+		if fieldType, ok := wireType(field.Type); !ok || fieldType != wt {
+			continue // field changed type, leave it zero
+		}
+		err = readValue(raw, value.Field(number-1))
+		if err != nil {
+			return fmt.Errorf("wire codec: %s.%s: %w", structType.Name(), field.Name, err)
+		}
+	}
+	return nil
+}
+
+// splitValue - cut one value of wire type wt off the front of input; for
+// wireBytes the returned value excludes the length prefix
+func splitValue(input []byte, wt int) ([]byte, []byte, error) {
+	switch wt {
+	case wireVarint:
+		_, n := binary.Uvarint(input)
+		if n <= 0 {
+			return nil, nil, ErrWireTruncated
+		}
+		return input[:n], input[n:], nil
+	case wireFixed64, wireFixed32:
+		size := 8
+		if wt == wireFixed32 {
+			size = 4
+		}
+		if len(input) < size {
+			return nil, nil, ErrWireTruncated
+		}
+		return input[:size], input[size:], nil
+	case wireBytes:
+		length, n := binary.Uvarint(input)
+		if n <= 0 || uint64(len(input)-n) < length {
+			return nil, nil, ErrWireTruncated
+		}
+		end := n + int(length)
+		return input[n:end], input[end:], nil
+	}
+	return nil, nil, fmt.Errorf("wire codec: unknown wire type %d", wt)
+}
+
+// readValue - raw is exactly one value as cut by splitValue
+func readValue(raw []byte, value reflect.Value) error {
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		value = value.Elem()
+	}
+	if reflect.PtrTo(value.Type()).Implements(binaryUnmarshalerType) && value.Type().Implements(binaryMarshalerType) {
+		return value.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(raw)
+	}
+	switch value.Kind() {
+	case reflect.Bool:
+		value.SetBool(raw[0] != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, _ := binary.Varint(raw)
+		value.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, _ := binary.Uvarint(raw)
+		value.SetUint(v)
+	case reflect.Float64:
+		value.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(raw)))
+	case reflect.Float32:
+		value.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(raw))))
+	case reflect.String:
+		value.SetString(string(raw))
+	case reflect.Struct:
+		return readStruct(raw, value)
+	case reflect.Slice, reflect.Array:
+		return readList(raw, value)
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+	return nil
+}
+
+func readList(raw []byte, value reflect.Value) error {
+	elemType := value.Type().Elem()
+	if elemType.Kind() == reflect.Uint8 {
+		if value.Kind() == reflect.Slice {
+			value.Set(reflect.MakeSlice(value.Type(), len(raw), len(raw)))
+		}
+		for i := 0; i < len(raw) && i < value.Len(); i++ {
+			value.Index(i).SetUint(uint64(raw[i]))
+		}
+		return nil
+	}
+	count, n := binary.Uvarint(raw)
+	if n <= 0 || count > uint64(len(raw)) { // every element takes at least one byte
+		return ErrWireTruncated
+	}
+	raw = raw[n:]
+	if value.Kind() == reflect.Slice {
+		value.Set(reflect.MakeSlice(value.Type(), int(count), int(count)))
+	}
+	wt, ok := wireType(elemType)
+	if !ok {
+		return fmt.Errorf("unsupported type %s", elemType)
+	}
+	for i := 0; i < int(count); i++ {
+		element, rest, err := splitValue(raw, wt)
+		if err != nil {
+			return err
+		}
+		raw = rest
+		if i >= value.Len() { // array shorter than what was sent
+			continue
+		}
+		if elemType.Kind() == reflect.Ptr && wt == wireBytes && len(element) == 0 {
+			continue // nil pointer
+		}
+		err = readValue(element, value.Index(i))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
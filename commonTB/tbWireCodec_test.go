@@ -0,0 +1,221 @@
+package common
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testHeader - a header with every field set, negative ones included
+func testHeader(msgCode string) MessageHeader {
+	return MessageHeader{MsgCode: msgCode, Ttl: 3, StepMode: 1, TimeSent: 1.5e12, SrcSeq: 1023, SrcRole: 2,
+		IamAlone: true, SrcMAC: "02:00:00:00:00:02", SrcName: "node2", SrcId: 2, SrcIP: "10.0.0.2",
+		SrcPort: "4000", DstName: "UNICAST", DstId: 64, DstIP: "10.0.0.64", DstPort: "4000",
+		GroundRange: GROUND_NOT_VISIBLE, Hash: 0xBEEF, AckReq: true, Hops: 2, SrcX: 10.25, SrcY: -0.5, SrcZ: 6371,
+		Positioned: true, SrcECEF: true}
+}
+
+//====================================================================================
+// Every message decodes to what was encoded, with either codec
+//====================================================================================
+func TestWireCodecRoundTrip(t *testing.T) {
+	var neighbors BitMask
+	for _, id := range []int{1, 3, 64, 130} {
+		neighbors.Set(id)
+	}
+	tests := []struct {
+		name   string
+		msg    interface{}
+		newMsg func() interface{}
+	}{
+		{"DISCOVERY", &MsgCodeDiscovery{MsgHeader: testHeader(MSG_TYPE_DISCOVERY), MsgDiscovery: DiscoveryMsgBody{
+			TimeCreated: 1.7e18, NodeActive: true, LastChangeTime: 1.7e18 + 1, MsgLastSentAt: 2.5, MsgLastRcvdAt: 3.5,
+			MsgsSent: 1 << 40, MsgsRcvd: 7, GroundDistance: GROUND_DISTANCE_UNKNOWN, GroundRange: 120.5,
+			Neighbors: neighbors, Position: Position{X: 12.5, Y: 99.75, Z: -1}, HelloInterval: 1050,
+			SessionId: 7}},
+			func() interface{} { return new(MsgCodeDiscovery) }},
+		{"DRONE_MOVE absolute", &MsgCodeMove{MsgHeader: testHeader(MSG_TYPE_DRONE_MOVE), MsgMove: MoveMsgBody{
+			Kind: MOVE_ABSOLUTE, Latitude: -33.8688, Longitude: 151.2093, Altitude: 0.25, Velocity: 80, ETA: 90}},
+			func() interface{} { return new(MsgCodeMove) }},
+		{"DRONE_MOVE relative", &MsgCodeMove{MsgHeader: testHeader(MSG_TYPE_DRONE_MOVE), MsgMove: MoveMsgBody{
+			Kind: MOVE_RELATIVE, DX: -5, DY: 2.5, DZ: 0.125}},
+			func() interface{} { return new(MsgCodeMove) }},
+		{"DRONE_MOVE_ACK", &MsgCodeMoveAck{MsgHeader: testHeader(MSG_TYPE_DRONE_MOVE_ACK), MsgMoveAck: MoveAckMsgBody{
+			MoveSeq: 12, Accepted: true, Arrived: true, Reason: "clipped to the playing field", Latitude: 45.5,
+			Longitude: -122.25, Altitude: 1, Position: Position{X: 6000, Y: -1500, Z: 900, ECEF: true},
+			Target: Position{X: 1, Y: 2, Z: 3}, ETA: 30.5}},
+			func() interface{} { return new(MsgCodeMoveAck) }},
+		{"ACK", &MsgCodeAck{MsgHeader: testHeader(MSG_TYPE_ACK), MsgAck: AckMsgBody{AckSeq: 1023}},
+			func() interface{} { return new(MsgCodeAck) }},
+		{"ROUTES", &MsgCodeRoutes{MsgHeader: testHeader(MSG_TYPE_ROUTES), MsgRoutes: RoutesMsgBody{Routes: []RouteEntry{
+			{DstId: 2, NextHop: 2, Metric: 1}, {DstId: 3, NextHop: 2, Metric: ROUTE_INFINITY}, {DstId: 64}}}},
+			func() interface{} { return new(MsgCodeRoutes) }},
+		{"CONNECTING", &MsgCodeSession{MsgHeader: testHeader(MSG_TYPE_CONNECTING), MsgSession: SessionMsgBody{
+			SessionId: 7, Accepted: true, Reason: "welcome"}},
+			func() interface{} { return new(MsgCodeSession) }},
+		{"DRONE_TERMINATE", &MsgCodeTerminate{MsgHeader: testHeader(MSG_TYPE_DRONE_TERMINATE),
+			MsgTerminate: TerminateMsgBody{When: 1.7e18}},
+			func() interface{} { return new(MsgCodeTerminate) }},
+		{"STEP", &MsgCodeStep{MsgHeader: testHeader(MSG_TYPE_STEP), MsgStep: StepMsgBody{Steps: -1}},
+			func() interface{} { return new(MsgCodeStep) }},
+		{"DRONE_STATUS_REPLY", &MsgCodeStatusReply{MsgHeader: testHeader(MSG_TYPE_STATUS_REPLY),
+			MsgStatusReply: StatusReplyMsgBody{TimeCreated: 1, NodeActive: true, LastChangeTime: 2, MsgLastSentAt: 3,
+				MsgLastRcvdAt: 4, MsgsSent: 5, MsgsRcvd: 6}},
+			func() interface{} { return new(MsgCodeStatusReply) }},
+		{"GROUNDINFO", &MsgCodeGroundInfo{MsgHeader: testHeader(MSG_TYPE_GROUND_INFO)},
+			func() interface{} { return new(MsgCodeGroundInfo) }},
+		{"empty", &MsgCodeAck{}, func() interface{} { return new(MsgCodeAck) }},
+	}
+	for _, codec := range []string{WIRE_CODEC_BINARY, WIRE_CODEC_JSON} {
+		for _, test := range tests {
+			t.Run(codec+" "+test.name, func(t *testing.T) {
+				msgOut, err := TBencode(codec, test.msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if TBisBinary(msgOut) != (codec == WIRE_CODEC_BINARY) {
+					t.Errorf("binary %v", TBisBinary(msgOut))
+				}
+				got := test.newMsg()
+				if err = TBdecode(msgOut, got); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, test.msg) {
+					t.Errorf("decoded %+v\nwant %+v", got, test.msg)
+				}
+				msgHeader, err := TBdecodeHeader(msgOut)
+				if err != nil || *msgHeader != *headerOf(test.msg) {
+					t.Errorf("header %+v, err %v", msgHeader, err)
+				}
+			})
+		}
+	}
+}
+
+// headerOf - the MsgHeader of msg, a pointer to a MsgCode struct
+func headerOf(msg interface{}) *MessageHeader {
+	return reflect.ValueOf(msg).Elem().FieldByName("MsgHeader").Addr().Interface().(*MessageHeader)
+}
+
+//====================================================================================
+// Input that is not a binary message, from a newer version, or cut short anywhere
+// but between the header and the body, does not decode
+//====================================================================================
+func TestWireCodecBadInput(t *testing.T) {
+	msg := MsgCodeDiscovery{MsgHeader: testHeader(MSG_TYPE_DISCOVERY),
+		MsgDiscovery: DiscoveryMsgBody{MsgsSent: 5, Position: Position{X: 1}, Neighbors: BitMask{Words: []uint64{6}}}}
+	msgOut, err := TBbinaryMarshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerOnly, _ := TBbinaryMarshal(MsgCodeDiscovery{MsgHeader: msg.MsgHeader})
+
+	tests := []struct {
+		name    string
+		input   []byte
+		wantErr error // nil: any error
+	}{
+		{"empty", nil, nil},
+		{"magic only", []byte{WIRE_MAGIC}, nil},
+		{"bad magic", append([]byte{WIRE_MAGIC + 1}, msgOut[1:]...), nil},
+		{"JSON", []byte(`{"MsgHeader":{}}`), nil},
+		{"newer version", append([]byte{WIRE_MAGIC, WIRE_VERSION + 1}, msgOut[2:]...), ErrWireVersion},
+		{"unknown wire type", []byte{WIRE_MAGIC, WIRE_VERSION, 1<<3 | 3, 0}, nil},
+		{"key cut", []byte{WIRE_MAGIC, WIRE_VERSION, 0x80}, ErrWireTruncated},
+		{"length past the end", []byte{WIRE_MAGIC, WIRE_VERSION, 1<<3 | wireBytes, 100, 0}, ErrWireTruncated},
+		{"float cut", []byte{WIRE_MAGIC, WIRE_VERSION, 1<<3 | wireBytes, 3, 4<<3 | wireFixed64, 0, 0},
+			ErrWireTruncated},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got MsgCodeDiscovery
+			err := TBbinaryUnmarshal(test.input, &got)
+			if err == nil || test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("err %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+	for n := 3; n < len(msgOut); n++ {
+		if n == len(headerOnly) {
+			continue // a DISCOVERY with an empty body
+		}
+		var got MsgCodeDiscovery
+		if err := TBbinaryUnmarshal(msgOut[:n], &got); !errors.Is(err, ErrWireTruncated) {
+			t.Errorf("cut at %d of %d: err %v, want %v", n, len(msgOut), err, ErrWireTruncated)
+		}
+	}
+}
+
+// newerAckBody - AckMsgBody as a later version may have it
+type newerAckBody struct {
+	AckSeq int
+	Reason string
+	Seqs   []int
+	Where  Position
+}
+
+// changedAckBody - AckMsgBody with its field changed to another type
+type changedAckBody struct {
+	AckSeq string
+}
+
+//====================================================================================
+// Fields a node does not know are skipped, and so are fields whose type changed;
+// the fields it knows still decode
+//====================================================================================
+func TestWireCodecUnknownFields(t *testing.T) {
+	header := testHeader(MSG_TYPE_ACK)
+	tests := []struct {
+		name string
+		msg  interface{}
+		want MsgCodeAck
+	}{
+		{"newer, more fields", struct {
+			MsgHeader MessageHeader
+			MsgAck    newerAckBody
+			Extra     string
+		}{header, newerAckBody{AckSeq: 9, Reason: "late", Seqs: []int{1, 2}, Where: Position{X: 1}}, "more"},
+			MsgCodeAck{MsgHeader: header, MsgAck: AckMsgBody{AckSeq: 9}}},
+		{"field changed type", struct {
+			MsgHeader MessageHeader
+			MsgAck    changedAckBody
+		}{header, changedAckBody{AckSeq: "nine"}},
+			MsgCodeAck{MsgHeader: header}},
+		{"body changed type", struct {
+			MsgHeader MessageHeader
+			MsgAck    int
+		}{header, 9},
+			MsgCodeAck{MsgHeader: header}},
+		{"older, no body", struct {
+			MsgHeader MessageHeader
+		}{header},
+			MsgCodeAck{MsgHeader: header}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msgOut, err := TBbinaryMarshal(test.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got MsgCodeAck
+			if err = TBbinaryUnmarshal(msgOut, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("decoded %+v\nwant %+v", got, test.want)
+			}
+		})
+	}
+
+	// and the other way round, a newer node decoding what we send
+	msgOut, _ := TBbinaryMarshal(MsgCodeAck{MsgHeader: header, MsgAck: AckMsgBody{AckSeq: 9}})
+	var newer struct {
+		MsgHeader MessageHeader
+		MsgAck    newerAckBody
+		Extra     string
+	}
+	if err := TBbinaryUnmarshal(msgOut, &newer); err != nil || newer.MsgAck.AckSeq != 9 || newer.MsgAck.Reason != "" ||
+		newer.Extra != "" {
+		t.Errorf("newer decoded %+v, err %v", newer, err)
+	}
+}
@@ -10,9 +10,9 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
-	"github.com/igismo/synapse/commonTB"
 	"os"
 	"strings"
 )
@@ -21,8 +21,9 @@ var ConsoleInput = make(chan []string)
 
 // StartConsole =========================================================
 // Early work to test some options for officeMaster console
+// Stops after forwarding quit/exit, or once ctx is cancelled
 //=======================================================================
-func StartConsole(consoleInput <-chan []string) {
+func StartConsole(ctx context.Context, consoleInput <-chan []string) {
 	// runtime.GOMAXPROCS(2)
 	go func(ch <-chan []string) {
 		// reader := bufio.NewReader(os.Stdin)
@@ -53,16 +54,18 @@ func StartConsole(consoleInput <-chan []string) {
 			//for i := 0; i < len; i++ {println("START SA[", i, "]=", sa[i])}
 			//commandText [] string = sa
 
-			ConsoleInput <- sa
+			select {
+			case ConsoleInput <- sa:
+			case <-ctx.Done():
+				return
+			}
 			switch sa[0] {
-			case "quit":
-				common.ControlPlaneCloseConnections(M2.M2Connectivity)
-				fmt.Printf("Exiting\n")
-				os.Exit(0)
-			case "exit":
-				common.ControlPlaneCloseConnections(M2.M2Connectivity)
+			case "quit", "exit":
+				// main loop closes the connections and drains what is in flight
 				fmt.Printf("Exiting\n")
-				os.Exit(0)
+				return
+			case "reach":
+				// reach <node> <hops>, answered by the main loop
 			case "help":
 				//fmt.Printf("No HELP available yet\n")
 				//M2.M2Channels.CmdChannel <- []byte(s)
@@ -16,16 +16,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/igismo/synapse/commonTB"
 	"github.com/spf13/viper"
 	"net"
 	"os"
-	"os/exec"
+	"os/signal"
 	//"reflect"
 	"runtime"
-	"strconv"
-	"strings"
+	"syscall"
 	"time"
 )
 
@@ -35,13 +35,7 @@ import (
 var M3 common.M3Info           // all info about the Node
 var Log = common.LogInstance{} // for log file storage
 
-const StateDown = "DOWN"
-const StateConnecting = "CONNECTING"
-const StateConnected = "CONNECTED"
-
-// REMOTE_CMD Command from local terminal, or rcvd from ground controller
-// const LOCAL_CMD = "LOCAL"
-const REMOTE_CMD = "REMOTE"
+var Node *common.Node // what every terminal does, M3 is its common.M3Role
 
 // InitM3Configuration InitDroneConfiguration ======================================
 // READ ARGUMENTS IF ANY
@@ -51,13 +45,11 @@ func InitM3Configuration() {
 	M3.M3TerminalName = "Node01" // will be overwritten by config
 	M3.M3TerminalId = 1          // will be overwritten by config
 	M3.M3TerminalIP = ""
-	M3.TerminalConnectionTimer = common.DRONE_KEEPALIVE_TIMER // 5 sec
-	M3.TerminalDiscoveryTimeout = 5000                        // milli sec
-
-	M3.Channels.CmdChannel = nil            // so that all local threads can talk back
-	M3.Channels.UnicastRcvCtrlChannel = nil // to send control msgs to Recv Thread
-	M3.Channels.BroadcastRcvCtrlChannel = nil
-	M3.Channels.MulticastRcvCtrlChannel = nil
+	M3.TerminalConnectionTimer = common.DRONE_KEEPALIVE_TIMER       // 5 sec
+	M3.TerminalDiscoveryTimeout = 2 * common.LIVENESS_DEAD_INTERVAL // milli sec, never before DOWN for a while
+	M3.TerminalHelloTimerLength = common.LIVENESS_HELLO_INTERVAL
+	M3.TerminalDeadInterval = common.LIVENESS_DEAD_INTERVAL
+	M3.Terminals = common.NewTerminalRegistry(common.MAX_NODES)
 
 	// M3.TerminalConnection = nil
 
@@ -67,11 +59,6 @@ func InitM3Configuration() {
 	Log.WarningLog = true
 	Log.ErrorLog = true
 
-	M3.Channels.UnicastRcvCtrlChannel = make(chan []byte) //
-	M3.Channels.BroadcastRcvCtrlChannel = make(chan []byte)
-	M3.Channels.MulticastRcvCtrlChannel = make(chan []byte)
-	M3.Channels.CmdChannel = make(chan []string) // receive command line cmnds
-
 	M3.Connectivity.BroadcastRxAddress = ":48999"
 	M3.Connectivity.BroadcastRxPort = "48999"
 	M3.Connectivity.BroadcastRxIP = ""
@@ -92,28 +79,17 @@ func InitM3Configuration() {
 	M3.GroundIP = ""
 	M3.M3TerminalPort = "8888"
 	M3.GroundIPandPort = "" //M3.GroundIP + ":" + M3.GroundUdpPort
-	M3.TerminalUdpAddrStructure = new(net.UDPAddr)
 	M3.GroundUdpAddrSTR = new(net.UDPAddr)
-	M3.TerminalTimeCreated = time.Now() // strconv.FormatInt(common.TBtimestampNano(), 10)
+
+	M3.PlatformInfo = common.DefaultPlatformInfo() // unless the config says where we are
 }
 
 // InitFromConfigFile ================================================================
 // InitFromConfigFile() - Set configuration from config file
 //====================================================================================
 func InitFromConfigFile() {
-	var fileName string
-	argNum := len(os.Args) // Number of arguments supplied, including the command
-	fmt.Println("Number of Arguments = ", argNum)
-	if argNum > 2 && os.Args[1] != "" && os.Args[1] != "0" {
-		M3.M3TerminalName = os.Args[1]
-		M3.M3TerminalId, _ = strconv.Atoi(os.Args[2])
-		fileName = "config" + os.Args[2] + ".yml"
-		viper.SetConfigName(fileName)
-	} else {
-		// Set the file name of the configurations file
-		fileName = "config.yml"
-		viper.SetConfigName(fileName)
-	}
+	fileName := common.NodeConfigFileName()
+	viper.SetConfigName(fileName)
 	// Set the path to look for the configurations file
 	viper.AddConfigPath(".")
 	// Enable VIPER to read Environment Variables
@@ -160,83 +136,47 @@ func InitFromConfigFile() {
 		M3.Connectivity.BroadcastTxIP + ":" + M3.Connectivity.BroadcastTxPort
 	fmt.Println("BroadcastTxAddress        = ", M3.Connectivity.BroadcastTxAddress)
 
+	M3.Connectivity.MulticastIP = M3.MulticastIP
+	M3.Connectivity.MulticastPort = M3.MulticastPort
+	M3.Connectivity.MulticastInterface = M3.MulticastInterface
+	M3.Connectivity.MulticastTTL = M3.MulticastTTL
+	M3.Connectivity.WireCodec = M3.WireCodec
+	M3.Connectivity.MaxDatagramSize = M3.MaxDatagramSize
+	M3.Connectivity.MTU = M3.MTU
+	fmt.Println("MulticastIP               = ", M3.Connectivity.MulticastIP)
+	fmt.Println("MulticastPort             = ", M3.Connectivity.MulticastPort)
+	fmt.Println("MulticastInterface        = ", M3.Connectivity.MulticastInterface)
+	fmt.Println("MulticastTTL              = ", M3.Connectivity.MulticastTTL)
+	fmt.Println("WireCodec                 = ", M3.Connectivity.WireCodec)
+	fmt.Println("MaxDatagramSize           = ", M3.Connectivity.MaxDatagramSize)
+	fmt.Println("MTU                       = ", M3.Connectivity.MTU)
+	M3.PlatformInfo.Print()
+	fmt.Println("TerminalHelloTimerLength  = ", M3.TerminalHelloTimerLength)
+	fmt.Println("TerminalDeadInterval      = ", M3.TerminalDeadInterval)
+	fmt.Println("MaxSessions               = ", M3.MaxSessions)
+
 	fmt.Println("GroundId                  = ", M3.GroundIP)
 	fmt.Println("GroundUdpPort             = ", M3.GroundUdpPort)
 	fmt.Println("GroundIPandPort           = ", M3.GroundIPandPort)
 }
 
-// InitFromCommandLine ====================================================================================
-// InitFromCommandLine()  READ ARGUMENTS IF ANY
-//====================================================================================
-func InitFromCommandLine() {
-	// argsWithProg := os.Args; argsWithoutProg := os.Args[1:]
-	// Second: check for the command line parametersz, they overwrite the config file
-	for index := range os.Args {
-		arg := os.Args[index]
-		fmt.Println("Arg", index, "=", arg)
-	}
-	argNum := len(os.Args) // Number of arguments supplied, including the command
-	fmt.Println("Number of Arguments = ", argNum)
-	if argNum > 2 && os.Args[1] != "" && os.Args[1] != "0" {
-		fmt.Println("M3 NAME = ", os.Args[1])
-		M3.M3TerminalName = os.Args[1]
-		M3.M3TerminalId, _ = strconv.Atoi(os.Args[2])
-	}
-	if argNum > 3 && os.Args[3] != "" && os.Args[3] != "0" {
-		fmt.Println("Satellite IP   = ", os.Args[3])
-		M3.M3TerminalIP = os.Args[3]
-		// TODO: Set eth0 IP address to M3.M3IpAddress !!!
-		var ifCmd = exec.Command("sudo", "ifconfig", "eth0",
-			M3.M3TerminalIP, "up", "", "")
-		output, err := ifCmd.Output()
-		fmt.Println("SET MY IP=", "sudo", "ifconfig", "eth0", M3.M3TerminalIP, "up",
-			" -OUTPUT:", string(output), " ERR:", err)
-	}
-	if argNum > 4 && os.Args[4] != "" && os.Args[4] != "0" {
-		M3.M3TerminalPort = os.Args[4] // strconv.ParseInt(os.Args[3], 10, 64)
-	}
-	if argNum > 5 && os.Args[5] != "" && os.Args[5] != "0" && argNum > 6 && os.Args[6] != "" && os.Args[6] != "0" {
-		M3.GroundIPandPort = os.Args[5] + ":" + os.Args[6]
-	}
-}
-
-// SetFinalM3Info ===============================================================
-//  Initialize my own info in the M3 structure
-//===============================================================================
-func SetFinalM3Info() {
-
-	if M3.M3TerminalIP == "" {
-		M3.M3TerminalIP, M3.M3TerminalMac = common.GetLocalIp() // get IP and MAC
-	}
-
-	// TODO memset(&distanceVector, 0, sizeof(distanceVector))
-	M3.TerminalLastChangeTime = float64(common.TBtimestampNano()) // tiM3Now().String()
-	changeState(StateDown)
-
-	var bit uint64 = 0x1
-	for j := 0; j < 64; j++ {
-		// TODO .. from prev coded, should be just = 0xffffffffffffffff
-		bit <<= 1
-	}
-
-	for m2Number := 0; m2Number < 4; m2Number++ {
-		M3.Terminal[m2Number].TerminalMsgLastRcvdAt = time.Now() // TBtimestampNano() //time.Now()
-	}
-}
-
-// InitM3Connectivity ================================================================
-//  Initialize IP and UDP addressing
+// M3NodeConfig =====================================================================
+// M3NodeConfig() - what the Node needs to know of us, from the config file and
+// then the command line
 //====================================================================================
-func InitM3Connectivity() {
-	M3.M3TerminalIPandPort = M3.M3TerminalIP + ":" + M3.M3TerminalPort
-	var err3 error
-	M3.TerminalUdpAddrStructure, err3 = net.ResolveUDPAddr("udp", M3.M3TerminalIPandPort)
-	if err3 != nil {
-		fmt.Println("ERROR ResolveUDPAddr: ", err3)
-	} else {
-		M3.M3TerminalFullName = common.NameId{Name: M3.M3TerminalName,
-			Address: *M3.TerminalUdpAddrStructure}
-	}
+func M3NodeConfig() common.NodeConfig {
+	config := common.NodeConfig{
+		Id:           M3.M3TerminalId,
+		Name:         M3.M3TerminalName,
+		IP:           M3.M3TerminalIP,
+		Port:         M3.M3TerminalPort,
+		TickInterval: 300 * time.Millisecond,
+	}
+	M3.PlatformInfo.ToNodeConfig(&config)
+	if ground := common.NodeCommandLine(&config); ground != "" {
+		M3.GroundIPandPort = ground
+	}
+	return config
 }
 
 //====================================================================================
@@ -249,34 +189,6 @@ func checkErrorNode(err error) {
 	}
 }
 
-//====================================================================================
-//
-//====================================================================================
-func periodicFunc(tick time.Time) {
-	// TODO - figure out reasonable timer period to process these two
-	// Note that we may have not receive messages from some terminals for some time
-	// So maybe wait until the end of processing period and figure out who we
-	// received discovery msgs from and based on that figure out the connectivity
-
-	fmt.Println("TICK: UPDATE CONNECTIVITY -----  ", tick)
-
-	currTimeMilliSec := common.TBtimestampMilli()
-
-	for i := 1; i < 5; i++ {
-		if M3.Terminal[i].TerminalActive == true {
-			elapsedTimeSinceLastSend := currTimeMilliSec - M3.Terminal[i].TerminalLastHelloSendTime
-			timeSinceLastHelloReceived := currTimeMilliSec - M3.Terminal[i].TerminalLastHelloReceiveTime
-
-			if timeSinceLastHelloReceived > M3.TerminalHelloTimerLength {
-				M3.Terminal[i].TerminalActive = false
-			} else if elapsedTimeSinceLastSend >= M3.TerminalHelloTimerLength {
-				M3.Terminal[i].TerminalLastHelloSendTime = currTimeMilliSec
-				// send hello
-			}
-		}
-	}
-}
-
 //===============================================================================
 // M3 == Satellite, really M3
 //===============================================================================
@@ -290,505 +202,36 @@ func main() {
 	// Then try to read config file
 	InitFromConfigFile()
 	// Finally overwrite if any command arguments given
-	InitFromCommandLine()
-
-	M3.Terminal[0].TerminalActive = true  // M1
-	M3.Terminal[1].TerminalActive = false // M2 1
-	M3.Terminal[2].TerminalActive = false // M2 2
-	M3.Terminal[3].TerminalActive = false // M2 3
-	M3.Terminal[4].TerminalActive = false // M2 4
+	role := common.NewM3Role(&M3)
+	role.Log = &Log
+	Node = common.NewNode(M3NodeConfig(), &M3.Connectivity, role)
+	// TODO memset(&distanceVector, 0, sizeof(distanceVector))
+	Node.ChangeState(common.SESSION_DOWN)
 
-	SetFinalM3Info()
 	// Create LOG file
-	common.CreateLog(&Log, M3.M3TerminalName, M3.TerminalLogPath)
+	common.CreateLog(&Log, Node.Name, M3.TerminalLogPath)
 	Log.Warning(&Log, "Warning test:this will be printed anyway")
 
-	InitM3Connectivity()
-
-	// Make this work one of these days ...
-	var err error
-	checkErrorNode(err)
-
-	common.ControlPlaneInit(&(M3.Connectivity), M3.Channels)
-
-	// START SEND AND RECEIVE THREADS:
-	err2 := common.ControlPlaneRecvThread(&M3.Connectivity, M3.Channels)
-	if err2 != nil {
-		fmt.Println(M3.M3TerminalName, "INIT: Error creating Broadcast/Unicast RX thread")
-		panic(err2)
-	}
-
-	// TODO: Make this work later
-	//if M3.GroundIsKnown == true {
-	//	fmt.Println(M3.Name, ":",M3.FullName, "INIT: GROUND LOCATED")
-	//	changeState(StateConnected)
-	//	M3.KeepAliveRcvdTime = time.Now()
-	//}
-
 	//================================================================================
-	// START TIMER : Call periodicFunc on every timerTick
+	// STOP on quit/exit from the console, or on SIGINT/SIGTERM
 	//================================================================================
-	tick := 300 * time.Millisecond
-	fmt.Println(M3.M3TerminalName, "MAIN: Starting a new Timer Ticker at ", tick, " msec")
-	ticker := time.NewTicker(tick)
-
-	go func() {
-		for t := range ticker.C {
-			//Call the periodic function here.
-			periodicFunc(t)
-		}
-	}()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	//================================================================================
 	// START CONSOLE:
 	//================================================================================
-	StartConsole(ConsoleInput)
-
-	//================================================================================
-	// RECEIVE AND PROCESS MESSAGES: Control Plane msgs, and Commands from console
-	// Note that this software is implemented as FSM with run to completion
-	//================================================================================
-	for {
-		select {
-		case UnicastMsg := <-M3.Channels.UnicastRcvCtrlChannel:
-			fmt.Println(M3.M3TerminalName, "MAIN: Unicast MSG in state", M3.M3TerminalState, "MSG=", string(UnicastMsg))
-			// these include text messages from the ground/controller
-			ControlPlaneMessages(UnicastMsg)
-		case BroadcastMsg := <-M3.Channels.BroadcastRcvCtrlChannel:
-			//fmt.Println(M3.M3Name, "MAIN: Broadcast MSG in state", M3.M3State, "MSG=",string(BroadcastMsg))
-			// these include text messages from the ground/controller
-			ControlPlaneMessages(BroadcastMsg)
-		case MulticastMsg := <-M3.Channels.MulticastRcvCtrlChannel:
-			//fmt.Println(M3.M3Name, "MAIN: Multicast MSG in state", M3.M3State, "MSG=",string(MulticastMsg))
-			// these include text messages from the ground/controller
-			ControlPlaneMessages(MulticastMsg)
-		case CmdText, ok := <-ConsoleInput: // These are messsages from local M3 console
-			fmt.Println("====> MAIN: Console Input: ", CmdText)
-
-			if !ok {
-				fmt.Println("ERROR Reading input from stdin:", CmdText)
-				break
-			} else {
-				// fmt.Println("Read input from stdin:", CmdText)
-				// LocalCommandMessages(CmdText)
-				// SendTextMsg(stdin)
-				// fmt.Println("Console input sent to ground");
-				switch string(CmdText[0]) { // switch on console command
-				case "status":
-					fmt.Println("STATUS REPLY: Name=", M3.M3TerminalName, " State=", M3.M3TerminalState)
-				}
-
-			}
-			//default:
-			//	fmt.Println("done and exit select")
-		} // EndOfSelect
-	} // EndOfFOR
-
-	// common.ControlPlaneCloseConnections(M3.Connectivity)
-	// os.Exit(0)
-}
-
-// ControlPlaneMessages ====================================================================================
-// ControlPlaneMessages() - handle Control Plane messages
-//====================================================================================
-func ControlPlaneMessages(message []byte) {
-	msg := new(common.Msg)
-	err1 := common.TBunmarshal(message, &msg)
-	if err1 != nil {
-		println("Error unmarshalling message: ", msg.MsgHeader.MsgCode)
-		return
-	}
-	msgHeader := &msg.MsgHeader
-	sender := msgHeader.SrcId
-	//msglen := len(message)
-	fmt.Println("ControlPlaneMessages: Msg=", msg) //.MsgCode, " msglen=", msglen)
-
-	// Was this msg originated by us ?
-	if strings.Contains(msgHeader.SrcIP, M3.M3TerminalIP) && sender == M3.M3TerminalId {
-		// println("My own message: MsgCode=", msgHeader.MsgCode, " M3.NodeId=", M3.NodeId)
-		return
-	}
-	//============================================================================
-	// Is the other side within the RF range ?
-	// we need to do this for ethernet connectivity as we receive everything
-	//============================================================================
-	// First check that the senders id is in valid range
-	if sender == M3.M3TerminalId || sender < 1 || sender > 5 {
-		println("Sender id WRONG: ", sender, " MsgCode=", msgHeader.MsgCode)
-		return
-	}
-	//node 	:= &M3.NodeList[sender -1]
-	fmt.Println("CHECK MESSAGE CODE ", msgHeader.MsgCode)
-	switch msgHeader.MsgCode {
-	case common.MSG_TYPE_DISCOVERY: // from another M3
-		var discoveryMsg = new(common.MsgCodeDiscovery)
-		err := common.TBunmarshal(message, discoveryMsg) //message
-		if err != nil {
-			println("ControlPlaneMessages: ERR=", err)
-			return
-		}
-		ControlPlaneProcessDiscoveryMessage(msgHeader, &discoveryMsg.MsgDiscovery)
-		break
-	case common.MSG_TYPE_GROUND_INFO: // info from ground
-		// TODO: will require some rethinking how to handle
-		// TODO: may need to rebroadcast for nodes that aare out of range
-		// Note that in order to cure the situation where a node might have been out of reach
-		// at the time the STEP message was sent, GROUND will insert the latest value for
-		//the StepMode in all GROUNDINFO messages .... but we need to process those ...
-		handleGroundInfoMsg(msgHeader)
-		break
-	case common.MSG_TYPE_STATUS_REQ: // command from ground
-		handleGroundStatusRequest(msgHeader)
-		break
-	case "UPDATE":
-		break
-	default:
-		fmt.Println("ControlPlaneMessages:  UNKNOWN Message")
-		break
-	}
-}
-
-//====================================================================================
-// ControlPlaneMessage STATUS REQ
-//====================================================================================
-func handleGroundStatusRequest(msgHeader *common.MessageHeader) {
-	fmt.Println("...... STATUS REQUEST: srcIP=", msgHeader.SrcIP, " SrcMAC=", msgHeader.SrcMAC,
-		" DstID=", msgHeader.DstId, " SrcID=", msgHeader.SrcId)
-
-	// REPLY
-	sendUnicastStatusReplyPacket(msgHeader)
-}
-
-//====================================================================================
-// ControlPlaneMessage   GROUNDINFO
-//====================================================================================
-func handleGroundInfoMsg(msgHeader *common.MessageHeader) {
-	var err error
-	// TODO  ... add to msg the playing field size .... hmmm ?? relation to random etc
-	M3.GroundFullName.Name = msgHeader.SrcName //.DstName
-	M3.GroundIP = msgHeader.SrcIP
-	M3.GroundIPandPort = string(msgHeader.SrcIP) + ":" + msgHeader.SrcPort
-	myPort, _ := strconv.Atoi(msgHeader.SrcPort)
-	M3.GroundUdpPort = myPort
-	M3.GroundIsKnown = true //msg.GroundUp
-
-	// M3.GroundUdpAddrSTR.IP = msg.TxIP;
-	//fmt.Println(TERMCOLOR, "... GROUNDINFO: Name=", M3.GroundFullName.Name,
-	//	"  IP:Port=", M3.GroundIPandPort, " StepMode=", msgHeader.StepMode)
-	// fmt.Println("Port=", msg.TxPort, " txIP=", msg.TxIP, " " +
-	//	"groundIP=",M3.GroundIP)
+	StartConsole(ctx, ConsoleInput)
 
-	M3.GroundUdpAddrSTR, err = net.ResolveUDPAddr("udp", M3.GroundIPandPort)
-	//M3.GroundUdpAddrSTR.Port = msg.TxPort
-	//M3.GroundUdpAddrSTR.IP   = net.IP(msg.TxIP)
-	//M3.GroundUdpAddrSTR.IP 			 = net.IP((M3.GroundIP))
-	//M3.GroundUdpAddrSTR.Port, _ 	 = strconv.Atoi(M3.GroundUdpPort)
-	//M3.GroundUdpAddrSTR.IP	 = M3.GroundIP // net.IP(M3.GroundIP)
-
-	//fmt.Println("1 M3.GroundUdpAddrSTR=", M3.GroundUdpAddrSTR)
-	myPort, _ = strconv.Atoi(msgHeader.DstPort)
-	M3.GroundUdpAddrSTR = &net.UDPAddr{IP: net.ParseIP(msgHeader.DstIP), Port: myPort}
-	M3.GroundFullName = common.NameId{Name: msgHeader.DstName, Address: *M3.GroundUdpAddrSTR}
-	//fmt.Println("2 M3.GroundUdpAddrSTR=", M3.GroundUdpAddrSTR)
-
-	if err != nil {
-		fmt.Println("ERROR in net.ResolveUDPAddr = ", err)
-		fmt.Println("ERROR locating master, will retry")
-		return
-	} else {
-		// fmt.Println("GROUND INFO: Name=", M3.GroundFullName.Name, "  IP:Port=", M3.GroundIPandPort)
-	}
-}
-
-// ControlPlaneProcessDiscoveryMessage ===============================================
-// Handle DISCOVERY messages in all states
-//====================================================================================
-func ControlPlaneProcessDiscoveryMessage(msgHeader *common.MessageHeader,
-	discoveryMsg *common.DiscoveryMsgBody) {
-	//fmt.Println("Discovery MSG in state ", M3.M3State)
-	switch M3.M3TerminalState {
-	case StateDown:
-		stateConnectedDiscoveryMessage(msgHeader, discoveryMsg)
-		break
-	case StateConnecting:
-		stateConnectedDiscoveryMessage(msgHeader, discoveryMsg)
-		break
-	case StateConnected:
-		stateConnectedDiscoveryMessage(msgHeader, discoveryMsg)
-		break
-	default:
-	}
-}
-
-//==========================================================================
-// Me=0, M1=1, M2=2..5
-//===========================================================================
-func stateConnectedDiscoveryMessage(msgHeader *common.MessageHeader,
-	discoveryMsg *common.DiscoveryMsgBody) {
-	sender := msgHeader.SrcId
-	// TODO ... make sure we only handle configured M1 and M2s
-	if sender < 1 || sender > 5 {
-		// fmt.Println("DISCARD MSG: invalid senderId=", sender)
-		return
-	}
-	term := &M3.Terminal[sender-1]
-
-	// update info for the sending terminal
-	term.TerminalName = msgHeader.SrcName
-	term.TerminalId = msgHeader.SrcId
-	term.TerminalIP = msgHeader.SrcIP
-	term.TerminalMac = msgHeader.SrcMAC
-	term.TerminalPort = msgHeader.SrcPort
-	term.TerminalNextMsgSeq = msgHeader.SrcSeq
-	// Check if terminal was rebooted
-	term.TerminalTimeCreated = discoveryMsg.TimeCreated // incarnation #
-	term.TerminalLastChangeTime = discoveryMsg.LastChangeTime
-	term.TerminalActive = discoveryMsg.NodeActive
-	term.TerminalMsgsSent = discoveryMsg.MsgsSent
-	term.TerminalMsgsRcvd = discoveryMsg.MsgsRcvd
-	term.TerminalMsgLastSentAt = discoveryMsg.MsgLastSentAt
-	term.TerminalMsgLastRcvdAt = time.Now() // TBtimestampNano() // time.Now()
-
-	term.TerminalActive = true
-
-	if M3.GroundIsKnown {
-		// did this DISCOVERY reach the ground as well
-		/*
-			if node.NodeDistanceToGround > M3.GroundRadioRange {
-				fmt.Println("=== NEED TO FORWARD, OTHER NODE ", int(node.NodeDistanceToGround),
-					" AWAY FROM GROUND, Ground at ", M3.GroundRadioRange)
-				theNode, distance := FindShortestConnectionToGround()
-				fmt.Println("====== Me=", M3.NodeId, " MY DISTANCE=",M3.NodeDistanceToGround,
-					" HIS DISTANCE=", node.NodeDistanceToGround," SHORTEST=", distance,
-					" Forwarder=", theNode.NodeId)
-				if Me == theNode && M3.NodeDistanceToGround <= M3.GroundRadioRange {
-					fmt.Println("====== I AM THE FORWARDER ===========================")
-					// forward the DISCOVERY as UNICAST to GROUND
-					forwardUnicastDiscoveryPacket(msgHeader, discoveryMsg, int(distance))
-				}
-			} else {
-				//fmt.Println("==NODE ", node.NodeId, " CAN REACH GROUND AT",node.DistanceToGround ,
-				//	" Ground at ", M3.GroundRadioRange)
-			}
-		*/
-	}
-}
-
-/*
-func FindShortestConnectionToGround() (*common.NodeInfo, float64) {
-	var theNode *common.NodeInfo = nil
-	var distance float64 = 1000000000
-	for j:=0; j<64; j++ {
-		if M3.NodeList[j].NodeActive == true {
-			if distance > M3.NodeList[j].NodeDistanceToGround {
-				distance = M3.NodeList[j].NodeDistanceToGround
-				theNode = &M3.NodeList[j]
-			}
-		}
-	}
-	return theNode, distance
-}
-
-//====================================================================================
-// Handle messages received in the CONNECTED state
-//====================================================================================
-func RemoteCommandMessages(msg *common.Msg) {
-	var cmds []common.LinuxCommand
-	// _ = TBunmarshal(msg.MsgBody, &cmds)
-
-	for cmdIndex := range cmds {
-		var cmd common.LinuxCommand
-		cmd = cmds[cmdIndex]
-		err := RunLinuxCommand(REMOTE_CMD, cmd.Cmd, cmd.Par1, cmd.Par2, cmd.Par3, cmd.Par4, cmd.Par5, cmd.Par6)
-		if err != nil {
-			fmt.Printf("%T\n", err)
-		}
-	}
-}
-*/
-//=======================================================================
-//
-//=======================================================================
-func LocalCommandMessages(cmdText string) {
-	//var cmd []string
-	//cmd = strings.Split(cmdText, " ")
-
-	switch cmdText {
-	case "enable":
-		M3.M3TerminalActive = true
-	case "disable":
-		M3.M3TerminalActive = false
-	default:
-	}
-	fmt.Println("RCVD CONSOLE INPUT =", cmdText, " M3 ACTIVE=", M3.M3TerminalActive)
-	// TODO figure out the bellow line
-	//err := RunLinuxCommand(LOCAL_CMD, cmd[0], cmd[1], cmd[2], cmd[3], cmd[4], cmd[5], cmd[6])
-	//if err != nil {fmt.Printf("%T\n", err)}
-
-}
-
-// RunLinuxCommand ========================================================
-//
-//=======================================================================
-func RunLinuxCommand(origin, Cmd, Par1, Par2, Par3, Par4, Par5, Par6 string) error {
-	//fmt.Println("RCVD CMD ", cmdIndex, " =",cmd)
-	// fmt.Println("cmd=", cmd.Cmd, " ", cmd.Par1, " ", cmd.Par2, " ", cmd.Par3, " ", cmd.Par4, " ", cmd.Par5, " ", cmd.Par6)
-	//cmd.Output() → run it, wait, get output
-	//cmd.Run() → run it, wait for it to finish.
-	//cmd.Start() → run it, don't wait. err = cmd.Wait() to get result.
-	var thisCmd = exec.Command(Cmd, Par1, Par2, Par3, Par4, Par5, Par6)
-	output, err := thisCmd.Output()
-	//if err != nil && err.Error() != "exit status 1" {
-	//	fmt.Println("CMDx=", cmd.Cmd, " ", cmd.Par1, " ", cmd.Par2, " ", cmd.Par3, " ", cmd.Par4,
-	//		" ", cmd.Par5, " ", cmd.Par6, " :  cmd.Run() failed with ", err)
-	//} else {
-	//if err != nil && err.Error() != "exit status 1" {
-	//	//panic(err)
-	//	//fmt.Printf("ERROR=", err, "\n")
-	//	fmt.Printf("%T\n", err)
-	//} else {
-	//	fmt.Printf("CMD OUTPUT=",string(output))
-	//	// SEND REEPLY, OR MAYBE COMBINED ALL FIRST
+	// TODO: Make this work later
+	//if M3.GroundIsKnown == true {
+	//	fmt.Println(M3.Name, ":",M3.FullName, "INIT: GROUND LOCATED")
+	//	changeState(StateConnected)
+	//	M3.KeepAliveRcvdTime = time.Now()
 	//}
-	fmt.Println(origin, " CMD= ", Cmd, " ", Par1, Par2, " ", Par3, " ", Par4,
-		" ", Par5, " ", Par6, " :  RESULT:", string(output), "  ERR:", err)
-	return err
-}
-
-//====================================================================================
-//  Set new state
-//====================================================================================
-func changeState(newState string) {
-	fmt.Println(M3.M3TerminalName, "OldState=", M3.M3TerminalState, " NewState=", newState)
-	M3.M3TerminalState = newState
-}
-
-//====================================================================================
-//  Format and send DISCOVERY msg
-//====================================================================================
-func sendBroadcastDiscoveryPacket() {
-	// ... done in updateConnectivity ...MoveNode(Me)
-	//M3.TerminalMsgLastSentAt = float64(common.TBtimestampNano()) // time.Now() //TBtimestampNano()
-	//strconv.FormatInt(TBtimestampNano(), 10)
-
-	dstPort := M3.Connectivity.BroadcastTxPort
-	// TODO add heightFromEarthCenter as configuration ?? what if it wobbles?
-	// timeNow := tiM3.Now()
-	msgHdr := common.MessageHeader{
-		MsgCode:  "DISCOVERY",
-		Ttl:      3,
-		TimeSent: float64(common.TBtimestampNano()), // timeNow, //M3.MsgLastSentAt, // time.Now().String()
-		SrcSeq:   M3.M3TerminalNextMsgSeq,
-		SrcMAC:   M3.M3TerminalMac,
-		SrcName:  M3.M3TerminalName,
-		SrcId:    M3.M3TerminalId, // node ids are 1 based
-		SrcIP:    M3.M3TerminalIP,
-		SrcPort:  M3.M3TerminalPort,
-		DstName:  "BROADCAST",
-		DstId:    0,
-		DstIP:    M3.Connectivity.BroadcastTxIP,
-		DstPort:  dstPort,
-		Hash:     0,
-	}
-	discBody := common.DiscoveryMsgBody{
-		NodeActive: M3.M3TerminalActive,
-		MsgsSent:   M3.M3TerminalMsgsSent,
-		MsgsRcvd:   M3.M3TerminalMsgsRcvd,
-	}
-	myMsg := common.MsgCodeDiscovery{
-		MsgHeader:    msgHdr,
-		MsgDiscovery: discBody,
-	}
-	M3.M3TerminalNextMsgSeq++
-	msg, _ := common.TBmarshal(myMsg)
-
-	// fmt.Println(" BROADCAST DISCOVERY", M3.BroadcastTxStruct)
-	common.ControlPlaneBroadcastSend(M3.Connectivity, msg, M3.Connectivity.BroadcastTxStruct)
-
-}
-
-//=================================================================================
-//=================================================================================
-func sendUnicastStatusReplyPacket(msgHeader *common.MessageHeader) {
-	fmt.Println("...... STATUS REPLY: srcIP=", msgHeader.SrcIP, " SrcMAC=", msgHeader.SrcMAC,
-		" DstID=", msgHeader.DstId, " SrcID=", msgHeader.SrcId)
-
-	// port, _ :=strconv.Atoi(M3.UnicastTxPort)
-	// latitude,longitude := ConvertXYZtoLatLong(M3.MyX, M3.MyY, M3.MyZ, orbitHeightFromEartCenter)
-	msgHdr := common.MessageHeader{
-		MsgCode:  "STATUS_REPLY",
-		Ttl:      1,
-		TimeSent: float64(common.TBtimestampNano()),
-		SrcSeq:   M3.M3TerminalNextMsgSeq,
-		SrcMAC:   M3.M3TerminalMac,
-		SrcName:  M3.M3TerminalName,
-		SrcId:    M3.M3TerminalId, // node ids are 1 based
-		SrcIP:    M3.M3TerminalIP,
-		SrcPort:  M3.M3TerminalPort,
-		DstName:  "UNICAST",
-		DstId:    msgHeader.SrcId,
-		DstIP:    msgHeader.SrcIP,
-		DstPort:  msgHeader.SrcPort,
-		Hash:     0,
-	}
-
-	statusReplyBody := common.StatusReplyMsgBody{
-		//TimeCreated:	M3.TerminalTimeCreated,
-		LastChangeTime: M3.TerminalLastChangeTime,
-		NodeActive:     M3.M3TerminalActive,
-		MsgsSent:       M3.M3TerminalMsgsSent,
-		MsgsRcvd:       M3.M3TerminalMsgsRcvd,
-		//MsgLastSentAt:	 M3.TerminalMsgLastSentAt,
-	}
-
-	myMsg := common.MsgCodeStatusReply{
-		MsgHeader:      msgHdr,
-		MsgStatusReply: statusReplyBody,
-	}
-	M3.M3TerminalNextMsgSeq++
-	msg, _ := common.TBmarshal(myMsg)
-
-	common.ControlPlaneUnicastSend(M3.Connectivity, msg, M3.GroundIP+":"+M3.Connectivity.UnicastTxPort)
-}
-
-//=================================================================================
-//=================================================================================
-func sendUnicastDiscoveryPacket(unicastIP string) {
-	M3.M3TerminalMsgLastSentAt = float64(common.TBtimestampNano()) //time.Now()
-
-	port := M3.Connectivity.UnicastTxPort //   strconv.Atoi(M3.Connectivity.UnicastTxPort)
-	// latitude,longitude := ConvertXYZtoLatLong(M3.MyX, M3.MyY, M3.MyZ, orbitHeightFromEartCenter)
-	msgHdr := common.MessageHeader{
-		MsgCode:  "DISCOVERY",
-		Ttl:      3,
-		TimeSent: float64(common.TBtimestampNano()), // timeNow, //M3.MsgLastSentAt, // time.Now().String()
-		SrcSeq:   M3.M3TerminalNextMsgSeq,
-		SrcMAC:   M3.M3TerminalMac,
-		SrcName:  M3.M3TerminalName,
-		SrcId:    M3.M3TerminalId, // node ids are 1 based
-		SrcIP:    M3.M3TerminalIP,
-		SrcPort:  M3.M3TerminalPort,
-		DstName:  "UNICAST",
-		DstId:    0,
-		DstIP:    M3.Connectivity.BroadcastTxIP,
-		DstPort:  port,
-		Hash:     0,
-	}
-
-	discBody := common.DiscoveryMsgBody{
-		NodeActive: M3.M3TerminalActive,
-		MsgsSent:   M3.M3TerminalMsgsSent,
-		MsgsRcvd:   M3.M3TerminalMsgsRcvd,
-	}
-	myMsg := common.MsgCodeDiscovery{
-		MsgHeader:    msgHdr,
-		MsgDiscovery: discBody,
-	}
-	M3.M3TerminalNextMsgSeq++
-	msg, _ := common.TBmarshal(myMsg)
-
-	//fmt.Println( "SEND UNICAST DISCOVERY to ", unicastIP)
-	common.ControlPlaneUnicastSend(M3.Connectivity, msg, unicastIP)
+	err := Node.Start(ctx, ConsoleInput)
+	checkErrorNode(err)
+	<-Node.Done()
 }
 
 //====================================================================================